@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+)
+
+const (
+	// apiPageLimit is the page size GetAll reads items with, the largest
+	// the server accepts.
+	apiPageLimit = 1000
+	// releaseTimeout bounds the release of a snapshot whose pages were not
+	// all read.
+	releaseTimeout = 5 * time.Second
+)
+
+// Reader reads items for the typed Get and GetAll, and for getItem and
+// getAllItems in the shell. API reads them through the HTTP API of a
+// server; other implementations can read them from wherever the items are
+// available to the client.
+type Reader interface {
+	// Get returns the item of key in namespace, and whether it exists.
+	Get(ctx context.Context, namespace, key string) (replication.Item, bool, error)
+	// GetAll returns every item of namespace in order.
+	GetAll(ctx context.Context, namespace string) ([]replication.Item, error)
+}
+
+// API reads items through the HTTP API of a server. Reads take effect when
+// the server receives them, so they do not wait for commands that are
+// still in the queue. The server verifies and authorizes them like the
+// messages of the client, so an API should identify the client the same
+// way.
+type API struct {
+	base   string
+	http   *http.Client
+	id     string
+	signer *auth.Signer
+}
+
+// APIOption configures optional behaviour of an API.
+type APIOption func(*API)
+
+// WithAPIClientID names the client in the headers of its requests.
+func WithAPIClientID(id string) APIOption {
+	return func(a *API) {
+		a.id = id
+	}
+}
+
+// WithAPISigner signs every request with s. The client is then identified
+// by the key's client instead of WithAPIClientID.
+func WithAPISigner(s *auth.Signer) APIOption {
+	return func(a *API) {
+		a.signer = s
+	}
+}
+
+// NewAPI returns an API for the server at addr, either a URL or a
+// host:port address.
+func NewAPI(addr string, opts ...APIOption) *API {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	a := &API{
+		base: strings.TrimRight(addr, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Get returns the item of key in namespace, and whether it exists.
+func (a *API) Get(ctx context.Context, namespace, key string) (replication.Item, bool, error) {
+	var item replication.Item
+	command := types.NewGetCommand(key).WithNamespace(namespace)
+	status, err := a.do(ctx, http.MethodGet, "/items/"+url.PathEscape(key), url.Values{"namespace": {namespace}}, command, &item)
+	if status == http.StatusNotFound {
+		return replication.Item{}, false, nil
+	}
+	if err != nil {
+		return replication.Item{}, false, err
+	}
+	item.Namespace = namespace
+	return item, true, nil
+}
+
+// GetAll returns every item of namespace in order. The items are read in
+// pages of one snapshot of the map, which the server releases with the
+// last page. If a page cannot be read, GetAll releases the snapshot.
+func (a *API) GetAll(ctx context.Context, namespace string) (_ []replication.Item, err error) {
+	var items []replication.Item
+	query := url.Values{"namespace": {namespace}, "limit": {strconv.Itoa(apiPageLimit)}}
+	command := types.NewGetAllCommand().WithNamespace(namespace)
+	defer func() {
+		if snapshot := query.Get("snapshot"); err != nil && snapshot != "" {
+			a.release(ctx, namespace, snapshot)
+		}
+	}()
+	for {
+		var page struct {
+			Items    []replication.Item `json:"items"`
+			Snapshot string             `json:"snapshot"`
+			Next     *int               `json:"next"`
+		}
+		if _, err := a.do(ctx, http.MethodGet, "/items", query, command, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			item.Namespace = namespace
+			items = append(items, item)
+		}
+		if page.Next == nil {
+			return items, nil
+		}
+		query.Set("snapshot", page.Snapshot)
+		query.Set("offset", strconv.Itoa(*page.Next))
+	}
+}
+
+// release releases a snapshot, even if ctx is done. Errors are ignored,
+// since the server expires unused snapshots.
+func (a *API) release(ctx context.Context, namespace, snapshot string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	var v interface{}
+	query := url.Values{"namespace": {namespace}, "snapshot": {snapshot}}
+	_, _ = a.do(ctx, http.MethodDelete, "/items", query, types.NewCloseSnapshotCommand(snapshot).WithNamespace(namespace), &v)
+}
+
+// do sends a request for path, which the server authorizes as command,
+// decodes the JSON response into v and returns its status code.
+func (a *API) do(ctx context.Context, method, path string, query url.Values, command types.Command, v interface{}) (int, error) {
+	if query.Get("namespace") == "" {
+		query.Del("namespace")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.base+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	headers := make(map[string]string)
+	if a.id != "" {
+		headers[queue.ClientIDHeader] = a.id
+	}
+	if a.signer != nil {
+		if err := a.signer.Sign(command.String(), headers); err != nil {
+			return 0, err
+		}
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, e.Error)
+	}
+	// Numbers, such as counters, keep their digits.
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return resp.StatusCode, decoder.Decode(v)
+}
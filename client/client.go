@@ -5,27 +5,209 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"command-queue/internal/types"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/metrics"
 	"command-queue/internal/util/queue"
+	"command-queue/internal/util/tracing"
 )
 
 type Client struct {
 	inputSource io.Reader
 	queue       queue.Queue
+	metrics     *clientMetrics
+	tracer      *tracing.Tracer
+	log         logger.Logger
+	id          string
+	namespace   string
+	signer      *auth.Signer
+	errorMode   ErrorMode
+	retries     int
+	retryDelay  time.Duration
+	rejected    io.Writer
+	reader      Reader
+	workers     int
+	partition   bool
+	progress    io.Writer
+	interval    time.Duration
+
+	summaryMutex sync.Mutex
+	summary      Summary
+}
+
+// Option configures optional behaviour of a Client.
+type Option func(*Client)
+
+// WithTracer traces every send with t and passes the trace context to the
+// server in the message headers.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(c *Client) {
+		c.tracer = t
+	}
+}
+
+// WithClientID names the client in the headers of its messages, for the
+// server's audit log.
+func WithClientID(id string) Option {
+	return func(c *Client) {
+		c.id = id
+	}
+}
+
+// WithNamespace makes the commands sent by the client apply to namespace,
+// unless they name one themselves.
+func WithNamespace(namespace string) Option {
+	return func(c *Client) {
+		c.namespace = namespace
+	}
+}
+
+// WithSigner signs every message with s. The client is then identified by
+// the key's client instead of WithClientID.
+func WithSigner(s *auth.Signer) Option {
+	return func(c *Client) {
+		c.signer = s
+	}
+}
+
+// WithErrorMode sets what Start does with lines that do not parse or
+// cannot be sent. The default is FailFast.
+func WithErrorMode(mode ErrorMode) Option {
+	return func(c *Client) {
+		c.errorMode = mode
+	}
 }
 
-func NewClient(inputSource io.Reader, queue queue.Queue) *Client {
-	return &Client{
+// WithRetries sets how often RetryThenSkip retries a failed send, and the
+// delay before the first retry.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryDelay = delay
+	}
+}
+
+// WithRejectedLines writes the lines that Start does not send to w, one per
+// line, so that they can be fixed and sent again.
+func WithRejectedLines(w io.Writer) Option {
+	return func(c *Client) {
+		c.rejected = w
+	}
+}
+
+// WithReader reads items through r: the typed Get and GetAll, and getItem
+// and getAllItems in the shell. Without a Reader the client only sends
+// commands.
+func WithReader(r Reader) Option {
+	return func(c *Client) {
+		c.reader = r
+	}
+}
+
+// WithAPI reads items through the HTTP API of a server, like WithReader.
+func WithAPI(api *API) Option {
+	return WithReader(api)
+}
+
+// WithWorkers makes Start send lines with n concurrent workers. With
+// partition, all lines with the same key are sent by the same worker, in
+// the order of the input.
+func WithWorkers(n int, partition bool) Option {
+	return func(c *Client) {
+		c.workers = n
+		c.partition = partition
+	}
+}
+
+// WithProgress writes the number of sent, invalid and failed lines and the
+// current throughput to w every interval while Start runs.
+func WithProgress(w io.Writer, interval time.Duration) Option {
+	return func(c *Client) {
+		c.progress = w
+		c.interval = interval
+	}
+}
+
+// WithLogger logs the commands the client sends to log.
+func WithLogger(log logger.Logger) Option {
+	return func(c *Client) {
+		c.log = log
+	}
+}
+
+// NewClient creates a client that sends commands to queue. Start reads them
+// from inputSource, which may be nil for clients that only use the typed
+// API (Add, Delete, Do, Get and GetAll). The typed API is safe for
+// concurrent use.
+func NewClient(inputSource io.Reader, queue queue.Queue, opts ...Option) *Client {
+	c := &Client{
 		inputSource: inputSource,
 		queue:       queue,
+		metrics:     newClientMetrics(),
+		log:         logger.NewConsoleLogger(),
+		errorMode:   FailFast,
+		retries:     DefaultRetries,
+		retryDelay:  DefaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.signer != nil {
+		// Messages are signed once every other header is set.
+		c.queue = auth.NewSignedQueue(c.queue, c.signer)
+	}
+	c.log = c.log.Component("client")
+	return c
+}
+
+// clientMetrics count the commands a client sends.
+type clientMetrics struct {
+	registry *metrics.Registry
+	sent     *metrics.CounterVec
+	errors   *metrics.CounterVec
+	latency  *metrics.HistogramVec
+}
+
+func newClientMetrics() *clientMetrics {
+	r := metrics.NewRegistry()
+	return &clientMetrics{
+		registry: r,
+		sent: r.NewCounterVec("commandqueue_client_sent_total",
+			"Commands sent to the queue by type.", "type"),
+		errors: r.NewCounterVec("commandqueue_client_errors_total",
+			"Commands that could not be sent by reason.", "reason"),
+		latency: r.NewHistogramVec("commandqueue_client_send_seconds",
+			"Time to send a command to the queue.", metrics.DefBuckets, "type"),
 	}
 }
 
+// Metrics returns the registry of the client's metrics.
+func (c *Client) Metrics() *metrics.Registry {
+	return c.metrics.registry
+}
+
+// Start sends the commands of the input source, one per line, until the
+// input ends or ctx is done. The error mode decides whether a line that
+// does not parse or cannot be sent stops it; Summary reports the outcome.
+// With several workers the lines are sent concurrently.
 func (c *Client) Start(ctx context.Context) error {
+	if c.progress != nil && c.interval > 0 {
+		stop := c.reportProgress()
+		defer stop()
+	}
+	if c.workers > 1 {
+		return c.startWorkers(ctx)
+	}
+
 	// Read commands from the input source and send them to the server.
 	scanner := bufio.NewScanner(c.inputSource)
+	line := 0
 	for scanner.Scan() {
+		line++
 		select {
 		case <-ctx.Done():
 			// Context cancelled. Stop processing.
@@ -33,15 +215,16 @@ func (c *Client) Start(ctx context.Context) error {
 		default:
 			str := scanner.Text()
 
-			_, err := types.ParseCommand(str)
+			command, err := types.ParseCommand(str)
 			if err != nil {
-				return fmt.Errorf("error creating command %v", err)
+				c.metrics.errors.With("parse").Inc()
+				if err := c.reject(LineError{Line: line, Text: str, Err: err}, true); err != nil {
+					return err
+				}
+				continue
 			}
-			// check if command is valid or not
-
-			err = c.queue.SendMessage(str)
-			if err != nil {
-				return fmt.Errorf("error sending command %v", err)
+			if err := c.process(ctx, job{line: line, text: str, command: command}); err != nil {
+				return err
 			}
 		}
 	}
@@ -53,6 +236,53 @@ func (c *Client) Start(ctx context.Context) error {
 	return nil
 }
 
+// process sends the command of a line and records the outcome. It returns
+// the error that stops Start, if any.
+func (c *Client) process(ctx context.Context, j job) error {
+	if err := c.sendLine(ctx, j.command, j.text); err != nil {
+		return c.reject(LineError{Line: j.line, Text: j.text, Err: err}, false)
+	}
+	c.sent()
+	c.log.Debug("Sent command", logger.KeyCommandType, string(j.command.Type), "line", j.line)
+	return nil
+}
+
+// sendCommand sends the message of command with the extra headers and
+// counts it.
+func (c *Client) sendCommand(ctx context.Context, command types.Command, message string, extra map[string]string) error {
+	start := time.Now()
+	err := c.send(ctx, command, message, extra)
+	c.metrics.latency.With(string(command.Type)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.metrics.errors.With("send").Inc()
+		return err
+	}
+	c.metrics.sent.With(string(command.Type)).Inc()
+	return nil
+}
+
+// send sends message in a span whose context travels with the message.
+func (c *Client) send(ctx context.Context, command types.Command, message string, extra map[string]string) error {
+	ctx, span := c.tracer.Start(ctx, "send", tracing.KindProducer)
+	defer span.End()
+	span.SetAttribute("command.type", string(command.Type))
+
+	headers := make(map[string]string, len(extra)+4)
+	for key, value := range extra {
+		headers[key] = value
+	}
+	if c.id != "" {
+		headers[queue.ClientIDHeader] = c.id
+	}
+	if c.namespace != "" {
+		headers[queue.NamespaceHeader] = c.namespace
+	}
+	tracing.Inject(ctx, headers)
+	err := queue.Send(c.queue, message, headers)
+	span.SetError(err)
+	return err
+}
+
 // Stop stops the client, preventing it from sending further commands to the server.
 func (c *Client) Stop() error {
 	// Perform any cleanup or shutdown logic here.
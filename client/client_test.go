@@ -3,11 +3,22 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"command-queue/internal/types"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/logger"
 	"command-queue/internal/util/queue"
+	"command-queue/internal/util/tracing"
 )
 
 func TestClient_Start(t *testing.T) {
@@ -27,7 +38,8 @@ func TestClient_Start(t *testing.T) {
 	ctx := context.Background()
 
 	// Create a client with memQueue and input buffer
-	c := NewClient(inputBuffer, memQ)
+	log := logger.NewTestLogger()
+	c := NewClient(inputBuffer, memQ, WithLogger(log))
 
 	// Start the client
 	err := c.Start(ctx)
@@ -51,4 +63,188 @@ func TestClient_Start(t *testing.T) {
 	default:
 		// No message in the channel, as expected
 	}
+
+	entry, ok := log.Find("Sent command")
+	assert.True(t, ok)
+	assert.Equal(t, "client", entry.Fields[logger.KeyComponent])
+	assert.Equal(t, int64(1), entry.Fields["line"])
+	assert.Len(t, log.Entries(), len(inputCommands))
+
+	var sb strings.Builder
+	assert.Nil(t, c.Metrics().WriteText(&sb))
+	assert.Contains(t, sb.String(), `commandqueue_client_sent_total{type="addItem"} 1`+"\n")
+	assert.Contains(t, sb.String(), `commandqueue_client_send_seconds_count{type="getAllItems"} 1`+"\n")
+}
+
+func TestClient_Tracing(t *testing.T) {
+	memQ := queue.NewMemQueue(1)
+	exporter, err := tracing.NewFileExporter(filepath.Join(t.TempDir(), "spans"))
+	assert.Nil(t, err)
+	tracer := tracing.NewTracer("client", exporter, nil)
+	defer tracer.Close()
+
+	c := NewClient(bytes.NewBufferString("getItem('a')\n"), memQ, WithTracer(tracer))
+	assert.Nil(t, c.Start(context.Background()))
+
+	// Queues without headers carry the trace context in an envelope.
+	messages, _ := memQ.ReceiveMessage()
+	message := queue.Unwrap(<-messages)
+	assert.Equal(t, "getItem('a')", message.Body)
+	sc, err := tracing.ParseTraceparent(message.Headers[tracing.TraceparentHeader])
+	assert.Nil(t, err)
+	assert.True(t, sc.IsValid())
+}
+
+func TestClient_Signed(t *testing.T) {
+	memQ := queue.NewMemQueue(1)
+	key, err := auth.GenerateKey("k1", "billing", auth.Ed25519)
+	assert.Nil(t, err)
+	clientKeys, _ := auth.NewKeyring(key)
+	serverKeys, _ := auth.NewKeyring(key.Public())
+	signer, err := auth.NewSigner(clientKeys, "k1")
+	assert.Nil(t, err)
+
+	c := NewClient(bytes.NewBufferString("getItem('a')\n"), memQ, WithSigner(signer), WithClientID("admin"))
+	assert.Nil(t, c.Start(context.Background()))
+
+	// The key's client replaces the claimed ID.
+	messages, _ := memQ.ReceiveMessage()
+	client, err := auth.NewVerifier(serverKeys, auth.DefaultWindow).Verify(queue.Unwrap(<-messages))
+	assert.Nil(t, err)
+	assert.Equal(t, "billing", client)
+}
+
+// flakyQueue fails to send messages containing "fail", and the first
+// attempt of messages containing "flaky".
+type flakyQueue struct {
+	queue.Queue
+	attempts map[string]int
+}
+
+func (q *flakyQueue) SendMessage(message string) error {
+	q.attempts[message]++
+	if strings.Contains(message, "fail") || (strings.Contains(message, "flaky") && q.attempts[message] == 1) {
+		return errors.New("broker unavailable")
+	}
+	return q.Queue.SendMessage(message)
+}
+
+func TestClient_ErrorModes(t *testing.T) {
+	input := "addItem('a', '1')\naddItem('b'\naddItem('flaky', '2')\naddItem('fail', '3')\ndeleteItem('a')\n"
+	tests := []struct {
+		mode     ErrorMode
+		err      string
+		summary  string
+		rejected string
+	}{
+		{FailFast, "error creating command on line 2", "sent 1, invalid 1 (line 2), failed 0", "addItem('b'\n"},
+		{SkipInvalid, "error sending command on line 3", "sent 1, invalid 1 (line 2), failed 1 (line 3)", "addItem('b'\naddItem('flaky', '2')\n"},
+		{RetryThenSkip, "", "sent 3, invalid 1 (line 2), failed 1 (line 4)", "addItem('b'\naddItem('fail', '3')\n"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			q := &flakyQueue{Queue: queue.NewMemQueue(10), attempts: map[string]int{}}
+			var rejected bytes.Buffer
+			log := logger.NewTestLogger()
+			c := NewClient(bytes.NewBufferString(input), q, WithLogger(log), WithErrorMode(test.mode),
+				WithRetries(2, time.Millisecond), WithRejectedLines(&rejected))
+			err := c.Start(context.Background())
+			if test.err == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+			assert.Equal(t, test.summary, c.Summary().String())
+			assert.Equal(t, test.rejected, rejected.String())
+			if test.mode == RetryThenSkip {
+				assert.Equal(t, 3, q.attempts["addItem('fail', '3')"])
+				_, ok := log.Find("Skipped line that could not be sent")
+				assert.True(t, ok)
+			}
+		})
+	}
+}
+
+// recordingQueue records the messages sent to it from any goroutine.
+type recordingQueue struct {
+	queue.Queue
+	mu       sync.Mutex
+	messages []string
+}
+
+func (q *recordingQueue) SendMessage(message string) error {
+	if strings.Contains(message, "fail") {
+		return errors.New("broker unavailable")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, message)
+	return nil
+}
+
+func TestClient_Workers(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "addItem('key%d', '%d')\n", i%7, i)
+	}
+	input.WriteString("addItem('x'\n")
+
+	q := &recordingQueue{}
+	var progress bytes.Buffer
+	c := NewClient(strings.NewReader(input.String()), q, WithLogger(logger.NewTestLogger()),
+		WithErrorMode(SkipInvalid), WithWorkers(4, true), WithProgress(&progress, time.Hour))
+	assert.Nil(t, c.Start(context.Background()))
+	assert.Equal(t, "sent 200, invalid 1 (line 201), failed 0", c.Summary().String())
+	assert.True(t, strings.HasPrefix(progress.String(), "sent 200 ("), progress.String())
+	assert.True(t, strings.HasSuffix(progress.String(), "/s), invalid 1, failed 0\n"), progress.String())
+
+	// Each key keeps the order of the input.
+	assert.Len(t, q.messages, 200)
+	last := map[string]int{}
+	for _, message := range q.messages {
+		command, err := types.ParseCommand(message)
+		assert.Nil(t, err)
+		value, _ := strconv.Atoi(command.Value())
+		if previous, ok := last[command.Key()]; ok {
+			assert.Greater(t, value, previous, command.Key())
+		}
+		last[command.Key()] = value
+	}
+}
+
+func TestClient_WorkersStop(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "addItem('key%d', '%d')\n", i, i)
+	}
+	input.WriteString("addItem('fail', '1')\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "deleteItem('key%d')\n", i)
+	}
+
+	q := &recordingQueue{}
+	c := NewClient(strings.NewReader(input.String()), q, WithLogger(logger.NewTestLogger()), WithWorkers(3, false))
+	err := c.Start(context.Background())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "error sending command on line 101")
+	}
+	s := c.Summary()
+	if assert.Len(t, s.Failed, 1) {
+		assert.Equal(t, 101, s.Failed[0].Line)
+	}
+	assert.Less(t, s.Sent, 1100)
+}
+
+func TestSummary_String(t *testing.T) {
+	var s Summary
+	for i := 1; i <= 12; i++ {
+		s.Invalid = append(s.Invalid, LineError{Line: i})
+	}
+	assert.Equal(t, "sent 0, invalid 12 (lines 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, ...), failed 0", s.String())
+
+	mode, err := ParseErrorMode("skip-invalid")
+	assert.Nil(t, err)
+	assert.Equal(t, SkipInvalid, mode)
+	_, err = ParseErrorMode("ignore")
+	assert.NotNil(t, err)
 }
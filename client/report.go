@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/logger"
+)
+
+// ErrorMode decides what Client.Start does with lines it cannot send.
+type ErrorMode string
+
+const (
+	// FailFast stops at the first line that does not parse or cannot be
+	// sent.
+	FailFast ErrorMode = "fail-fast"
+	// SkipInvalid skips lines that do not parse and stops at the first line
+	// that cannot be sent.
+	SkipInvalid ErrorMode = "skip-invalid"
+	// RetryThenSkip skips lines that do not parse, retries sends that fail
+	// and skips the line when the retries fail too.
+	RetryThenSkip ErrorMode = "retry-then-skip"
+)
+
+const (
+	// DefaultRetries is the number of times RetryThenSkip retries a send.
+	DefaultRetries = 3
+	// DefaultRetryDelay is the delay before the first retry of a send. It
+	// doubles with every retry.
+	DefaultRetryDelay = 100 * time.Millisecond
+)
+
+// ParseErrorMode parses the name of an error mode.
+func ParseErrorMode(name string) (ErrorMode, error) {
+	switch mode := ErrorMode(name); mode {
+	case FailFast, SkipInvalid, RetryThenSkip:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid error mode %q (fail-fast, skip-invalid or retry-then-skip)", name)
+}
+
+// LineError is a line of the input that was not sent.
+type LineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+// Summary counts the lines of the input by outcome.
+type Summary struct {
+	Sent    int
+	Invalid []LineError
+	Failed  []LineError
+}
+
+// maxSummaryLines is the number of line numbers Summary.String lists per
+// outcome.
+const maxSummaryLines = 10
+
+// String returns a one-line summary such as
+// "sent 10, invalid 2 (lines 3, 5), failed 0".
+func (s Summary) String() string {
+	return fmt.Sprintf("sent %d, invalid %d%s, failed %d%s",
+		s.Sent, len(s.Invalid), lineNumbers(s.Invalid), len(s.Failed), lineNumbers(s.Failed))
+}
+
+func lineNumbers(errs []LineError) string {
+	if len(errs) == 0 {
+		return ""
+	}
+	numbers := make([]string, 0, maxSummaryLines+1)
+	for i, e := range errs {
+		if i == maxSummaryLines {
+			numbers = append(numbers, "...")
+			break
+		}
+		numbers = append(numbers, strconv.Itoa(e.Line))
+	}
+	if len(errs) == 1 {
+		return " (line " + numbers[0] + ")"
+	}
+	return " (lines " + strings.Join(numbers, ", ") + ")"
+}
+
+// Summary returns the outcome of the lines read by Start so far, with the
+// lines that were not sent in input order.
+func (c *Client) Summary() Summary {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+	return Summary{
+		Sent:    c.summary.Sent,
+		Invalid: byLine(c.summary.Invalid),
+		Failed:  byLine(c.summary.Failed),
+	}
+}
+
+// byLine returns a copy of errs sorted by line. Workers can fail lines out
+// of order.
+func byLine(errs []LineError) []LineError {
+	sorted := append([]LineError(nil), errs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Line < sorted[j].Line })
+	return sorted
+}
+
+// sendLine sends command, retrying failed sends in RetryThenSkip mode.
+func (c *Client) sendLine(ctx context.Context, command types.Command, message string) error {
+	retries := 0
+	if c.errorMode == RetryThenSkip {
+		retries = c.retries
+	}
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.sendCommand(ctx, command, message, nil)
+		if err == nil || attempt == retries {
+			return err
+		}
+		c.log.Warn("Retrying command", logger.KeyCommandType, string(command.Type), logger.KeyError, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// sent records a line that was sent.
+func (c *Client) sent() {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+	c.summary.Sent++
+}
+
+// reject records a line that was not sent, because it is invalid or because
+// sending it failed, writes it to the rejected lines and returns the error
+// that stops Start if the error mode does not skip it.
+func (c *Client) reject(e LineError, invalid bool) error {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+	if invalid {
+		c.summary.Invalid = append(c.summary.Invalid, e)
+	} else {
+		c.summary.Failed = append(c.summary.Failed, e)
+	}
+	if c.rejected != nil {
+		if _, err := fmt.Fprintln(c.rejected, e.Text); err != nil {
+			return fmt.Errorf("error writing rejected line %d: %v", e.Line, err)
+		}
+	}
+
+	switch {
+	case invalid && c.errorMode == FailFast:
+		return fmt.Errorf("error creating command on line %d: %v", e.Line, e.Err)
+	case !invalid && c.errorMode != RetryThenSkip:
+		return fmt.Errorf("error sending command on line %d: %v", e.Line, e.Err)
+	}
+	reason := "Skipped invalid line"
+	if !invalid {
+		reason = "Skipped line that could not be sent"
+	}
+	c.log.Warn(reason, "line", e.Line, logger.KeyError, e.Err)
+	return nil
+}
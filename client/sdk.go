@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/queue"
+)
+
+var (
+	// ErrNoAPI is returned by Get and GetAll for clients without a Reader.
+	ErrNoAPI = errors.New("client has no reader to read items from")
+	// ErrNotRepresentable is returned for keys and values that cannot be
+	// written in the text format of commands, such as values with commas.
+	ErrNotRepresentable = errors.New("command cannot be represented as a message")
+)
+
+// Item is an item read with Get or GetAll.
+type Item struct {
+	Key   string
+	Value string
+}
+
+// CallOption configures one call of the typed API.
+type CallOption func(*call)
+
+type call struct {
+	idempotencyKey string
+	ttl            time.Duration
+	namespace      string
+}
+
+// IdempotencyKey sends the command with key, so that the server applies it
+// only once even if it is sent again, for example after a failed send.
+func IdempotencyKey(key string) CallOption {
+	return func(c *call) {
+		c.idempotencyKey = key
+	}
+}
+
+// TTL makes Add remove the key after ttl, rounded up to whole seconds. The
+// item and its expiry are applied as one batch.
+func TTL(ttl time.Duration) CallOption {
+	return func(c *call) {
+		c.ttl = ttl
+	}
+}
+
+// Namespace applies the command to namespace instead of the client's
+// namespace.
+func Namespace(namespace string) CallOption {
+	return func(c *call) {
+		c.namespace = namespace
+	}
+}
+
+func newCall(opts []CallOption) call {
+	var c call
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Add sets key to value.
+func (c *Client) Add(ctx context.Context, key, value string, opts ...CallOption) error {
+	o := newCall(opts)
+	command := types.NewAddCommand(key, value)
+	if o.ttl > 0 {
+		seconds := int((o.ttl + time.Second - 1) / time.Second)
+		command = types.NewBatchCommand(command, types.NewExpireCommand(key, seconds))
+	}
+	return c.do(ctx, command, o)
+}
+
+// Delete removes key.
+func (c *Client) Delete(ctx context.Context, key string, opts ...CallOption) error {
+	return c.Do(ctx, types.NewDeleteCommand(key), opts...)
+}
+
+// Do validates command and sends it to the queue. It sends any command
+// type, such as increments or batches.
+func (c *Client) Do(ctx context.Context, command types.Command, opts ...CallOption) error {
+	o := newCall(opts)
+	if o.ttl > 0 {
+		return fmt.Errorf("%s: TTL only applies to Add", command.Type)
+	}
+	return c.do(ctx, command, o)
+}
+
+func (c *Client) do(ctx context.Context, command types.Command, o call) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if o.namespace != "" {
+		if !types.IsNamespace(o.namespace) {
+			return fmt.Errorf("invalid namespace %q", o.namespace)
+		}
+		command = command.WithNamespace(o.namespace)
+	}
+	if err := command.Validate(); err != nil {
+		return err
+	}
+	// The server parses the message, so it must parse back to the command.
+	message := command.String()
+	if parsed, err := types.ParseCommand(message); err != nil || parsed.String() != message {
+		return fmt.Errorf("%s: %w", message, ErrNotRepresentable)
+	}
+	var headers map[string]string
+	if o.idempotencyKey != "" {
+		headers = map[string]string{queue.IdempotencyKeyHeader: o.idempotencyKey}
+	}
+	return c.sendCommand(ctx, command, message, headers)
+}
+
+// Get returns the value of key, and whether it exists, from the client's
+// Reader.
+func (c *Client) Get(ctx context.Context, key string, opts ...CallOption) (string, bool, error) {
+	if c.reader == nil {
+		return "", false, ErrNoAPI
+	}
+	item, ok, err := c.reader.Get(ctx, c.readNamespace(newCall(opts)), key)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return fmt.Sprint(item.Value), true, nil
+}
+
+// GetAll returns every item in order from the client's Reader.
+func (c *Client) GetAll(ctx context.Context, opts ...CallOption) ([]Item, error) {
+	if c.reader == nil {
+		return nil, ErrNoAPI
+	}
+	items, err := c.reader.GetAll(ctx, c.readNamespace(newCall(opts)))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Item, 0, len(items))
+	for _, item := range items {
+		result = append(result, Item{Key: item.Key, Value: fmt.Sprint(item.Value)})
+	}
+	return result, nil
+}
+
+// readNamespace returns the namespace a read applies to.
+func (c *Client) readNamespace(o call) string {
+	if o.namespace != "" {
+		return o.namespace
+	}
+	return c.namespace
+}
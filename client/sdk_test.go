@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/queue"
+	"command-queue/server"
+)
+
+func TestClient_Typed(t *testing.T) {
+	memQ := queue.NewMemQueue(10)
+	c := NewClient(nil, memQ, WithNamespace("orders"))
+	ctx := context.Background()
+
+	assert.Nil(t, c.Add(ctx, "a", "1"))
+	assert.Nil(t, c.Add(ctx, "b", "2", TTL(1500*time.Millisecond), Namespace("carts"), IdempotencyKey("req-1")))
+	assert.Nil(t, c.Delete(ctx, "a", IdempotencyKey("req-2")))
+	assert.Nil(t, c.Do(ctx, types.NewIncrementCommand("n", 5)))
+
+	assert.ErrorIs(t, c.Add(ctx, "a", "1,2"), ErrNotRepresentable)
+	assert.NotNil(t, c.Add(ctx, "", "1"))
+	assert.NotNil(t, c.Do(ctx, types.NewExpireCommand("a", 0)))
+	assert.NotNil(t, c.Delete(ctx, "a", TTL(time.Second)))
+	assert.NotNil(t, c.Delete(ctx, "a", Namespace("a b")))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, c.Add(canceled, "a", "1"), context.Canceled)
+
+	memQ.Close()
+	messages, _ := memQ.ReceiveMessage()
+	var sent []queue.Message
+	for message := range messages {
+		sent = append(sent, queue.Unwrap(message))
+	}
+	if assert.Len(t, sent, 4) {
+		assert.Equal(t, "addItem('a', '1')", sent[0].Body)
+		assert.Equal(t, "orders", sent[0].Headers[queue.NamespaceHeader])
+		assert.Equal(t, "batch@carts([addItem('b', '2'), expire('b', '2')])", sent[1].Body)
+		assert.Equal(t, "req-1", sent[1].Headers[queue.IdempotencyKeyHeader])
+		assert.Equal(t, "req-2", sent[2].Headers[queue.IdempotencyKeyHeader])
+		assert.Equal(t, "increment('n', '5')", sent[3].Body)
+	}
+}
+
+func TestClient_TypedConcurrent(t *testing.T) {
+	const senders, perSender = 8, 50
+	memQ := queue.NewMemQueue(senders * perSender)
+	c := NewClient(nil, memQ)
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				assert.Nil(t, c.Add(context.Background(), fmt.Sprintf("k%d-%d", i, j), "v"))
+			}
+		}(i)
+	}
+	wg.Wait()
+	memQ.Close()
+
+	messages, _ := memQ.ReceiveMessage()
+	seen := make(map[string]bool)
+	for message := range messages {
+		seen[message] = true
+	}
+	assert.Len(t, seen, senders*perSender)
+}
+
+func TestClient_TypedReads(t *testing.T) {
+	_, _, err := NewClient(nil, queue.NewMemQueue(1)).Get(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrNoAPI)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.URL.Query().Get("namespace")
+		switch r.URL.Path {
+		case "/items/big":
+			fmt.Fprintf(w, `{"key": "big", "value": 12345678901234567}`)
+		case "/items":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"items": []map[string]string{{"key": "a", "value": namespace}}, "snapshot": "s1",
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+	c := NewClient(nil, queue.NewMemQueue(1), WithNamespace("orders"), WithAPI(NewAPI(api.URL)))
+	ctx := context.Background()
+
+	value, ok, err := c.Get(ctx, "big")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "12345678901234567", value)
+	_, ok, err = c.Get(ctx, "missing")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	items, err := c.GetAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "a", Value: "orders"}}, items)
+	items, err = c.GetAll(ctx, Namespace("carts"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "a", Value: "carts"}}, items)
+}
+
+func TestAPI_Signed(t *testing.T) {
+	key, err := auth.GenerateKey("k1", "reporting", auth.HMACSHA256)
+	assert.Nil(t, err)
+	keys, err := auth.NewKeyring(key)
+	assert.Nil(t, err)
+	signer, err := auth.NewSigner(keys, "")
+	assert.Nil(t, err)
+	s := server.NewServer(nil, logger.NewTestLogger(), 1, server.WithVerifier(auth.NewVerifier(keys, auth.DefaultWindow)))
+	api := httptest.NewServer(s.AdminHandler())
+	defer api.Close()
+	ctx := context.Background()
+
+	// The server verifies reads like messages.
+	_, err = NewAPI(api.URL).GetAll(ctx, "")
+	assert.ErrorContains(t, err, "not authenticated")
+	signed := NewAPI(api.URL, WithAPISigner(signer))
+	_, ok, err := signed.Get(ctx, "orders", "a")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	items, err := signed.GetAll(ctx, "orders")
+	assert.Nil(t, err)
+	assert.Empty(t, items)
+	assert.Equal(t, 0, s.Stats().Snapshots)
+}
+
+func TestAPI_GetAllRelease(t *testing.T) {
+	var mu sync.Mutex
+	var released []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "reporting", r.Header.Get(queue.ClientIDHeader))
+		query := r.URL.Query()
+		switch {
+		case r.Method == http.MethodDelete:
+			mu.Lock()
+			released = append(released, query.Get("snapshot"))
+			mu.Unlock()
+			fmt.Fprintf(w, `{"snapshot": %q}`, query.Get("snapshot"))
+		case query.Get("snapshot") == "":
+			fmt.Fprint(w, `{"items": [{"key": "a", "value": "1"}], "snapshot": "s1", "next": 1000}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer api.Close()
+
+	// A snapshot whose pages were not all read is released.
+	_, err := NewAPI(api.URL, WithAPIClientID("reporting")).GetAll(context.Background(), "")
+	assert.ErrorContains(t, err, "500")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"s1"}, released)
+}
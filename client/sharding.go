@@ -0,0 +1,418 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/hashring"
+	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+)
+
+var (
+	// ErrCrossShard is returned for commands whose keys belong to different shards.
+	ErrCrossShard = errors.New("command spans several shards")
+	// ErrNotShardable is returned for commands that read or change a position
+	// of the whole map, which has no meaning across shards.
+	ErrNotShardable = errors.New("command cannot be routed to a shard")
+)
+
+// ShardedQueue sends every command to the queue of the shard that owns its
+// key. Scans and prefix watches are sent to every shard.
+var _ queue.HeaderQueue = (*ShardedQueue)(nil)
+
+type ShardedQueue struct {
+	ring   *hashring.Ring
+	queues map[string]queue.Queue
+}
+
+// NewShardedQueue creates a ShardedQueue over the queues of the named shards.
+func NewShardedQueue(queues map[string]queue.Queue) *ShardedQueue {
+	shards := make([]string, 0, len(queues))
+	for shard := range queues {
+		shards = append(shards, shard)
+	}
+	return &ShardedQueue{
+		ring:   hashring.New(shards, hashring.DefaultReplicas),
+		queues: queues,
+	}
+}
+
+// Route returns the shards command is sent to.
+func (q *ShardedQueue) Route(command types.Command) ([]string, error) {
+	return route(q.ring, command)
+}
+
+func route(ring *hashring.Ring, command types.Command) ([]string, error) {
+	switch command.Type {
+	case types.GetAllItems, types.GetRange, types.GetPrefix, types.WatchPrefix, types.UnwatchPrefix,
+		types.ListNamespaces, types.CreateNamespace, types.DropNamespace, types.ClearNamespace:
+		return ring.Shards(), nil
+	case types.GetFirst, types.GetLast, types.GetAt, types.PopFirst, types.PopLast,
+		types.OpenSnapshot, types.GetPage, types.CloseSnapshot:
+		return nil, fmt.Errorf("%s: %w", command.Type, ErrNotShardable)
+	case types.InsertBefore, types.InsertAfter:
+		owner := ring.Owner(command.Key())
+		if ring.Owner(command.Pivot()) != owner {
+			return nil, fmt.Errorf("%s: %w", command, ErrCrossShard)
+		}
+		return []string{owner}, nil
+	case types.Batch:
+		// A batch is atomic on one server only.
+		var owner string
+		for _, step := range command.Commands() {
+			shards, err := route(ring, step)
+			if err != nil {
+				return nil, err
+			}
+			if len(shards) != 1 || (owner != "" && shards[0] != owner) {
+				return nil, fmt.Errorf("%s: %w", command, ErrCrossShard)
+			}
+			owner = shards[0]
+		}
+		return []string{owner}, nil
+	}
+	return []string{ring.Owner(command.Key())}, nil
+}
+
+// SendMessage sends message to the shards its command is routed to.
+func (q *ShardedQueue) SendMessage(message string) error {
+	return q.SendMessageWithHeaders(message, nil)
+}
+
+// SendMessageWithHeaders sends message with headers to the shards its
+// command is routed to.
+func (q *ShardedQueue) SendMessageWithHeaders(message string, headers map[string]string) error {
+	command, err := types.ParseCommand(message)
+	if err != nil {
+		return err
+	}
+	shards, err := q.Route(command)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, shard := range shards {
+		if err := queue.Send(q.queues[shard], message, headers); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", shard, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReceiveMessage returns the messages of all shards on one channel.
+func (q *ShardedQueue) ReceiveMessage() (<-chan string, error) {
+	out := make(chan string)
+	var wg sync.WaitGroup
+	for _, shard := range q.ring.Shards() {
+		messages, err := q.queues[shard].ReceiveMessage()
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range messages {
+				out <- message
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// ReceiveMessages returns the messages of all shards with their headers on
+// one channel.
+func (q *ShardedQueue) ReceiveMessages() (<-chan queue.Message, error) {
+	out := make(chan queue.Message)
+	var wg sync.WaitGroup
+	for _, shard := range q.ring.Shards() {
+		messages, err := queue.Receive(q.queues[shard])
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range messages {
+				out <- message
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// Close closes the queues of all shards.
+func (q *ShardedQueue) Close() error {
+	var errs []error
+	for _, shard := range q.ring.Shards() {
+		if err := q.queues[shard].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", shard, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseShards parses a comma separated list of name=value pairs, such as
+// "s1=orders-1,s2=orders-2".
+func ParseShards(spec string) (map[string]string, error) {
+	shards := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid shard %q, expected name=value", pair)
+		}
+		if _, ok := shards[name]; ok {
+			return nil, fmt.Errorf("duplicate shard %q", name)
+		}
+		shards[name] = value
+	}
+	return shards, nil
+}
+
+// GatherAll reads every item of the shards whose replication logs are
+// served on addrs. Items of one shard keep their order; shards follow each
+// other in lexical order of their names, or, if sorted is set, are merged
+// into one sequence ordered by key.
+func GatherAll(ctx context.Context, addrs map[string]string, sorted bool) ([]replication.Item, error) {
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	shards := make([][]replication.Item, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			items, _, err := replication.FetchSnapshot(ctx, addrs[name])
+			if err != nil {
+				errs[i] = fmt.Errorf("shard %s: %w", name, err)
+			}
+			shards[i] = items
+		}(i, name)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return MergeShards(shards, sorted), nil
+}
+
+// MergeShards combines the items of several shards, keeping the order
+// within each shard. Unless sorted is set the shards are concatenated;
+// otherwise every shard must be ordered by key and the result is too.
+func MergeShards(shards [][]replication.Item, sorted bool) []replication.Item {
+	total := 0
+	for _, items := range shards {
+		total += len(items)
+	}
+	merged := make([]replication.Item, 0, total)
+	if !sorted {
+		for _, items := range shards {
+			merged = append(merged, items...)
+		}
+		return merged
+	}
+
+	next := make([]int, len(shards))
+	for len(merged) < total {
+		best := -1
+		for i, items := range shards {
+			if next[i] == len(items) {
+				continue
+			}
+			if best < 0 || less(items[next[i]], shards[best][next[best]]) {
+				best = i
+			}
+		}
+		merged = append(merged, shards[best][next[best]])
+		next[best]++
+	}
+	return merged
+}
+
+// less orders items by namespace, the default namespace first, and then by
+// key, the order in which a shard with sorted maps returns them.
+func less(a, b replication.Item) bool {
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Key < b.Key
+}
+
+// ShardedReader reads items from the Readers of the shards, such as their
+// APIs. Get reads from the shard that owns the key and GetAll merges the
+// items of every shard like MergeShards.
+var _ Reader = (*ShardedReader)(nil)
+
+type ShardedReader struct {
+	ring    *hashring.Ring
+	readers map[string]Reader
+	sorted  bool
+}
+
+// NewShardedReader creates a ShardedReader over the readers of the named
+// shards. With sorted set, GetAll merges the items by key.
+func NewShardedReader(readers map[string]Reader, sorted bool) *ShardedReader {
+	shards := make([]string, 0, len(readers))
+	for shard := range readers {
+		shards = append(shards, shard)
+	}
+	return &ShardedReader{
+		ring:    hashring.New(shards, hashring.DefaultReplicas),
+		readers: readers,
+		sorted:  sorted,
+	}
+}
+
+// Get returns the item of key in namespace from the shard that owns key.
+func (r *ShardedReader) Get(ctx context.Context, namespace, key string) (replication.Item, bool, error) {
+	shard := r.ring.Owner(key)
+	item, ok, err := r.readers[shard].Get(ctx, namespace, key)
+	if err != nil {
+		return replication.Item{}, false, fmt.Errorf("shard %s: %w", shard, err)
+	}
+	return item, ok, nil
+}
+
+// GetAll returns the items of namespace of every shard. Shards follow each
+// other in lexical order of their names, or are merged by key if the
+// reader is sorted.
+func (r *ShardedReader) GetAll(ctx context.Context, namespace string) ([]replication.Item, error) {
+	names := r.ring.Shards()
+	sort.Strings(names)
+
+	shards := make([][]replication.Item, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			items, err := r.readers[name].GetAll(ctx, namespace)
+			if err != nil {
+				errs[i] = fmt.Errorf("shard %s: %w", name, err)
+			}
+			shards[i] = items
+		}(i, name)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return MergeShards(shards, r.sorted), nil
+}
+
+// Move is an item that belongs to another shard after resharding. Expires
+// is the time the key expires, if it has an expiry deadline.
+type Move struct {
+	Namespace string
+	Key       string
+	Value     interface{}
+	Expires   *time.Time
+	From      string
+	To        string
+}
+
+// PlanMoves returns the items of shard that ring assigns to other shards.
+func PlanMoves(shard string, items []replication.Item, ring *hashring.Ring) []Move {
+	var moves []Move
+	for _, item := range items {
+		if owner := ring.Owner(item.Key); owner != shard {
+			moves = append(moves, Move{Namespace: item.Namespace, Key: item.Key, Value: item.Value, Expires: item.Expires, From: shard, To: owner})
+		}
+	}
+	return moves
+}
+
+// moveCommand returns the command that copies move to its new shard, or
+// false if the key expired meanwhile. A key with an expiry deadline is
+// added in a batch with an expire step for the seconds left, rounded up.
+func moveCommand(move Move, now time.Time) (types.Command, bool, error) {
+	value, err := moveValue(move.Value)
+	if err != nil {
+		return types.Command{}, false, fmt.Errorf("key %q: %w", move.Key, err)
+	}
+	command := types.NewAddCommand(move.Key, value)
+	if move.Expires != nil {
+		left := move.Expires.Sub(now)
+		if left <= 0 {
+			return types.Command{}, false, nil
+		}
+		seconds := int((left + time.Second - 1) / time.Second)
+		command = types.NewBatchCommand(command, types.NewExpireCommand(move.Key, seconds))
+	}
+	return command.WithNamespace(move.Namespace), true, nil
+}
+
+// moveValue returns the text of a moved value. Servers store strings, and
+// the replication log may carry integers as JSON numbers; other values
+// cannot be added without changing their type.
+func moveValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+	}
+	return "", fmt.Errorf("value %v of type %T cannot be moved", value, value)
+}
+
+// ApplyMoves copies every moved item, with its expiry deadline, to its new
+// shard and then deletes it from the old one. Items that expired meanwhile
+// are only deleted. Deletes are skipped for shards without a queue, which
+// are being retired. It returns the number of items copied.
+//
+// The copy and the delete are separate messages, so writers must be stopped
+// while moves are applied: a write that reaches the old shard in between is
+// lost. Both commands are idempotent, so after a failure the moves can be
+// planned and applied again; an item left on both shards is planned again
+// from the old one.
+func ApplyMoves(moves []Move, queues map[string]queue.Queue) (int, error) {
+	for i, move := range moves {
+		to, ok := queues[move.To]
+		if !ok {
+			return i, fmt.Errorf("no queue for shard %s", move.To)
+		}
+		add, ok, err := moveCommand(move, time.Now())
+		if err != nil {
+			return i, err
+		}
+		if ok {
+			if err := to.SendMessage(add.String()); err != nil {
+				return i, fmt.Errorf("shard %s: %w", move.To, err)
+			}
+		}
+		if from, ok := queues[move.From]; ok {
+			if err := from.SendMessage(types.NewDeleteCommand(move.Key).WithNamespace(move.Namespace).String()); err != nil {
+				return i + 1, fmt.Errorf("shard %s: %w", move.From, err)
+			}
+		}
+	}
+	return len(moves), nil
+}
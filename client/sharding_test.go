@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/hashring"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+)
+
+func drain(q queue.Queue) []string {
+	messages, _ := q.ReceiveMessage()
+	var result []string
+	for {
+		select {
+		case message := <-messages:
+			result = append(result, message)
+		default:
+			return result
+		}
+	}
+}
+
+func TestShardedQueue(t *testing.T) {
+	queues := map[string]queue.Queue{
+		"s1": queue.NewMemQueue(100),
+		"s2": queue.NewMemQueue(100),
+		"s3": queue.NewMemQueue(100),
+	}
+	q := NewShardedQueue(queues)
+	ring := hashring.New([]string{"s1", "s2", "s3"}, hashring.DefaultReplicas)
+
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		assert.Nil(t, q.SendMessage(types.NewAddCommand(key, "v").String()))
+		assert.Equal(t, []string{types.NewAddCommand(key, "v").String()}, drain(queues[ring.Owner(key)]))
+	}
+
+	// Scans are sent to every shard.
+	assert.Nil(t, q.SendMessage("getAllItems()"))
+	for _, shardQ := range queues {
+		assert.Equal(t, []string{"getAllItems()"}, drain(shardQ))
+	}
+
+	// A batch must stay on one shard.
+	var a, b string
+	for i := 0; b == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if a == "" {
+			a = key
+		} else if ring.Owner(key) != ring.Owner(a) {
+			b = key
+		}
+	}
+	assert.ErrorIs(t, q.SendMessage("batch([deleteItem('"+a+"'), deleteItem('"+b+"')])"), ErrCrossShard)
+	assert.ErrorIs(t, q.SendMessage("insertAfter('"+a+"', '"+b+"', 'v')"), ErrCrossShard)
+	assert.Nil(t, q.SendMessage("batch([deleteItem('"+a+"'), addItem('"+a+"', 'v')])"))
+	assert.Len(t, drain(queues[ring.Owner(a)]), 1)
+
+	assert.ErrorIs(t, q.SendMessage("popFirst()"), ErrNotShardable)
+}
+
+func TestMergeShards(t *testing.T) {
+	shards := [][]replication.Item{
+		{{Key: "b", Value: "1"}, {Key: "d", Value: "2"}},
+		{{Key: "a", Value: "3"}, {Key: "c", Value: "4"}, {Key: "e", Value: "5"}},
+	}
+	keys := func(items []replication.Item) []string {
+		var result []string
+		for _, item := range items {
+			result = append(result, item.Key)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"b", "d", "a", "c", "e"}, keys(MergeShards(shards, false)))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys(MergeShards(shards, true)))
+}
+
+func TestGatherAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := make(map[string]string)
+	for name, items := range map[string][]replication.Item{
+		"s1": {{Key: "x", Value: "1"}},
+		"s2": {{Key: "y", Value: "2"}, {Key: "w", Value: "3"}},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		items := items
+		snapshot := func() ([]replication.Item, uint64) { return items, 0 }
+		go replication.NewPrimary(replication.NewLog(1), snapshot, logger.NewConsoleLogger()).Serve(ctx, ln)
+		addrs[name] = ln.Addr().String()
+	}
+
+	items, err := GatherAll(ctx, addrs, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []replication.Item{{Key: "x", Value: "1"}, {Key: "y", Value: "2"}, {Key: "w", Value: "3"}}, items)
+}
+
+// itemReader is a Reader over the items of one shard.
+type itemReader []replication.Item
+
+func (r itemReader) Get(_ context.Context, namespace, key string) (replication.Item, bool, error) {
+	for _, item := range r {
+		if item.Namespace == namespace && item.Key == key {
+			return item, true, nil
+		}
+	}
+	return replication.Item{}, false, nil
+}
+
+func (r itemReader) GetAll(_ context.Context, namespace string) ([]replication.Item, error) {
+	var items []replication.Item
+	for _, item := range r {
+		if item.Namespace == namespace {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func TestShardedReader(t *testing.T) {
+	ring := hashring.New([]string{"s1", "s2"}, hashring.DefaultReplicas)
+	shards := map[string]itemReader{}
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		shards[ring.Owner(key)] = append(shards[ring.Owner(key)], replication.Item{Key: key, Value: i})
+	}
+	readers := map[string]Reader{"s1": shards["s1"], "s2": shards["s2"]}
+	ctx := context.Background()
+
+	// Reads of a key go to its shard.
+	r := NewShardedReader(readers, true)
+	item, ok, err := r.Get(ctx, "", "key7")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 7, item.Value)
+
+	// Scans merge the items of all shards.
+	items, err := r.GetAll(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 10)
+	for i := 1; i < len(items); i++ {
+		assert.Less(t, items[i-1].Key, items[i].Key)
+	}
+	items, err = NewShardedReader(readers, false).GetAll(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, append(append([]replication.Item(nil), shards["s1"]...), shards["s2"]...), items)
+}
+
+func TestReshard(t *testing.T) {
+	var items []replication.Item
+	for i := 0; i < 100; i++ {
+		items = append(items, replication.Item{Key: "key" + strconv.Itoa(i), Value: strconv.Itoa(i)})
+	}
+	ring := hashring.New([]string{"s1", "s2"}, hashring.DefaultReplicas)
+	moves := PlanMoves("s1", items, ring)
+	assert.NotEmpty(t, moves)
+	for _, move := range moves {
+		assert.Equal(t, "s2", move.To)
+	}
+
+	queues := map[string]queue.Queue{"s1": queue.NewMemQueue(100), "s2": queue.NewMemQueue(100)}
+	moved, err := ApplyMoves(moves[:1], queues)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, moved)
+	add := types.NewAddCommand(moves[0].Key, moves[0].Value.(string)).String()
+	assert.Equal(t, []string{add}, drain(queues["s2"]))
+	assert.Equal(t, []string{types.NewDeleteCommand(moves[0].Key).String()}, drain(queues["s1"]))
+
+	// A failed delete leaves the item on both shards; planning again from
+	// the old shard moves it again with the same commands.
+	failing := map[string]queue.Queue{"s1": &flakyQueue{Queue: queue.NewMemQueue(100), attempts: map[string]int{}}, "s2": queue.NewMemQueue(100)}
+	fail := Move{Key: "fail", Value: "1", From: "s1", To: "s2"}
+	moved, err = ApplyMoves([]Move{fail}, failing)
+	assert.Error(t, err)
+	assert.Equal(t, 1, moved)
+	moved, err = ApplyMoves([]Move{fail}, queues)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, moved)
+	assert.Equal(t, drain(failing["s2"]), drain(queues["s2"]))
+
+	drain(queues["s1"])
+
+	// Keys keep their expiry deadline; expired keys are only deleted.
+	expires := time.Now().Add(90 * time.Second)
+	expired := time.Now().Add(-time.Second)
+	ttl := []Move{
+		{Namespace: "orders", Key: "ttl", Value: "v", Expires: &expires, From: "s1", To: "s2"},
+		{Key: "gone", Value: "v", Expires: &expired, From: "s1", To: "s2"},
+		{Key: "count", Value: float64(42), From: "s1", To: "s2"},
+	}
+	moved, err = ApplyMoves(ttl, queues)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, moved)
+	withTTL := types.NewBatchCommand(types.NewAddCommand("ttl", "v"), types.NewExpireCommand("ttl", 90)).WithNamespace("orders")
+	assert.Equal(t, []string{withTTL.String(), types.NewAddCommand("count", "42").String()}, drain(queues["s2"]))
+	assert.Len(t, drain(queues["s1"]), 3)
+
+	// Values that the text of a command cannot carry are not stringified.
+	moved, err = ApplyMoves([]Move{{Key: "f", Value: 1.5, From: "s1", To: "s2"}}, queues)
+	assert.Error(t, err)
+	assert.Equal(t, 0, moved)
+
+	shards, err := ParseShards("s1=q1, s2=q2")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"s1": "q1", "s2": "q2"}, shards)
+	_, err = ParseShards("s1=q1,s1=q2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/lineedit"
+	"command-queue/internal/util/logger"
+)
+
+// maxRecentKeys is the number of keys the shell offers as completions.
+const maxRecentKeys = 100
+
+const shellHelp = `Type a command such as addItem('key', 'value') and press Enter to send it.
+Tab completes command names and recently used keys; Up and Down browse the history.
+Invalid commands are reported and not sent. Type exit or press Ctrl-D to quit.
+`
+
+// Shell sends the commands a user types to the queue of a Client and reports
+// errors without stopping. If the client has an API, getItem and
+// getAllItems are answered by the server instead of being queued.
+type Shell struct {
+	client *Client
+	out    io.Writer
+	keys   []string
+}
+
+// NewShell returns a shell that sends commands with c and writes its output
+// to out.
+func NewShell(c *Client, out io.Writer) *Shell {
+	return &Shell{client: c, out: out}
+}
+
+// Run reads commands from editor until the input ends, the user exits or ctx
+// is done.
+func (s *Shell) Run(ctx context.Context, editor *lineedit.Editor) error {
+	prompt := "> "
+	if s.client.namespace != "" {
+		prompt = s.client.namespace + "> "
+	}
+	for ctx.Err() == nil {
+		line, err := editor.ReadLine(prompt)
+		if errors.Is(err, lineedit.ErrInterrupted) {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading input: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := editor.AddHistory(line); err != nil {
+			fmt.Fprintf(s.out, "warning: %v\n", err)
+		}
+		switch line {
+		case "help":
+			fmt.Fprint(s.out, shellHelp)
+			continue
+		case "exit", "quit":
+			return nil
+		}
+		s.execute(ctx, line)
+	}
+	return nil
+}
+
+// execute sends or answers one command.
+func (s *Shell) execute(ctx context.Context, line string) {
+	command, err := types.ParseCommand(line)
+	if err != nil {
+		s.client.metrics.errors.With("parse").Inc()
+		fmt.Fprintf(s.out, "error: %s\n", strings.TrimSpace(err.Error()))
+		return
+	}
+	s.remember(command)
+
+	if s.client.reader != nil && (command.Type == types.GetItem || command.Type == types.GetAllItems) {
+		if err := s.reply(ctx, command); err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+		return
+	}
+	if err := s.client.sendCommand(ctx, command, line, nil); err != nil {
+		fmt.Fprintf(s.out, "error sending command: %v\n", err)
+		return
+	}
+	s.client.log.Debug("Sent command", logger.KeyCommandType, string(command.Type))
+	fmt.Fprintln(s.out, "sent")
+}
+
+// reply prints the result of a getItem or getAllItems command.
+func (s *Shell) reply(ctx context.Context, command types.Command) error {
+	namespace := command.Namespace()
+	if namespace == "" {
+		namespace = s.client.namespace
+	}
+	if command.Type == types.GetItem {
+		item, ok, err := s.client.reader.Get(ctx, namespace, command.Key())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(s.out, "%s not found\n", command.Key())
+			return nil
+		}
+		fmt.Fprintf(s.out, "%s : %v\n", item.Key, item.Value)
+		return nil
+	}
+	items, err := s.client.reader.GetAll(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		fmt.Fprintf(s.out, "%s : %v\n", item.Key, item.Value)
+	}
+	fmt.Fprintf(s.out, "(%d items)\n", len(items))
+	return nil
+}
+
+// remember adds the keys of command to the recently used keys.
+func (s *Shell) remember(command types.Command) {
+	if command.Type == types.Batch {
+		for _, step := range command.Commands() {
+			s.remember(step)
+		}
+		return
+	}
+	if !command.HasKey() {
+		return
+	}
+	keys := []string{command.Key()}
+	if command.Type == types.InsertBefore || command.Type == types.InsertAfter {
+		keys = append(keys, command.Pivot())
+	}
+	for _, key := range keys {
+		for i, k := range s.keys {
+			if k == key {
+				s.keys = append(s.keys[:i], s.keys[i+1:]...)
+				break
+			}
+		}
+		s.keys = append([]string{key}, s.keys...)
+	}
+	if len(s.keys) > maxRecentKeys {
+		s.keys = s.keys[:maxRecentKeys]
+	}
+}
+
+// Complete completes command names at the start of a command, and recently
+// used keys inside an open quote. It is a lineedit.Completer.
+func (s *Shell) Complete(before string) (int, []string) {
+	// A command starts at the start of the line or of a batch step, which
+	// follows a '[' or a ',' outside of the parentheses of another step.
+	start, depth, stepDepth, quoted := 0, 0, 0, false
+	for i, r := range before {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == '[', r == ',' && depth == stepDepth:
+			start, stepDepth = i+1, depth
+		}
+	}
+	if word := strings.TrimLeft(before[start:], " "); !quoted && !strings.ContainsAny(word, "()@") {
+		start = len(before) - len(word)
+		var candidates []string
+		for _, commandType := range types.CommandTypes() {
+			if strings.HasPrefix(string(commandType), word) {
+				candidates = append(candidates, string(commandType)+"(")
+			}
+		}
+		sort.Strings(candidates)
+		return start, candidates
+	}
+	if !quoted {
+		return 0, nil
+	}
+	start = strings.LastIndex(before, "'") + 1
+	var candidates []string
+	for _, key := range s.keys {
+		if strings.HasPrefix(key, before[start:]) {
+			candidates = append(candidates, key+"'")
+		}
+	}
+	return start, candidates
+}
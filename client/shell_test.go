@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/lineedit"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/queue"
+)
+
+func TestShell_Run(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/items/a" && r.URL.Query().Get("namespace") == "orders":
+			json.NewEncoder(w).Encode(map[string]string{"key": "a", "value": "1"})
+		case r.URL.Path == "/items" && r.URL.Query().Get("snapshot") == "":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"items": []map[string]string{{"key": "a", "value": "1"}}, "snapshot": "s1", "next": 1,
+			})
+		case r.URL.Path == "/items" && r.URL.Query().Get("offset") == "1":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"items": []map[string]string{{"key": "b", "value": "2"}}, "snapshot": "s1",
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		}
+	}))
+	defer api.Close()
+
+	input := strings.Join([]string{
+		"addItem('a', '1')",
+		"addItem('a'",
+		"",
+		"help",
+		"getItem('a')",
+		"getItem('b')",
+		"getAllItems()",
+		"exit",
+		"deleteItem('a')",
+	}, "\n")
+	memQ := queue.NewMemQueue(10)
+	c := NewClient(nil, memQ, WithLogger(logger.NewTestLogger()), WithNamespace("orders"), WithAPI(NewAPI(api.URL)))
+	var out bytes.Buffer
+	editor, err := lineedit.New(strings.NewReader(input), &out)
+	assert.Nil(t, err)
+	assert.Nil(t, NewShell(c, &out).Run(context.Background(), editor))
+
+	output := out.String()
+	assert.Contains(t, output, "orders> sent\n")
+	assert.Contains(t, output, "error: Invalid message: addItem('a'\n")
+	assert.Contains(t, output, "Tab completes")
+	assert.Contains(t, output, "a : 1\n")
+	assert.Contains(t, output, "b not found\n")
+	assert.Contains(t, output, "a : 1\nb : 2\n(2 items)\n")
+	assert.Equal(t, []string{"addItem('a', '1')", "addItem('a'", "help", "getItem('a')", "getItem('b')", "getAllItems()", "exit"}, editor.History())
+
+	// Only the valid write was queued; the shell stopped at exit.
+	memQ.Close()
+	messages, _ := memQ.ReceiveMessage()
+	var sent []string
+	for message := range messages {
+		sent = append(sent, queue.Unwrap(message).Body)
+	}
+	assert.Equal(t, []string{"addItem('a', '1')"}, sent)
+
+	var sb strings.Builder
+	assert.Nil(t, c.Metrics().WriteText(&sb))
+	assert.Contains(t, sb.String(), `commandqueue_client_errors_total{reason="parse"} 1`+"\n")
+}
+
+func TestShell_Complete(t *testing.T) {
+	s := NewShell(NewClient(nil, queue.NewMemQueue(1)), &bytes.Buffer{})
+	for _, message := range []string{"batch([addItem('apple', '1'), insertAfter('apple', 'apricot', '2')])", "getItem('banana')"} {
+		command, err := types.ParseCommand(message)
+		assert.Nil(t, err)
+		s.remember(command)
+	}
+
+	tests := []struct {
+		before     string
+		start      int
+		candidates []string
+	}{
+		{"getA", 0, []string{"getAllItems(", "getAt("}},
+		{"  del", 2, []string{"deleteItem("}},
+		{"batch([addItem('a', '1'), getI", 26, []string{"getItem("}},
+		{"getItem('ap", 9, []string{"apple'", "apricot'"}},
+		{"getItem('", 9, []string{"banana'", "apple'", "apricot'"}},
+		{"getItem('a', ", 0, nil},
+		{"addItem@orders(", 0, nil},
+	}
+	for _, test := range tests {
+		start, candidates := s.Complete(test.before)
+		assert.Equal(t, test.candidates, candidates, test.before)
+		if candidates != nil {
+			assert.Equal(t, test.start, start, test.before)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"command-queue/internal/types"
+)
+
+// job is a parsed line of the input.
+type job struct {
+	line    int
+	text    string
+	command types.Command
+}
+
+// startWorkers reads the input and sends its lines with c.workers
+// goroutines. Lines are parsed in order by the reader, so invalid lines are
+// handled as by a single worker; sends can complete in any order, except
+// for lines with the same key when partitioning.
+func (c *Client) startWorkers(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	stop := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// Without partitioning the workers share one channel.
+	channels := make([]chan job, 1)
+	if c.partition {
+		channels = make([]chan job, c.workers)
+	}
+	for i := range channels {
+		channels[i] = make(chan job, c.workers)
+	}
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func(jobs <-chan job) {
+			defer wg.Done()
+			for j := range jobs {
+				// Drain the remaining jobs once the run stopped.
+				if runCtx.Err() != nil {
+					continue
+				}
+				if err := c.process(runCtx, j); err != nil {
+					stop(err)
+				}
+			}
+		}(channels[i%len(channels)])
+	}
+
+	readErr := c.dispatch(runCtx, channels, stop)
+	for _, ch := range channels {
+		close(ch)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return readErr
+}
+
+// dispatch parses the lines of the input and hands them to the channel of
+// their key, until the input ends or ctx is done.
+func (c *Client) dispatch(ctx context.Context, channels []chan job, stop func(error)) error {
+	scanner := bufio.NewScanner(c.inputSource)
+	line := 0
+	for scanner.Scan() {
+		line++
+		str := scanner.Text()
+		command, err := types.ParseCommand(str)
+		if err != nil {
+			c.metrics.errors.With("parse").Inc()
+			if err := c.reject(LineError{Line: line, Text: str, Err: err}, true); err != nil {
+				stop(err)
+				return nil
+			}
+			continue
+		}
+		ch := channels[0]
+		if len(channels) > 1 {
+			ch = channels[partition(command, len(channels))]
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case ch <- job{line: line, text: str, command: command}:
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input: %v", err)
+	}
+	return nil
+}
+
+// partition returns the worker of the key of command. Commands without a
+// key go to the first worker; batches go to the worker of their first key.
+func partition(command types.Command, workers int) int {
+	key := ""
+	switch {
+	case command.HasKey():
+		key = command.Key()
+	case command.Type == types.Batch:
+		for _, step := range command.Commands() {
+			if step.HasKey() {
+				key = step.Key()
+				break
+			}
+		}
+	}
+	if key == "" {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// reportProgress writes the progress of Start every interval until the
+// returned function is called, which writes it a last time.
+func (c *Client) reportProgress() func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		last, lastTime := 0, time.Now()
+		report := func(now time.Time) {
+			s := c.Summary()
+			rate := float64(s.Sent-last) / now.Sub(lastTime).Seconds()
+			fmt.Fprintf(c.progress, "sent %d (%.1f/s), invalid %d, failed %d\n", s.Sent, rate, len(s.Invalid), len(s.Failed))
+			last, lastTime = s.Sent, now
+		}
+		for {
+			select {
+			case <-done:
+				report(time.Now())
+				return
+			case now := <-ticker.C:
+				report(now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"command-queue/internal/util/audit"
+)
+
+// auditverify checks the hash chain of a server's audit log, including its
+// rotated files, and exits with status 1 if a record was changed, removed
+// or reordered.
+func main() {
+	file := flag.String("file", "", "Audit log file written by the server (-auditLog)")
+	flag.Parse()
+
+	if *file == "" {
+		fmt.Println("Please provide the audit log file")
+		os.Exit(1)
+	}
+	report, err := audit.Verify(*file)
+	var verr *audit.VerifyError
+	switch {
+	case errors.As(err, &verr):
+		fmt.Printf("Audit log tampered: %v\n", verr)
+		os.Exit(1)
+	case err != nil:
+		fmt.Printf("Error reading audit log: %v\n", err)
+		os.Exit(1)
+	case report.Records == 0:
+		fmt.Println("Audit log is empty")
+		return
+	}
+	fmt.Printf("Audit log intact: %d records in %d files, from record %d to %d\n",
+		report.Records, report.Files, report.First, report.Last)
+	if report.First > 1 {
+		fmt.Printf("Records before %d were removed by rotation and are not verified\n", report.First)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"command-queue/internal/bench"
+	"command-queue/internal/cmdflags"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/queue"
+	"command-queue/server"
+)
+
+const (
+	defaultBufferLength = 1000
+)
+
+// bench sends a synthetic load to a server through a queue and reports the
+// throughput and latencies. With a feed queue it also measures the time
+// from sending a write to receiving its change event.
+func main() {
+	queueType := flag.String("queue", "", "Type of queue (rabbitmq, aws or mem for a server in this process)")
+	region := flag.String("region", "", "AWS region (required for aws)")
+	queueURL := flag.String("queueURL", "", "aws queue URL")
+	connectionString := flag.String("conn", "", "RabbitMQ connection string")
+	queueName := flag.String("queueName", "", "Queue name")
+	feedQueue := flag.String("feedQueue", "", "Change feed queue of the server (queue name for rabbitmq, URL for aws); enables end-to-end latencies of writes")
+	mixFlag := flag.String("mix", bench.DefaultMix.String(), "Relative frequencies of add, delete, get and getAll")
+	keys := flag.Int("keys", bench.DefaultKeys, "Number of distinct keys")
+	distribution := flag.String("distribution", string(bench.Uniform), "Key distribution (uniform or zipfian)")
+	zipfS := flag.Float64("zipfS", bench.DefaultZipfS, "Skew of the zipfian distribution, greater than 1")
+	valueSize := flag.Int("valueSize", bench.DefaultValueSize, "Size of the values of adds in bytes")
+	rate := flag.Float64("rate", 0, "Commands sent per second (0 sends as fast as possible)")
+	workers := flag.Int("workers", bench.DefaultWorkers, "Number of concurrent senders")
+	duration := flag.Duration("duration", 10*time.Second, "How long to send commands (0 for no limit)")
+	count := flag.Int("count", 0, "Number of commands to send (0 for no limit)")
+	prefix := flag.String("prefix", "", "Prefix of the keys (defaults to a prefix unique to the run)")
+	seed := flag.Int64("seed", 0, "Seed of the generator (defaults to the current time)")
+	drain := flag.Duration("drain", bench.DefaultDrain, "How long to wait for the change events of the last writes")
+	maxWorkers := flag.Int("maxWorkers", 10, "Maximum number of commands processed in parallel by the mem server")
+	logLevel := flag.String("logLevel", "warn", "Log level of the mem server")
+	signing := cmdflags.NewSigning(flag.CommandLine)
+	encoding := cmdflags.NewEncoding(flag.CommandLine)
+	flag.Parse()
+
+	mix, err := bench.ParseMix(*mixFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	dist, err := bench.ParseDistribution(*distribution)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *duration == 0 && *count == 0 {
+		fmt.Println("Please provide a duration or a count")
+		os.Exit(1)
+	}
+	now := time.Now()
+	if *prefix == "" {
+		*prefix = fmt.Sprintf("bench:%d:", now.Unix())
+	}
+	if *seed == 0 {
+		*seed = now.UnixNano()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Setup signal handling for cancellation
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		fmt.Println("\nReceived SIGTERM or SIGINT. Stopping the run...")
+		cancel()
+	}()
+
+	encoder, err := encoding.Encoder()
+	if err != nil {
+		fmt.Printf("Error %v\n", err)
+		os.Exit(1)
+	}
+	var q, feed queue.Queue
+	switch *queueType {
+	case "rabbitmq":
+		if *connectionString == "" || *queueName == "" {
+			fmt.Println("Please provide RabbitMQ connection string and queue name")
+			os.Exit(1)
+		}
+		if q, err = queue.NewRabbitMQQueue(ctx, *connectionString, *queueName, defaultBufferLength); err == nil && *feedQueue != "" {
+			feed, err = queue.NewRabbitMQQueue(ctx, *connectionString, *feedQueue, defaultBufferLength)
+		}
+	case "aws":
+		if *region == "" || *queueURL == "" {
+			fmt.Println("Please provide AWS region and SQS queue URL")
+			os.Exit(1)
+		}
+		if q, err = queue.NewSQSQueue(*region, *queueURL, defaultBufferLength); err == nil && *feedQueue != "" {
+			feed, err = queue.NewSQSQueue(*region, *feedQueue, defaultBufferLength)
+		}
+	case "mem":
+		// The server writes the results of reads to files in the working
+		// directory, which is a temporary one here.
+		dir, err := os.MkdirTemp("", "command-queue-bench")
+		if err != nil {
+			fmt.Printf("Error creating result directory: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(dir)
+		if err := os.Chdir(dir); err != nil {
+			fmt.Printf("Error changing to result directory: %v\n", err)
+			os.Exit(1)
+		}
+		levels, err := logger.ParseLevels(*logLevel)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log, err := logger.NewSlogLogger(os.Stderr, logger.FormatText, levels)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		q = queue.NewMemQueue(defaultBufferLength)
+		feed = queue.NewMemQueue(defaultBufferLength)
+		// The server decodes and encodes its queues like one started with
+		// the same flags.
+		received := encoder.Wrap(q, func(err error) {
+			log.Warn("Dropped message that could not be decoded", logger.KeyError, err)
+		})
+		s := server.NewServer(received, log, *maxWorkers, server.WithChangeFeed(encoder.Wrap(feed, nil)))
+		go s.Start(ctx)
+	default:
+		fmt.Println("Invalid queue type. Supported types: rabbitmq, aws, mem")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error creating queue: %v\n", err)
+		os.Exit(1)
+	}
+	if *queueType != "mem" {
+		defer q.Close()
+		if feed != nil {
+			defer feed.Close()
+		}
+	}
+	if feed != nil {
+		feed = encoder.Wrap(feed, nil)
+	}
+	if q, err = signing.Wrap(encoder.Wrap(q, nil)); err != nil {
+		fmt.Printf("Error %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg := bench.Config{
+		Mix:          mix,
+		Keys:         *keys,
+		Distribution: dist,
+		ZipfS:        *zipfS,
+		ValueSize:    *valueSize,
+		Rate:         *rate,
+		Workers:      *workers,
+		Duration:     *duration,
+		Count:        *count,
+		Prefix:       *prefix,
+		Seed:         *seed,
+		Drain:        *drain,
+	}
+	fmt.Printf("Sending %s over %d keys (%s) with prefix %q\n", mix, *keys, dist, *prefix)
+	report, err := bench.Run(ctx, q, feed, cfg)
+	if err != nil {
+		fmt.Printf("Error running benchmark: %v\n", err)
+		os.Exit(1)
+	}
+	if err := report.Write(os.Stdout); err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"command-queue/client"
+	"command-queue/internal/cmdflags"
+	"command-queue/internal/types"
+	"command-queue/internal/util/lineedit"
+	"command-queue/internal/util/logger"
 	"command-queue/internal/util/queue"
+	"command-queue/internal/util/tracing"
 )
 
 const (
@@ -24,8 +33,61 @@ func main() {
 	connectionString := flag.String("conn", "", "RabbitMQ connection string")
 	queuName := flag.String("queueName", "", "Queue name")
 	filePath := flag.String("file", "", "Input file path")
+	onError := flag.String("onError", string(client.FailFast), "What to do with lines that do not parse or cannot be sent: fail-fast, skip-invalid or retry-then-skip")
+	retries := flag.Int("retries", client.DefaultRetries, "Retries of a failed send with retry-then-skip")
+	retryDelay := flag.Duration("retryDelay", client.DefaultRetryDelay, "Delay before the first retry of a send; it doubles with every retry")
+	rejectedFile := flag.String("rejected", "", "File the lines that were not sent are written to")
+	workers := flag.Int("workers", 1, "Number of lines sent concurrently")
+	partition := flag.Bool("partition", false, "Send all lines with the same key from the same worker, in input order")
+	progress := flag.Duration("progress", 0, "Interval progress and throughput are printed to stderr at (0 disables it)")
+	interactive := flag.Bool("interactive", false, "Read commands in an interactive shell (the default when stdin is a terminal and no file is given)")
+	serverAddr := flag.String("serverAddr", "", "HTTP API address of the server that the shell reads getItem and getAllItems results from; with -shards, comma separated shard=address pairs")
+	historyFile := flag.String("history", defaultHistoryFile(), "File the shell keeps its history in")
+	shards := flag.String("shards", "", "Comma separated shard=queue pairs; commands are routed to shards by key")
+	gather := flag.String("gather", "", "Comma separated shard=address pairs of replication logs; prints the items of every shard and exits")
+	sorted := flag.Bool("sorted", false, "Merge gathered and read items of shards by key (for shards with sorted maps)")
+	metricsAddr := flag.String("metricsAddr", "", "Address to serve Prometheus metrics on while the client runs")
+	traceFile := flag.String("traceFile", "", "File that spans are appended to as JSON lines")
+	otlpEndpoint := flag.String("otlpEndpoint", "", "OTLP/HTTP collector that spans are exported to (e.g. http://localhost:4318)")
+	clientID := flag.String("id", "", "Client ID recorded in the server's audit log")
+	namespace := flag.String("namespace", "", "Namespace that commands without one apply to")
+	signing := cmdflags.NewSigning(flag.CommandLine)
+	encoding := cmdflags.NewEncoding(flag.CommandLine)
+	logLevel := flag.String("logLevel", "info", "Log level, optionally per component (e.g. info,replication=debug)")
+	logFormat := flag.String("logFormat", "text", "Log format (text or json)")
 	flag.Parse()
 
+	levels, err := logger.ParseLevels(*logLevel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	log, err := logger.NewSlogLogger(os.Stderr, logger.Format(*logFormat), levels)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *gather != "" {
+		addrs, err := client.ParseShards(*gather)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		items, err := client.GatherAll(context.Background(), addrs, *sorted)
+		if err != nil {
+			fmt.Printf("Error gathering items: %v\n", err)
+			os.Exit(1)
+		}
+		for _, item := range items {
+			if item.Namespace != "" {
+				fmt.Printf("@%s ", item.Namespace)
+			}
+			fmt.Printf("%s : %v\n", item.Key, item.Value)
+		}
+		return
+	}
+
 	// Check if required arguments are provided
 	if *queueType == "" {
 		fmt.Println("Please provide a queue type (rabbitmq or aws)")
@@ -35,6 +97,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shell := *interactive || (*filePath == "" && lineedit.IsTerminal(os.Stdin))
+	var shellEditor atomic.Pointer[lineedit.Editor]
+
 	// Setup signal handling for cancellation
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
@@ -42,42 +107,80 @@ func main() {
 		<-sig
 		fmt.Println("\nReceived SIGTERM or SIGINT. Cancelling all operations...")
 		cancel()
+		if editor := shellEditor.Load(); editor != nil {
+			// The shell waits for input; leave the terminal usable.
+			editor.Close()
+			os.Exit(1)
+		}
 	}()
 
 	// Initialize queue based on the provided type
-	var q queue.Queue
-	var err error
+	var newQueue func(name string) (queue.Queue, error)
 	switch *queueType {
 	case "rabbitmq":
 		if *connectionString == "" {
 			fmt.Println("Please provide RabbitMQ connection string")
 			os.Exit(1)
 		}
-		if *queuName == "" {
+		if *queuName == "" && *shards == "" {
 			fmt.Println("Please provide queue name")
 			os.Exit(1)
 		}
-		q, err = queue.NewRabbitMQQueue(ctx, *connectionString, *queuName, defaultBufferLength)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		newQueue = func(name string) (queue.Queue, error) {
+			return queue.NewRabbitMQQueue(ctx, *connectionString, name, defaultBufferLength)
 		}
 	case "aws":
-		if *region == "" || *queueURL == "" {
+		if *region == "" || (*queueURL == "" && *shards == "") {
 			fmt.Println("Please provide AWS region and SQS queue URL")
 			os.Exit(1)
 		}
-
-		q, err = queue.NewSQSQueue(*region, *queueURL, defaultBufferLength)
-		if err != nil {
-			fmt.Printf("Error creating SQS queue: %v\n", err)
-			os.Exit(1)
+		newQueue = func(url string) (queue.Queue, error) {
+			return queue.NewSQSQueue(*region, url, defaultBufferLength)
 		}
-
 	default:
 		fmt.Println("Invalid queue type. Supported types: rabbitmq, aws")
 		os.Exit(1)
 	}
+	encoder, err := encoding.Encoder()
+	if err != nil {
+		fmt.Printf("Error %v\n", err)
+		os.Exit(1)
+	}
+	plain := newQueue
+	newQueue = func(name string) (queue.Queue, error) {
+		q, err := plain(name)
+		if err != nil {
+			return nil, err
+		}
+		return encoder.Wrap(q, nil), nil
+	}
+
+	var q queue.Queue
+	if *shards != "" {
+		// Every shard is a server with its own queue.
+		names, err := client.ParseShards(*shards)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		queues := make(map[string]queue.Queue, len(names))
+		for shard, name := range names {
+			if queues[shard], err = newQueue(name); err != nil {
+				fmt.Printf("Error creating queue of shard %s: %v\n", shard, err)
+				os.Exit(1)
+			}
+		}
+		q = client.NewShardedQueue(queues)
+	} else {
+		name := *queuName
+		if *queueType == "aws" {
+			name = *queueURL
+		}
+		if q, err = newQueue(name); err != nil {
+			fmt.Printf("Error creating queue: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	defer q.Close()
 
 	// Initialize client with input source
@@ -94,11 +197,141 @@ func main() {
 		inputSource = os.Stdin
 	}
 
-	c := client.NewClient(inputSource, q)
+	errorMode, err := client.ParseErrorMode(*onError)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *workers < 1 {
+		fmt.Println("workers must be at least 1")
+		os.Exit(1)
+	}
+	opts := []client.Option{client.WithLogger(log), client.WithErrorMode(errorMode), client.WithRetries(*retries, *retryDelay)}
+	if *workers > 1 {
+		opts = append(opts, client.WithWorkers(*workers, *partition))
+	}
+	if *progress > 0 {
+		opts = append(opts, client.WithProgress(os.Stderr, *progress))
+	}
+	if *rejectedFile != "" {
+		f, err := os.Create(*rejectedFile)
+		if err != nil {
+			fmt.Printf("Error creating rejected lines file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts = append(opts, client.WithRejectedLines(f))
+	}
+	// Reads through the server's API identify the client like its messages.
+	var apiOpts []client.APIOption
+	if *clientID != "" {
+		opts = append(opts, client.WithClientID(*clientID))
+		apiOpts = append(apiOpts, client.WithAPIClientID(*clientID))
+	}
+	if *namespace != "" {
+		if !types.IsNamespace(*namespace) {
+			fmt.Printf("Invalid namespace %q\n", *namespace)
+			os.Exit(1)
+		}
+		opts = append(opts, client.WithNamespace(*namespace))
+	}
+	signer, err := signing.Signer()
+	if err != nil {
+		fmt.Printf("Error %v\n", err)
+		os.Exit(1)
+	}
+	if signer != nil {
+		opts = append(opts, client.WithSigner(signer))
+		apiOpts = append(apiOpts, client.WithAPISigner(signer))
+	}
+	switch {
+	case *serverAddr != "" && *shards != "":
+		// Reads go to the shard of their key; scans merge every shard.
+		addrs, err := client.ParseShards(*serverAddr)
+		if err != nil {
+			fmt.Printf("Error parsing server addresses: %v\n", err)
+			os.Exit(1)
+		}
+		// The shards must be the same, or keys would be read from
+		// other shards than they are sent to.
+		names, _ := client.ParseShards(*shards)
+		readers := make(map[string]client.Reader, len(addrs))
+		for shard, addr := range addrs {
+			if _, ok := names[shard]; !ok {
+				fmt.Printf("Server address of unknown shard %s\n", shard)
+				os.Exit(1)
+			}
+			readers[shard] = client.NewAPI(addr, apiOpts...)
+		}
+		if len(readers) != len(names) {
+			fmt.Println("Every shard needs a server address")
+			os.Exit(1)
+		}
+		opts = append(opts, client.WithReader(client.NewShardedReader(readers, *sorted)))
+	case *serverAddr != "":
+		opts = append(opts, client.WithAPI(client.NewAPI(*serverAddr, apiOpts...)))
+	}
+	exporter, err := tracing.NewExporter(*traceFile, *otlpEndpoint)
+	if err != nil {
+		fmt.Printf("Error creating trace exporter: %v\n", err)
+		os.Exit(1)
+	}
+	if exporter != nil {
+		tracer := tracing.NewTracer("command-queue-client", exporter, func(err error) {
+			log.Warn("Error exporting spans", logger.KeyError, err)
+		})
+		defer tracer.Close()
+		opts = append(opts, client.WithTracer(tracer))
+	}
+
+	c := client.NewClient(inputSource, q, opts...)
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", c.Metrics().Handler())
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				fmt.Printf("Error serving metrics: %v\n", err)
+			}
+		}()
+	}
+
+	if shell {
+		sh := client.NewShell(c, os.Stdout)
+		editorOpts := []lineedit.Option{lineedit.WithCompleter(sh.Complete)}
+		if *historyFile != "" {
+			editorOpts = append(editorOpts, lineedit.WithHistoryFile(*historyFile))
+		}
+		editor, err := lineedit.New(inputSource, os.Stdout, editorOpts...)
+		if err != nil {
+			fmt.Printf("Error starting shell: %v\n", err)
+			os.Exit(1)
+		}
+		shellEditor.Store(editor)
+		if err := sh.Run(ctx, editor); err != nil {
+			fmt.Printf("Error running shell: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Run the client
-	if err := c.Start(ctx); err != nil {
+	started := time.Now()
+	err = c.Start(ctx)
+	elapsed := time.Since(started)
+	summary := c.Summary()
+	fmt.Fprintf(os.Stderr, "%s in %s (%.1f commands/s)\n", summary, elapsed.Round(time.Millisecond), float64(summary.Sent)/elapsed.Seconds())
+	if err != nil {
 		fmt.Printf("Error running client: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// defaultHistoryFile returns the shell's history file in the home
+// directory, or "" if there is none.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".command-queue_history")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"command-queue/internal/util/auth"
+)
+
+// keygen creates a signing key for a client and adds it to the client's key
+// file and to the server's key file, which only receives the public key of
+// an Ed25519 key pair.
+func main() {
+	id := flag.String("id", "", "Key ID")
+	clientName := flag.String("client", "", "Client the key identifies")
+	algorithm := flag.String("alg", string(auth.Ed25519), "Algorithm (ed25519 or hmac-sha256)")
+	clientFile := flag.String("clientFile", "", "Key file of the client")
+	serverFile := flag.String("serverFile", "", "Key file of the server")
+	flag.Parse()
+
+	if *id == "" || *clientName == "" || *clientFile == "" || *serverFile == "" {
+		fmt.Println("Please provide the key ID, the client and both key files")
+		os.Exit(1)
+	}
+	key, err := auth.GenerateKey(*id, *clientName, auth.Algorithm(*algorithm))
+	if err != nil {
+		fmt.Printf("Error generating key: %v\n", err)
+		os.Exit(1)
+	}
+	if err := addKey(*clientFile, key); err != nil {
+		fmt.Printf("Error writing client key file: %v\n", err)
+		os.Exit(1)
+	}
+	if err := addKey(*serverFile, key.Public()); err != nil {
+		fmt.Printf("Error writing server key file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Added key %s of %s\n", key.ID, key.Client)
+}
+
+// addKey appends key to the key file at path, creating it if needed. New
+// keys go last, where signers pick them up after a reload.
+func addKey(path string, key auth.Key) error {
+	var file auth.KeyFile
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &file); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	for _, k := range file.Keys {
+		if k.ID == key.ID {
+			return fmt.Errorf("key %s exists", key.ID)
+		}
+	}
+	file.Keys = append(file.Keys, key)
+	data, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"command-queue/client"
+	"command-queue/internal/cmdflags"
+	"command-queue/internal/util/hashring"
+	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+)
+
+const (
+	defaultBufferLength = 1000
+)
+
+// reshard moves keys between shards after shards were added or removed. It
+// reads every current shard through its replication log and sends addItem
+// and deleteItem commands for the keys the new layout assigns elsewhere.
+func main() {
+	queueType := flag.String("queue", "", "Type of queue (rabbitmq or aws)")
+	region := flag.String("region", "", "AWS region (required for aws)")
+	connectionString := flag.String("conn", "", "RabbitMQ connection string")
+	from := flag.String("from", "", "Comma separated shard=address pairs of the replication logs of the current shards")
+	to := flag.String("to", "", "Comma separated shard=queue pairs of the new layout (queue name for rabbitmq, URL for aws)")
+	dryRun := flag.Bool("dryRun", false, "Print the keys that would move without moving them")
+	writersStopped := flag.Bool("writersStopped", false, "Confirm that no client writes to the shards while keys are moved")
+	signing := cmdflags.NewSigning(flag.CommandLine)
+	encoding := cmdflags.NewEncoding(flag.CommandLine)
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		fmt.Println("Please provide the current shards (-from) and the new layout (-to)")
+		os.Exit(1)
+	}
+	sources, err := client.ParseShards(*from)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	targets, err := client.ParseShards(*to)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	ring := hashring.New(names, hashring.DefaultReplicas)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Setup signal handling for cancellation
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		fmt.Println("\nReceived SIGTERM or SIGINT. Cancelling all operations...")
+		cancel()
+	}()
+
+	shards := make([]string, 0, len(sources))
+	for shard := range sources {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	var moves []client.Move
+	for _, shard := range shards {
+		items, _, err := replication.FetchSnapshot(ctx, sources[shard])
+		if err != nil {
+			fmt.Printf("Error reading shard %s: %v\n", shard, err)
+			os.Exit(1)
+		}
+		planned := client.PlanMoves(shard, items, ring)
+		fmt.Printf("Shard %s: %d of %d keys move\n", shard, len(planned), len(items))
+		moves = append(moves, planned...)
+	}
+	if !*dryRun && !*writersStopped {
+		fmt.Println("Writes that reach a shard while its keys move are lost. Stop every writer, then run again with -writersStopped")
+		os.Exit(1)
+	}
+	if *dryRun {
+		for _, move := range moves {
+			if move.Namespace != "" {
+				fmt.Printf("@%s ", move.Namespace)
+			}
+			fmt.Printf("%s: %s -> %s\n", move.Key, move.From, move.To)
+		}
+		return
+	}
+
+	// Shards that are not part of the new layout are retired, so keys are
+	// not deleted from them.
+	encoder, err := encoding.Encoder()
+	if err != nil {
+		fmt.Printf("Error %v\n", err)
+		os.Exit(1)
+	}
+	queues := make(map[string]queue.Queue, len(targets))
+	for shard, name := range targets {
+		var q queue.Queue
+		switch *queueType {
+		case "rabbitmq":
+			q, err = queue.NewRabbitMQQueue(ctx, *connectionString, name, defaultBufferLength)
+		case "aws":
+			q, err = queue.NewSQSQueue(*region, name, defaultBufferLength)
+		default:
+			fmt.Println("Invalid queue type. Supported types: rabbitmq, aws")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("Error creating queue of shard %s: %v\n", shard, err)
+			os.Exit(1)
+		}
+		defer q.Close()
+		if q, err = signing.Wrap(encoder.Wrap(q, nil)); err != nil {
+			fmt.Printf("Error %v\n", err)
+			os.Exit(1)
+		}
+		queues[shard] = q
+	}
+
+	moved, err := client.ApplyMoves(moves, queues)
+	fmt.Printf("Moved %d of %d keys\n", moved, len(moves))
+	if err != nil {
+		fmt.Printf("Error moving keys: %v\n", err)
+		fmt.Println("Run the tool again to move the remaining keys")
+		os.Exit(1)
+	}
+	if len(moves) > 0 {
+		fmt.Println("Run the tool again once the servers applied the moves; it should find no keys to move")
+	}
+}
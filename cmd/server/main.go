@@ -4,11 +4,21 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"command-queue/internal/cmdflags"
+	"command-queue/internal/types"
+	"command-queue/internal/util/audit"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/election"
 	"command-queue/internal/util/logger"
+	"command-queue/internal/util/orderedmap"
+	"command-queue/internal/util/policy"
+	"command-queue/internal/util/tracing"
 	"command-queue/server"
 
 	"command-queue/internal/util/queue"
@@ -28,8 +38,47 @@ func main() {
 	connectionString := flag.String("conn", "", "RabbitMQ connection string")
 	queuName := flag.String("queueName", "", "Queue name")
 	maxWorkers := flag.Int("maxWorkers", defaultMaxWorkers, "Maximum number of workers")
+	mapOrder := flag.String("mapOrder", "insertion", "Order of the items (insertion or sorted)")
+	shards := flag.Int("shards", 0, "Number of lock shards of the map (0 uses a single lock)")
+	maxNamespaces := flag.Int("maxNamespaces", 0, "Maximum number of namespaces besides the default one (0 is unlimited)")
+	namespaceQuota := flag.Int("namespaceQuota", 0, "Maximum number of items of a namespace unless createNamespace sets one (0 is unlimited)")
+	snapshotTTL := flag.Duration("snapshotTTL", 5*time.Minute, "How long an unused snapshot stays readable")
+	idempotencyWindow := flag.Duration("idempotencyWindow", 10*time.Minute, "How long idempotency keys of applied messages are remembered (0 disables deduplication)")
+	feedQueue := flag.String("feedQueue", "", "Queue name (rabbitmq) or URL (aws) that watched changes are sent to")
+	feedRetention := flag.Int("feedRetention", 10000, "Number of change events kept for resuming watches")
+	replicationAddr := flag.String("replicationAddr", "", "Address to serve the replication log to replicas on")
+	replicationRetention := flag.Int("replicationRetention", 10000, "Number of log entries kept for reconnecting replicas")
+	replicaOf := flag.String("replicaOf", "", "Address of the primary to replicate (promote with SIGUSR1)")
+	httpAddr := flag.String("httpAddr", "", "Address of the HTTP admin and query API (disabled if empty)")
+	leaseFile := flag.String("leaseFile", "", "Lease file shared by instances consuming the same queue; only the leader consumes")
+	leaseTTL := flag.Duration("leaseTTL", 10*time.Second, "How long the leader's lease lasts without renewal")
+	instanceID := flag.String("id", "", "Instance ID used in the lease (defaults to host name and process ID)")
+	advertiseAddr := flag.String("advertiseAddr", "", "Replication address published in the lease (defaults to replicationAddr)")
+	traceFile := flag.String("traceFile", "", "File that spans are appended to as JSON lines")
+	otlpEndpoint := flag.String("otlpEndpoint", "", "OTLP/HTTP collector that spans are exported to (e.g. http://localhost:4318)")
+	auditLog := flag.String("auditLog", "", "File that every change of the map is recorded in (disabled if empty)")
+	auditMaxSize := flag.Int64("auditMaxSize", 100<<20, "Size in bytes after which the audit log is rotated (0 disables rotation)")
+	auditMaxFiles := flag.Int("auditMaxFiles", 0, "Number of rotated audit log files kept (0 keeps all)")
+	keyFile := flag.String("keyFile", "", "Key file of the clients; unsigned and replayed messages are rejected (reload with SIGHUP)")
+	signatureWindow := flag.Duration("signatureWindow", auth.DefaultWindow, "How far a message's signature timestamp may be from the server's clock")
+	policyFile := flag.String("policyFile", "", "Policy file of the commands and keys each client may use (reload with SIGHUP)")
+	deadLetterQueue := flag.String("deadLetterQueue", "", "Queue name (rabbitmq) or URL (aws) that rejected and denied messages are sent to")
+	encoding := cmdflags.NewEncoding(flag.CommandLine)
+	logLevel := flag.String("logLevel", "info", "Log level, optionally per component (e.g. info,replication=debug)")
+	logFormat := flag.String("logFormat", "text", "Log format (text or json)")
 	flag.Parse()
 
+	levels, err := logger.ParseLevels(*logLevel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	log, err := logger.NewSlogLogger(os.Stderr, logger.Format(*logFormat), levels)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	// Check if required arguments are provided
 	if *queueType == "" {
 		fmt.Println("Please provide a queue type (rabbitmq or aws)")
@@ -39,6 +88,37 @@ func main() {
 		fmt.Println("maxWorkers must be a positive integer")
 		os.Exit(1)
 	}
+	if *leaseFile != "" && *replicaOf != "" {
+		fmt.Println("leaseFile and replicaOf cannot be combined; standbys follow the leader")
+		os.Exit(1)
+	}
+	if *leaseFile != "" && *replicationAddr == "" {
+		fmt.Println("leaseFile needs replicationAddr; a standby that takes over only has the data it replicated from the leader")
+		os.Exit(1)
+	}
+	if *shards < 0 {
+		fmt.Println("shards must not be negative")
+		os.Exit(1)
+	}
+	// Every namespace has a map of the same kind.
+	var newMap func() orderedmap.Map
+	switch *mapOrder {
+	case "insertion":
+		if *shards > 0 {
+			newMap = func() orderedmap.Map { return orderedmap.NewShardedMap(*shards) }
+		} else {
+			newMap = func() orderedmap.Map { return orderedmap.NewOrderedMap() }
+		}
+	case "sorted":
+		if *shards > 0 {
+			fmt.Println("shards are only supported with insertion order")
+			os.Exit(1)
+		}
+		newMap = func() orderedmap.Map { return orderedmap.NewSortedMap() }
+	default:
+		fmt.Println("Invalid map order. Supported orders: insertion, sorted")
+		os.Exit(1)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -54,7 +134,6 @@ func main() {
 
 	// Initialize queue based on the provided type
 	var q queue.Queue
-	var err error
 	switch *queueType {
 	case "rabbitmq":
 		if *connectionString == "" {
@@ -87,11 +166,180 @@ func main() {
 	}
 	defer q.Close()
 
+	encoder, err := encoding.Encoder()
+	if err != nil {
+		fmt.Printf("Error %v\n", err)
+		os.Exit(1)
+	}
+	q = encoder.Wrap(q, func(err error) {
+		log.Warn("Dropped message that could not be decoded", logger.KeyError, err)
+	})
+
+	opts := []server.Option{
+		server.WithMap(newMap()),
+		server.WithNamespaceMaps(newMap),
+		server.WithNamespaceLimits(*maxNamespaces, *namespaceQuota),
+		server.WithSnapshotTTL(*snapshotTTL),
+		server.WithIdempotencyWindow(*idempotencyWindow),
+		server.WithFeedRetention(*feedRetention),
+	}
+
+	// The change feed and dead-letter queues live on the same broker as the
+	// command queue, and are encoded like it.
+	sideQueue := func(name string) (queue.Queue, error) {
+		var sq queue.Queue
+		var err error
+		if *queueType == "rabbitmq" {
+			sq, err = queue.NewRabbitMQQueue(ctx, *connectionString, name, defaultBufferLength)
+		} else {
+			sq, err = queue.NewSQSQueue(*region, name, defaultBufferLength)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return encoder.Wrap(sq, nil), nil
+	}
+	if *feedQueue != "" {
+		fq, err := sideQueue(*feedQueue)
+		if err != nil {
+			fmt.Printf("Error creating change feed queue: %v\n", err)
+			os.Exit(1)
+		}
+		defer fq.Close()
+		opts = append(opts, server.WithChangeFeed(fq))
+	}
+	if *deadLetterQueue != "" {
+		dq, err := sideQueue(*deadLetterQueue)
+		if err != nil {
+			fmt.Printf("Error creating dead-letter queue: %v\n", err)
+			os.Exit(1)
+		}
+		defer dq.Close()
+		opts = append(opts, server.WithDeadLetter(dq))
+	}
+	if *replicationAddr != "" {
+		opts = append(opts, server.WithReplicationLog(*replicationRetention))
+	}
+	if *replicaOf != "" {
+		opts = append(opts, server.WithReplicaOf(*replicaOf))
+	}
+	if *auditLog != "" {
+		al, err := audit.Open(*auditLog, *auditMaxSize, *auditMaxFiles)
+		if err != nil {
+			fmt.Printf("Error opening audit log: %v\n", err)
+			os.Exit(1)
+		}
+		defer al.Close()
+		opts = append(opts, server.WithAuditLog(al))
+	}
+	reloaders := make(map[string]func() error)
+	if encoder.Keys != nil {
+		reloaders["encryption keys"] = encoder.Keys.Reload
+	}
+	if *keyFile != "" {
+		keys, err := auth.LoadKeyring(*keyFile)
+		if err != nil {
+			fmt.Printf("Error loading keys: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithVerifier(auth.NewVerifier(keys, *signatureWindow)))
+		reloaders["keys"] = keys.Reload
+	}
+	if *policyFile != "" {
+		p, err := policy.Load(*policyFile, types.IsCommandType)
+		if err != nil {
+			fmt.Printf("Error loading policy: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithPolicy(p))
+		reloaders["policy"] = p.Reload
+	}
+
+	// Keys and policies are changed by editing their files and sending SIGHUP.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			for name, fn := range reloaders {
+				if err := fn(); err != nil {
+					log.Error("Error reloading "+name, logger.KeyError, err)
+				} else {
+					log.Info("Reloaded " + name)
+				}
+			}
+		}
+	}()
+	exporter, err := tracing.NewExporter(*traceFile, *otlpEndpoint)
+	if err != nil {
+		fmt.Printf("Error creating trace exporter: %v\n", err)
+		os.Exit(1)
+	}
+	if exporter != nil {
+		tracer := tracing.NewTracer("command-queue-server", exporter, func(err error) {
+			log.Warn("Error exporting spans", logger.KeyError, err)
+		})
+		defer tracer.Close()
+		opts = append(opts, server.WithTracer(tracer))
+	}
+
 	// Initialize server
-	s := server.NewServer(q, logger.NewConsoleLogger(), *maxWorkers)
+	s := server.NewServer(q, log, *maxWorkers, opts...)
+	defer s.Stop()
+
+	if *replicationAddr != "" {
+		ln, err := net.Listen("tcp", *replicationAddr)
+		if err != nil {
+			fmt.Printf("Error listening for replicas: %v\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := s.ServeReplication(ctx, ln); err != nil {
+				log.Error("Error serving replicas", logger.KeyError, err)
+			}
+		}()
+	}
+
+	if *httpAddr != "" {
+		ln, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			fmt.Printf("Error listening for HTTP: %v\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := s.ServeAdmin(ctx, ln); err != nil {
+				log.Error("Error serving HTTP", logger.KeyError, err)
+			}
+		}()
+	}
+
+	// A replica is promoted to primary on SIGUSR1.
+	promote := make(chan os.Signal, 1)
+	signal.Notify(promote, syscall.SIGUSR1)
+	go func() {
+		for range promote {
+			if err := s.Promote(); err != nil {
+				log.Error("Error promoting server", logger.KeyError, err)
+			}
+		}
+	}()
 
 	// Run the server
-	if err := s.Start(ctx); err != nil {
+	if *leaseFile != "" {
+		id := *instanceID
+		if id == "" {
+			host, _ := os.Hostname()
+			id = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		addr := *advertiseAddr
+		if addr == "" {
+			addr = *replicationAddr
+		}
+		elector := election.NewElector(election.NewFileStore(*leaseFile), id, addr, *leaseTTL, log)
+		err = s.Lead(ctx, elector)
+	} else {
+		err = s.Start(ctx)
+	}
+	if err != nil {
 		fmt.Printf("Error running server: %v\n", err)
 		os.Exit(1)
 	}
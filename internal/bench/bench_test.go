@@ -0,0 +1,205 @@
+package bench
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/queue"
+	"command-queue/server"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := ParseMix("add=70, delete=10,getAll=0")
+	if err != nil {
+		t.Fatalf("ParseMix returned an error: %v", err)
+	}
+	if mix[OpAdd] != 70 || mix[OpDelete] != 10 || mix[OpGet] != 0 {
+		t.Errorf("unexpected mix %v", mix)
+	}
+	if got := mix.String(); got != "add=70,delete=10" {
+		t.Errorf("String() = %q", got)
+	}
+	for _, s := range []string{"", "add", "put=1", "add=-1", "get=0"} {
+		if _, err := ParseMix(s); err == nil {
+			t.Errorf("ParseMix(%q) did not fail", s)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	cfg := Config{Mix: Mix{OpAdd: 1, OpDelete: 1}, Keys: 100, Distribution: Zipfian, ValueSize: 40, Prefix: "b:"}
+	g, err := newGenerator(cfg)
+	if err != nil {
+		t.Fatalf("newGenerator returned an error: %v", err)
+	}
+	live := map[string]bool{}
+	counts := map[string]int{}
+	now := time.Unix(1700000000, 123)
+	for i := 0; i < 10000; i++ {
+		c := g.next(now)
+		counts[c.key]++
+		switch c.op {
+		case OpAdd:
+			if !c.expected || !strings.HasPrefix(c.message, "addItem('"+c.key+"', '") {
+				t.Fatalf("unexpected add %+v", c)
+			}
+			value := strings.TrimSuffix(strings.SplitN(c.message, "', '", 2)[1], "')")
+			if len(value) != 40 {
+				t.Errorf("value %q has %d bytes", value, len(value))
+			}
+			if at, ok := valueTime(value); !ok || !at.Equal(now) {
+				t.Errorf("valueTime(%q) = %v, %v", value, at, ok)
+			}
+			live[c.key] = true
+		case OpDelete:
+			if c.expected != live[c.key] {
+				t.Fatalf("delete of %s expected %v", c.key, c.expected)
+			}
+			delete(live, c.key)
+		default:
+			t.Fatalf("unexpected op %s", c.op)
+		}
+	}
+	// The first key is the hottest.
+	if counts["b:0"] < 10*counts["b:50"] {
+		t.Errorf("keys are not skewed: b:0 %d, b:50 %d", counts["b:0"], counts["b:50"])
+	}
+
+	if _, err := newGenerator(Config{Mix: Mix{OpGet: 1}, Keys: 10, Distribution: Zipfian, ZipfS: 1}); err == nil {
+		t.Error("expected an error for a skew of 1")
+	}
+}
+
+func TestLatencies_Percentile(t *testing.T) {
+	var l Latencies
+	if l.Percentile(50) != 0 {
+		t.Error("expected 0 without samples")
+	}
+	for i := 100; i > 0; i-- {
+		l.Add(time.Duration(i) * time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 99.5: 100 * time.Millisecond, 100: 100 * time.Millisecond, 0: time.Millisecond} {
+		if got := l.Percentile(p); got != want {
+			t.Errorf("Percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestTracker_Reordered(t *testing.T) {
+	report := newReport()
+	tr := newTracker("b:", report)
+	now := time.Unix(1700000000, 0)
+	g := &generator{valueSize: DefaultValueSize}
+	first, second := now, now.Add(time.Millisecond)
+	tr.expect(command{op: OpAdd, key: "b:1", at: first})
+	tr.expect(command{op: OpAdd, key: "b:2", at: second})
+
+	// The later change arrives first, and both are delivered twice.
+	events := []changefeed.Event{
+		{Seq: 8, Op: changefeed.OpSet, Key: "b:2", New: g.value(second)},
+		{Seq: 7, Op: changefeed.OpSet, Key: "b:1", New: g.value(first)},
+		{Seq: 8, Op: changefeed.OpSet, Key: "b:2", New: g.value(second)},
+		{Seq: 7, Op: changefeed.OpSet, Key: "b:1", New: g.value(first)},
+	}
+	for _, event := range events {
+		tr.observe(event, now.Add(time.Second))
+	}
+	if n := report.Ops[OpAdd].EndToEnd.Count(); n != 2 || tr.waiting != 0 {
+		t.Errorf("observed %d adds, %d waiting", n, tr.waiting)
+	}
+}
+
+func TestTracker_FirstSeen(t *testing.T) {
+	tr := newTracker("b:", newReport())
+	for _, seq := range []uint64{5, 3, 4} {
+		if !tr.firstSeen(seq) {
+			t.Errorf("%d was not seen first", seq)
+		}
+	}
+	if tr.firstSeen(3) {
+		t.Error("3 was seen first twice")
+	}
+
+	// Far behind the largest number, events are taken for redeliveries.
+	last := uint64(3*dedupWindow + 10)
+	if !tr.firstSeen(last) {
+		t.Errorf("%d was not seen first", last)
+	}
+	if tr.firstSeen(6) || !tr.firstSeen(last-1) {
+		t.Errorf("unexpected low-water mark %d", tr.low)
+	}
+	if len(tr.seen) != 2 {
+		t.Errorf("%d sequence numbers are kept", len(tr.seen))
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := queue.NewMemQueue(100)
+	feed := queue.NewMemQueue(100)
+	s := server.NewServer(q, logger.NewTestLogger(), 4, server.WithChangeFeed(feed))
+	go s.Start(ctx)
+	defer s.Stop()
+
+	cfg := Config{
+		Mix:       Mix{OpAdd: 3, OpDelete: 1},
+		Keys:      50,
+		ValueSize: DefaultValueSize,
+		Workers:   4,
+		Count:     500,
+		Prefix:    "bench:test:",
+		Drain:     time.Second,
+	}
+	report, err := Run(ctx, q, feed, cfg)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if report.Sent() != 500 || report.Failed() != 0 {
+		t.Errorf("sent %d, failed %d", report.Sent(), report.Failed())
+	}
+	add := report.Ops[OpAdd]
+	if add.Send.Count() != add.Sent {
+		t.Errorf("%d send latencies for %d adds", add.Send.Count(), add.Sent)
+	}
+	// Parallel workers can reorder writes of a key, so a few deletes may
+	// find nothing to delete.
+	observed := add.EndToEnd.Count() + report.Ops[OpDelete].EndToEnd.Count()
+	if observed < 400 || report.Unobserved > 20 {
+		t.Errorf("observed %d writes, %d unobserved", observed, report.Unobserved)
+	}
+
+	var sb strings.Builder
+	if err := report.Write(&sb); err != nil {
+		t.Fatalf("Write returned an error: %v", err)
+	}
+	out := sb.String()
+	for _, want := range []string{"sent 500 in ", "unobserved writes ", "e2e n", " add "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "getAll") {
+		t.Errorf("report lists ops that were not sent:\n%s", out)
+	}
+}
+
+func TestRun_Rate(t *testing.T) {
+	q := queue.NewMemQueue(1000)
+	cfg := Config{Mix: Mix{OpGet: 1}, Keys: 10, Rate: 200, Workers: 2, Count: 40}
+	report, err := Run(context.Background(), q, nil, cfg)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	// 40 commands at 200/s are due over 195ms.
+	if report.Elapsed < 190*time.Millisecond {
+		t.Errorf("sent 40 commands in %v", report.Elapsed)
+	}
+	if report.EndToEnd || report.Ops[OpGet].Sent != 40 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,222 @@
+// Package bench generates synthetic command loads, sends them through a
+// queue and measures throughput and latency.
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"command-queue/internal/types"
+)
+
+// Op is a kind of command the generator sends.
+type Op string
+
+const (
+	OpAdd    Op = "add"
+	OpDelete Op = "delete"
+	OpGet    Op = "get"
+	OpGetAll Op = "getAll"
+)
+
+// ops lists the ops in the order reports show them.
+var ops = []Op{OpAdd, OpDelete, OpGet, OpGetAll}
+
+// Mix is the relative frequency of each op.
+type Mix map[Op]int
+
+// DefaultMix is mostly writes with some single-key reads.
+var DefaultMix = Mix{OpAdd: 70, OpDelete: 10, OpGet: 20}
+
+// ParseMix parses a mix such as "add=70,delete=10,get=15,getAll=5". Ops that
+// are not listed are not sent.
+func ParseMix(s string) (Mix, error) {
+	mix := Mix{}
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		op := Op(name)
+		if !ok || !isOp(op) {
+			return nil, fmt.Errorf("invalid mix entry %q (op=weight with op add, delete, get or getAll)", part)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid weight %q of %s", weight, op)
+		}
+		mix[op] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("mix %q has no ops", s)
+	}
+	return mix, nil
+}
+
+func isOp(op Op) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the mix in the format of ParseMix.
+func (m Mix) String() string {
+	var parts []string
+	for _, op := range ops {
+		if m[op] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", op, m[op]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Distribution is how keys are drawn.
+type Distribution string
+
+const (
+	// Uniform draws every key equally often.
+	Uniform Distribution = "uniform"
+	// Zipfian draws key n with a probability proportional to 1/(n+1)^s, so a
+	// few hot keys get most of the commands.
+	Zipfian Distribution = "zipfian"
+)
+
+// DefaultZipfS is the skew of the zipfian distribution.
+const DefaultZipfS = 1.1
+
+// ParseDistribution parses the name of a key distribution.
+func ParseDistribution(name string) (Distribution, error) {
+	switch d := Distribution(name); d {
+	case Uniform, Zipfian:
+		return d, nil
+	}
+	return "", fmt.Errorf("invalid key distribution %q (uniform or zipfian)", name)
+}
+
+// command is a generated command.
+type command struct {
+	op      Op
+	key     string
+	message string
+	// at is the time the command was due. Adds carry it in their value.
+	at time.Time
+	// expected is set for writes whose change event is awaited: adds, and
+	// deletes of keys that the generator added.
+	expected bool
+	// added is the time of the add an expected delete removes.
+	added time.Time
+}
+
+// generator draws commands from a mix. It is not safe for concurrent use.
+type generator struct {
+	rng       *rand.Rand
+	ops       []Op
+	weights   []int // cumulative
+	total     int
+	keys      int
+	zipf      *rand.Zipf
+	prefix    string
+	valueSize int
+	// live holds the time of the last add of the keys added and not deleted
+	// since.
+	live map[int]time.Time
+}
+
+func newGenerator(cfg Config) (*generator, error) {
+	if cfg.Keys < 1 {
+		return nil, fmt.Errorf("keys must be at least 1")
+	}
+	g := &generator{
+		rng:       rand.New(rand.NewSource(cfg.Seed)),
+		keys:      cfg.Keys,
+		prefix:    cfg.Prefix,
+		valueSize: cfg.ValueSize,
+		live:      make(map[int]time.Time),
+	}
+	for _, op := range ops {
+		if cfg.Mix[op] > 0 {
+			g.total += cfg.Mix[op]
+			g.ops = append(g.ops, op)
+			g.weights = append(g.weights, g.total)
+		}
+	}
+	if g.total == 0 {
+		return nil, fmt.Errorf("mix has no ops")
+	}
+	switch cfg.Distribution {
+	case Uniform, "":
+	case Zipfian:
+		s := cfg.ZipfS
+		if s == 0 {
+			s = DefaultZipfS
+		}
+		if s <= 1 {
+			return nil, fmt.Errorf("zipfian skew must be greater than 1")
+		}
+		g.zipf = rand.NewZipf(g.rng, s, 1, uint64(cfg.Keys-1))
+	default:
+		return nil, fmt.Errorf("invalid key distribution %q", cfg.Distribution)
+	}
+	return g, nil
+}
+
+// next returns the next command, due at now.
+func (g *generator) next(now time.Time) command {
+	n := g.rng.Intn(g.total)
+	op := g.ops[sort.SearchInts(g.weights, n+1)]
+	if op == OpGetAll {
+		return command{op: op, message: types.NewGetAllCommand().String()}
+	}
+
+	k := g.key()
+	c := command{op: op, key: g.prefix + strconv.Itoa(k), at: now}
+	switch op {
+	case OpAdd:
+		c.expected = true
+		c.message = types.NewAddCommand(c.key, g.value(now)).String()
+		g.live[k] = now
+	case OpDelete:
+		c.added, c.expected = g.live[k]
+		c.message = types.NewDeleteCommand(c.key).String()
+		delete(g.live, k)
+	case OpGet:
+		c.message = types.NewGetItemCommand(c.key).String()
+	}
+	return c
+}
+
+func (g *generator) key() int {
+	if g.zipf != nil {
+		return int(g.zipf.Uint64())
+	}
+	return g.rng.Intn(g.keys)
+}
+
+// value returns a value of valueSize bytes, or longer if that is too short
+// for the timestamp, that starts with the Unix time of now in nanoseconds.
+func (g *generator) value(now time.Time) string {
+	v := strconv.FormatInt(now.UnixNano(), 10) + "."
+	if pad := g.valueSize - len(v); pad > 0 {
+		v += strings.Repeat("x", pad)
+	}
+	return v
+}
+
+// valueTime returns the time a generated value was stamped with.
+func valueTime(value string) (time.Time, bool) {
+	stamp, _, ok := strings.Cut(value, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
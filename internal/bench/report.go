@@ -0,0 +1,176 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Latencies collects latency samples. It is safe for concurrent use.
+type Latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	sorted  bool
+}
+
+// Add records a sample.
+func (l *Latencies) Add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples = append(l.samples, d)
+	l.sorted = false
+}
+
+// Count returns the number of samples.
+func (l *Latencies) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.samples)
+}
+
+// Percentile returns the smallest sample that is at least as large as p
+// percent of the samples, or 0 without samples.
+func (l *Latencies) Percentile(p float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) == 0 {
+		return 0
+	}
+	if !l.sorted {
+		sort.Slice(l.samples, func(i, j int) bool { return l.samples[i] < l.samples[j] })
+		l.sorted = true
+	}
+	rank := int(p / 100 * float64(len(l.samples)))
+	if float64(rank) < p/100*float64(len(l.samples)) {
+		rank++
+	}
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(l.samples) {
+		rank = len(l.samples)
+	}
+	return l.samples[rank-1]
+}
+
+// OpReport is the outcome of the commands of one op.
+type OpReport struct {
+	Sent   int
+	Failed int
+	// Send is the time the queue took to accept a command.
+	Send Latencies
+	// EndToEnd is the time from sending a write to receiving its change
+	// event. Reads do not produce events, so they have no samples.
+	EndToEnd Latencies
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	// Elapsed is the time it took to send the commands.
+	Elapsed time.Duration
+	Ops     map[Op]*OpReport
+	// Unobserved counts the writes whose change event was not received.
+	Unobserved int
+	// EndToEnd is set if the run measured end-to-end latencies.
+	EndToEnd bool
+
+	mu sync.Mutex
+}
+
+func newReport() *Report {
+	r := &Report{Ops: make(map[Op]*OpReport, len(ops))}
+	for _, op := range ops {
+		r.Ops[op] = &OpReport{}
+	}
+	return r
+}
+
+// count records a command of op that the queue accepted or not.
+func (r *Report) count(op Op, sent bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sent {
+		r.Ops[op].Sent++
+	} else {
+		r.Ops[op].Failed++
+	}
+}
+
+// Sent returns the number of commands the queue accepted.
+func (r *Report) Sent() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, o := range r.Ops {
+		n += o.Sent
+	}
+	return n
+}
+
+// Failed returns the number of commands the queue did not accept.
+func (r *Report) Failed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, o := range r.Ops {
+		n += o.Failed
+	}
+	return n
+}
+
+// Throughput returns the commands sent per second.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Sent()) / r.Elapsed.Seconds()
+}
+
+// percentiles are the percentiles a report shows.
+var percentiles = []float64{50, 90, 99, 100}
+
+// Write writes the report as a table with a row per op.
+func (r *Report) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "sent %d in %s (%.1f commands/s), failed %d\n",
+		r.Sent(), r.Elapsed.Round(time.Millisecond), r.Throughput(), r.Failed()); err != nil {
+		return err
+	}
+	if r.EndToEnd {
+		if _, err := fmt.Fprintf(w, "unobserved writes %d\n", r.Unobserved); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "op\tsent\tfailed\tsend p50\tp90\tp99\tmax\t")
+	if r.EndToEnd {
+		fmt.Fprint(tw, "e2e n\tp50\tp90\tp99\tmax\t")
+	}
+	fmt.Fprintln(tw)
+	for _, op := range ops {
+		o := r.Ops[op]
+		if o.Sent == 0 && o.Failed == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t", op, o.Sent, o.Failed)
+		writePercentiles(tw, &o.Send)
+		if r.EndToEnd {
+			if n := o.EndToEnd.Count(); n > 0 {
+				fmt.Fprintf(tw, "%d\t", n)
+				writePercentiles(tw, &o.EndToEnd)
+			} else {
+				fmt.Fprint(tw, "-\t-\t-\t-\t-\t")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func writePercentiles(w io.Writer, l *Latencies) {
+	for _, p := range percentiles {
+		fmt.Fprintf(w, "%s\t", l.Percentile(p).Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,376 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/queue"
+)
+
+// Defaults of Config.
+const (
+	DefaultKeys      = 10000
+	DefaultValueSize = 32
+	DefaultWorkers   = 8
+	DefaultDrain     = 10 * time.Second
+)
+
+// probeInterval is how often Run sends the probe write while it waits for
+// the watch to take effect, and probeTimeout how long it waits.
+const (
+	probeInterval = 200 * time.Millisecond
+	probeTimeout  = 10 * time.Second
+)
+
+// dedupWindow is how far below the largest sequence number seen an event
+// is still told apart from a redelivery. Older events are dropped.
+const dedupWindow = 100000
+
+// ErrNoEvents is returned by Run if the server does not send change events
+// to the feed queue.
+var ErrNoEvents = errors.New("no change events received; is the server started with a feed queue?")
+
+// Config describes a load.
+type Config struct {
+	Mix          Mix
+	Keys         int
+	Distribution Distribution
+	// ZipfS is the skew of the zipfian distribution (default DefaultZipfS).
+	ZipfS     float64
+	ValueSize int
+	// Rate is the number of commands sent per second, or 0 to send as fast
+	// as the workers can.
+	Rate    float64
+	Workers int
+	// The run ends after Duration or Count commands, whichever comes first.
+	// Zero disables a limit.
+	Duration time.Duration
+	Count    int
+	// Prefix is prepended to the keys, so that runs do not touch the keys of
+	// each other or of other clients.
+	Prefix string
+	Seed   int64
+	// Drain is how long Run waits for the change events of the last writes.
+	Drain time.Duration
+}
+
+// Run sends the load of cfg to q and reports its throughput and the time q
+// took to accept each command. With a feed queue, Run watches the keys of
+// the load and also reports the end-to-end latency of writes: the time from
+// sending a write to receiving its change event. Adds carry their send time
+// in their value, which matches them to their events; deletes are matched
+// by the value they remove. The run stops early when ctx is done.
+func Run(ctx context.Context, q, feed queue.Queue, cfg Config) (*Report, error) {
+	g, err := newGenerator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	report := newReport()
+	var t *tracker
+	if feed != nil {
+		report.EndToEnd = true
+		events, err := feed.ReceiveMessage()
+		if err != nil {
+			return nil, fmt.Errorf("error receiving change events: %v", err)
+		}
+		t = newTracker(cfg.Prefix, report)
+		go t.consume(events)
+		if err := t.watch(ctx, q); err != nil {
+			return nil, err
+		}
+	}
+
+	runCtx := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	jobs := make(chan command)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				o := report.Ops[c.op]
+				start := time.Now()
+				err := q.SendMessage(c.message)
+				o.Send.Add(time.Since(start))
+				report.count(c.op, err == nil)
+				if err != nil && t != nil && c.expected {
+					t.cancel(c)
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := 0; cfg.Count == 0 || i < cfg.Count; i++ {
+		now := time.Now()
+		if cfg.Rate > 0 {
+			// Commands are stamped with the time they were due, so that a
+			// queue that falls behind shows in the latencies.
+			due := start.Add(time.Duration(float64(i) / cfg.Rate * float64(time.Second)))
+			if wait := due.Sub(now); wait > 0 {
+				select {
+				case <-runCtx.Done():
+				case <-time.After(wait):
+				}
+			}
+			now = due
+		}
+		if runCtx.Err() != nil {
+			break
+		}
+		c := g.next(now)
+		if c.expected && t != nil {
+			t.expect(c)
+		}
+		select {
+		case <-runCtx.Done():
+			if c.expected && t != nil {
+				t.cancel(c)
+			}
+		case jobs <- c:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	if t != nil {
+		t.drain(ctx, cfg.Drain)
+		// The watch is removed on a best effort basis.
+		_ = q.SendMessage(types.NewUnwatchPrefixCommand(cfg.Prefix).String())
+		t.finish()
+	}
+	return report, nil
+}
+
+// addKey identifies an add by its key and send time.
+type addKey struct {
+	key   string
+	nanos int64
+}
+
+// tracker matches change events to the writes of a run.
+type tracker struct {
+	prefix string
+	probe  string
+	report *Report
+
+	mu     sync.Mutex
+	probed chan struct{}
+	// adds counts the adds that wait for their event by send time.
+	adds map[int64]int
+	// deletes holds the send times of the deletes that wait for their
+	// event by the add they remove.
+	deletes map[addKey]time.Time
+	// waiting counts the writes in adds and deletes.
+	waiting int
+	// seen holds the sequence numbers of the events observed that are not
+	// below low, the low-water mark; maxSeq is the largest of them.
+	seen   map[uint64]bool
+	low    uint64
+	maxSeq uint64
+	idle   chan struct{}
+}
+
+func newTracker(prefix string, report *Report) *tracker {
+	return &tracker{
+		prefix:  prefix,
+		probe:   prefix + "probe",
+		report:  report,
+		probed:  make(chan struct{}),
+		adds:    make(map[int64]int),
+		deletes: make(map[addKey]time.Time),
+		seen:    make(map[uint64]bool),
+	}
+}
+
+// watch watches the keys of the run and waits until the watch is in effect,
+// which it detects by writing a probe key until its change event arrives.
+func (t *tracker) watch(ctx context.Context, q queue.Queue) error {
+	if err := q.SendMessage(types.NewWatchPrefixCommand(t.prefix, 0).String()); err != nil {
+		return fmt.Errorf("error sending watch: %v", err)
+	}
+	timeout := time.After(probeTimeout)
+	for i := 0; ; i++ {
+		if err := q.SendMessage(types.NewAddCommand(t.probe, fmt.Sprint(i)).String()); err != nil {
+			return fmt.Errorf("error sending probe: %v", err)
+		}
+		select {
+		case <-t.probed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return ErrNoEvents
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// expect registers a write whose event is awaited.
+func (t *tracker) expect(c command) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.op == OpAdd {
+		t.adds[c.at.UnixNano()]++
+	} else {
+		t.deletes[addKey{c.key, c.added.UnixNano()}] = c.at
+	}
+	t.waiting++
+}
+
+// cancel forgets a write that was not sent.
+func (t *tracker) cancel(c command) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.op == OpAdd {
+		t.forgetAdd(c.at.UnixNano())
+	} else if _, ok := t.deletes[addKey{c.key, c.added.UnixNano()}]; ok {
+		delete(t.deletes, addKey{c.key, c.added.UnixNano()})
+		t.waiting--
+	}
+	t.signalIdle()
+}
+
+// forgetAdd stops waiting for an add sent at nanos. The caller must hold
+// mu.
+func (t *tracker) forgetAdd(nanos int64) {
+	if t.adds[nanos] <= 1 {
+		delete(t.adds, nanos)
+	} else {
+		t.adds[nanos]--
+	}
+	t.waiting--
+}
+
+// signalIdle wakes drain once no write waits. The caller must hold mu.
+func (t *tracker) signalIdle() {
+	if t.idle != nil && t.waiting == 0 {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// consume matches the events of the feed queue to the writes.
+func (t *tracker) consume(events <-chan string) {
+	for message := range events {
+		var event changefeed.Event
+		if err := json.Unmarshal([]byte(message), &event); err != nil || !strings.HasPrefix(event.Key, t.prefix) {
+			continue
+		}
+		t.observe(event, time.Now())
+	}
+}
+
+func (t *tracker) observe(event changefeed.Event, now time.Time) {
+	if event.Key == t.probe {
+		select {
+		case <-t.probed:
+		default:
+			close(t.probed)
+		}
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.firstSeen(event.Seq) {
+		return
+	}
+	switch event.Op {
+	case changefeed.OpSet:
+		value, _ := event.New.(string)
+		at, ok := valueTime(value)
+		if !ok {
+			return
+		}
+		if t.adds[at.UnixNano()] == 0 {
+			return
+		}
+		t.forgetAdd(at.UnixNano())
+		t.report.Ops[OpAdd].EndToEnd.Add(now.Sub(at))
+	case changefeed.OpDelete:
+		// The deleted value names the add, and so the delete.
+		value, _ := event.Old.(string)
+		added, ok := valueTime(value)
+		if !ok {
+			return
+		}
+		k := addKey{event.Key, added.UnixNano()}
+		at, ok := t.deletes[k]
+		if !ok {
+			return
+		}
+		delete(t.deletes, k)
+		t.waiting--
+		t.report.Ops[OpDelete].EndToEnd.Add(now.Sub(at))
+	}
+	t.signalIdle()
+}
+
+// firstSeen records seq and returns whether it is the first event with it.
+// Events are delivered at least once and, on queues such as SQS, out of
+// order, so an event may arrive after events with larger numbers. Those
+// more than dedupWindow below the largest number are taken for
+// redeliveries. The caller must hold mu.
+func (t *tracker) firstSeen(seq uint64) bool {
+	if seq < t.low || t.seen[seq] {
+		return false
+	}
+	t.seen[seq] = true
+	if seq > t.maxSeq {
+		t.maxSeq = seq
+	}
+	// The mark moves in steps of dedupWindow, so that pruning is cheap.
+	if t.maxSeq-t.low > 2*dedupWindow {
+		t.low = t.maxSeq - dedupWindow
+		for s := range t.seen {
+			if s < t.low {
+				delete(t.seen, s)
+			}
+		}
+	}
+	return true
+}
+
+// drain waits up to timeout for the events of the writes sent.
+func (t *tracker) drain(ctx context.Context, timeout time.Duration) {
+	t.mu.Lock()
+	if t.waiting == 0 {
+		t.mu.Unlock()
+		return
+	}
+	idle := make(chan struct{})
+	t.idle = idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	case <-time.After(timeout):
+	}
+}
+
+// finish records the writes whose event did not arrive.
+func (t *tracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.idle = nil
+	t.report.Unobserved = t.waiting
+}
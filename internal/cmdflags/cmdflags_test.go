@@ -0,0 +1,96 @@
+package cmdflags
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/queue"
+)
+
+func TestSigning(t *testing.T) {
+	k, err := auth.GenerateKey("k1", "reshard", auth.HMACSHA256)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data, _ := json.Marshal(auth.KeyFile{Keys: []auth.Key{k}})
+	os.WriteFile(path, data, 0o600)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	signing := NewSigning(fs)
+	mem := queue.NewMemQueue(10)
+	if q, err := signing.Wrap(mem); err != nil || q != queue.Queue(mem) {
+		t.Errorf("Expected the queue itself without keyFile but got %T, %v", q, err)
+	}
+
+	if err := fs.Parse([]string{"-keyFile", path}); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	q, err := signing.Wrap(mem)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if err := q.SendMessage("addItem('a', 'b')"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	messages, _ := queue.Receive(mem)
+	keys, _ := auth.NewKeyring(k)
+	if client, err := auth.NewVerifier(keys, auth.DefaultWindow).Verify(<-messages); err != nil || client != "reshard" {
+		t.Errorf("Expected reshard but got %q, %v", client, err)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	signing = NewSigning(fs)
+	fs.Parse([]string{"-keyFile", filepath.Join(t.TempDir(), "missing.json")})
+	if _, err := signing.Signer(); err == nil {
+		t.Errorf("Expected error for a missing key file")
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption.json")
+	data, _ := json.Marshal(queue.EncryptionKeyFile{Keys: []queue.EncryptionKey{{ID: "k1", Key: make([]byte, 32)}}})
+	os.WriteFile(path, data, 0o600)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	encoding := NewEncoding(fs)
+	if err := fs.Parse([]string{"-encryptionKeys", path, "-compression", "gzip", "-compressionThreshold", "1"}); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	encoder, err := encoding.Encoder()
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	body := "addItem('a', '" + strings.Repeat("b", 100) + "')"
+
+	// Bodies are compressed and then encrypted.
+	mem := queue.NewMemQueue(10)
+	if err := encoder.Wrap(mem, nil).SendMessage(body); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	encrypted, _ := queue.Receive(queue.NewEncryptedQueue(mem, encoder.Keys, nil))
+	if message := <-encrypted; message.Headers[queue.ContentEncodingHeader] != "gzip" {
+		t.Errorf("Expected a gzip body but got: %v", message.Headers)
+	}
+
+	mem = queue.NewMemQueue(10)
+	if err := encoder.Wrap(mem, nil).SendMessage(body); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	messages, _ := queue.Receive(encoder.Wrap(mem, nil))
+	if message := <-messages; message.Body != body {
+		t.Errorf("Expected the decoded body but got: %q", message.Body)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	encoding = NewEncoding(fs)
+	fs.Parse([]string{"-compression", "zstd"})
+	if _, err := encoding.Encoder(); err == nil {
+		t.Errorf("Expected error for an unknown codec")
+	}
+}
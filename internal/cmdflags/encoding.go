@@ -0,0 +1,76 @@
+package cmdflags
+
+import (
+	"flag"
+	"fmt"
+
+	"command-queue/internal/util/queue"
+)
+
+// Encoding holds the flags of how message bodies are encoded on the broker.
+// Every tool that sends to or receives from a server's queues needs the
+// same settings as the server.
+type Encoding struct {
+	encryptionKeys       *string
+	compression          *string
+	compressionThreshold *int
+	blobDir              *string
+	maxMessageSize       *int
+}
+
+// NewEncoding defines the encoding flags encryptionKeys, compression,
+// compressionThreshold, blobDir and maxMessageSize on fs.
+func NewEncoding(fs *flag.FlagSet) *Encoding {
+	return &Encoding{
+		encryptionKeys:       fs.String("encryptionKeys", "", "Encryption key file; message bodies are encrypted before they reach the broker"),
+		compression:          fs.String("compression", "", "Codec large message bodies are compressed with (gzip or deflate, optionally with a level such as gzip:best)"),
+		compressionThreshold: fs.Int("compressionThreshold", queue.DefaultCompressionThreshold, "Body size in bytes from which messages are compressed"),
+		blobDir:              fs.String("blobDir", "", "Directory shared by the clients and the server that bodies larger than maxMessageSize are stored in"),
+		maxMessageSize:       fs.Int("maxMessageSize", queue.DefaultMaxMessageSize, "Largest body in bytes sent to the broker when blobDir is set"),
+	}
+}
+
+// Encoder returns the encoder of the flags.
+func (e *Encoding) Encoder() (*Encoder, error) {
+	var enc Encoder
+	if *e.encryptionKeys != "" {
+		keys, err := queue.LoadEncryptionKeys(*e.encryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("loading encryption keys: %w", err)
+		}
+		enc.Keys = keys
+	}
+	if *e.compression != "" {
+		codec, err := queue.ParseCodec(*e.compression)
+		if err != nil {
+			return nil, err
+		}
+		enc.compression = append(enc.compression, queue.WithCodec(codec), queue.WithThreshold(*e.compressionThreshold))
+	}
+	if *e.blobDir != "" {
+		enc.compression = append(enc.compression, queue.WithClaimCheck(*e.blobDir, *e.maxMessageSize))
+	}
+	return &enc, nil
+}
+
+// Encoder encodes the bodies of the queues it wraps.
+type Encoder struct {
+	// Keys are the encryption keys, or nil without encryption. They can be
+	// reloaded while in use.
+	Keys        *queue.EncryptionKeys
+	compression []queue.CompressionOption
+}
+
+// Wrap returns q encoding the bodies of its messages, or q itself if
+// nothing is configured. Bodies are compressed before they are encrypted.
+// Received messages that cannot be decoded are dropped and reported to
+// onError, if it is not nil.
+func (e *Encoder) Wrap(q queue.Queue, onError func(error)) queue.Queue {
+	if e.Keys != nil {
+		q = queue.NewEncryptedQueue(q, e.Keys, onError)
+	}
+	if e.compression != nil {
+		q = queue.NewCompressedQueue(q, onError, e.compression...)
+	}
+	return q
+}
@@ -0,0 +1,52 @@
+// Package cmdflags defines the command line flags that the tools share to
+// sign and encode the messages they send, and builds their queues from
+// them, so that every tool talks to the servers the same way.
+package cmdflags
+
+import (
+	"flag"
+	"fmt"
+
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/queue"
+)
+
+// Signing holds the flags of the key messages are signed with.
+type Signing struct {
+	keyFile *string
+	keyID   *string
+}
+
+// NewSigning defines the signing flags keyFile and keyID on fs.
+func NewSigning(fs *flag.FlagSet) *Signing {
+	return &Signing{
+		keyFile: fs.String("keyFile", "", "Key file with the keys messages are signed with"),
+		keyID:   fs.String("keyID", "", "Key to sign with (defaults to the last valid key in keyFile)"),
+	}
+}
+
+// Signer returns the signer of the flags, or nil without keyFile.
+func (s *Signing) Signer() (*auth.Signer, error) {
+	if *s.keyFile == "" {
+		return nil, nil
+	}
+	keys, err := auth.LoadKeyring(*s.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading keys: %w", err)
+	}
+	signer, err := auth.NewSigner(keys, *s.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("loading keys: %w", err)
+	}
+	return signer, nil
+}
+
+// Wrap returns q signing every message it sends, or q itself without
+// keyFile.
+func (s *Signing) Wrap(q queue.Queue) (queue.Queue, error) {
+	signer, err := s.Signer()
+	if err != nil || signer == nil {
+		return q, err
+	}
+	return auth.NewSignedQueue(q, signer), nil
+}
@@ -2,34 +2,132 @@ package types
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 type CommandType string
 
 const (
-	Undefined   CommandType = ""
-	AddItem     CommandType = "addItem"
-	DeleteItem  CommandType = "deleteItem"
-	GetItem     CommandType = "getItem"
-	GetAllItems CommandType = "getAllItems"
+	Undefined     CommandType = ""
+	AddItem       CommandType = "addItem"
+	DeleteItem    CommandType = "deleteItem"
+	GetItem       CommandType = "getItem"
+	GetAllItems   CommandType = "getAllItems"
+	Batch         CommandType = "batch"
+	Increment     CommandType = "increment"
+	Decrement     CommandType = "decrement"
+	Append        CommandType = "append"
+	Prepend       CommandType = "prepend"
+	InsertBefore  CommandType = "insertBefore"
+	InsertAfter   CommandType = "insertAfter"
+	MoveToFront   CommandType = "moveToFront"
+	MoveToBack    CommandType = "moveToBack"
+	GetFirst      CommandType = "getFirst"
+	GetLast       CommandType = "getLast"
+	PopFirst      CommandType = "popFirst"
+	PopLast       CommandType = "popLast"
+	GetAt         CommandType = "getAt"
+	GetRange      CommandType = "getRange"
+	GetPrefix     CommandType = "getPrefix"
+	OpenSnapshot  CommandType = "openSnapshot"
+	GetPage       CommandType = "getPage"
+	CloseSnapshot CommandType = "closeSnapshot"
+	Expire        CommandType = "expire"
+	Watch         CommandType = "watch"
+	WatchPrefix   CommandType = "watchPrefix"
+	Unwatch       CommandType = "unwatch"
+	UnwatchPrefix CommandType = "unwatchPrefix"
+
+	ListNamespaces  CommandType = "listNamespaces"
+	CreateNamespace CommandType = "createNamespace"
+	DropNamespace   CommandType = "dropNamespace"
+	ClearNamespace  CommandType = "clearNamespace"
 )
 
+// commandTypes lists the command types in the order of their declaration.
+var commandTypes = []CommandType{
+	AddItem, DeleteItem, GetItem, GetAllItems, Batch, Increment, Decrement, Append, Prepend,
+	InsertBefore, InsertAfter, MoveToFront, MoveToBack, GetFirst, GetLast, PopFirst, PopLast,
+	GetAt, GetRange, GetPrefix, OpenSnapshot, GetPage, CloseSnapshot, Expire,
+	Watch, WatchPrefix, Unwatch, UnwatchPrefix,
+	ListNamespaces, CreateNamespace, DropNamespace, ClearNamespace,
+}
+
+// CommandTypes returns every command type.
+func CommandTypes() []CommandType {
+	return append([]CommandType(nil), commandTypes...)
+}
+
+// maxNamespaceLength is the longest namespace name.
+const maxNamespaceLength = 64
+
+// transaction is accepted as an alias of batch when parsing.
+const transactionAlias = "transaction"
+
 type Command struct {
-	args []string
-	Type CommandType
+	args      []string
+	commands  []Command
+	namespace string
+	Type      CommandType
 }
 
+// ParseCommand parses a command. A namespace can follow the command name,
+// as in addItem@orders('k', 'v').
 func ParseCommand(message string) (Command, error) {
+	namespace, message, err := splitNamespace(message)
+	if err != nil {
+		return Command{}, err
+	}
 	var command Command
 	command.Type = getCommand(message)
+	if command.Type == Batch {
+		if command, err = parseBatch(message); err != nil {
+			return Command{}, err
+		}
+		command.namespace = namespace
+		return command, nil
+	}
 	command.args = getArgs(message)
+	command.namespace = namespace
 	if !command.isValid() {
 		return Command{}, fmt.Errorf("Invalid message: %s\n", message)
 	}
 	return command, nil
 }
 
+// splitNamespace removes the namespace from the command name of message.
+func splitNamespace(message string) (string, string, error) {
+	head, rest := message, ""
+	if open := strings.Index(message, "("); open >= 0 {
+		head, rest = message[:open], message[open:]
+	}
+	name, namespace, ok := strings.Cut(head, "@")
+	if !ok {
+		return "", message, nil
+	}
+	if namespace = strings.TrimSpace(namespace); !IsNamespace(namespace) {
+		return "", "", fmt.Errorf("Invalid namespace %q: %s\n", namespace, message)
+	}
+	return namespace, name + rest, nil
+}
+
+// IsNamespace reports whether name is a valid namespace name: up to 64
+// letters, digits, '-', '_' and '.'.
+func IsNamespace(name string) bool {
+	if name == "" || len(name) > maxNamespaceLength {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func NewAddCommand(key, value string) Command {
 	return Command{
 		Type: AddItem,
@@ -65,6 +163,244 @@ func NewGetAllCommand() Command {
 	}
 }
 
+// NewIncrementCommand adds delta to the integer stored under key.
+func NewIncrementCommand(key string, delta int64) Command {
+	return Command{
+		Type: Increment,
+		args: []string{key, strconv.FormatInt(delta, 10)},
+	}
+}
+
+// NewDecrementCommand subtracts delta from the integer stored under key.
+func NewDecrementCommand(key string, delta int64) Command {
+	return Command{
+		Type: Decrement,
+		args: []string{key, strconv.FormatInt(delta, 10)},
+	}
+}
+
+// NewAppendCommand appends suffix to the string stored under key.
+func NewAppendCommand(key, suffix string) Command {
+	return Command{
+		Type: Append,
+		args: []string{key, suffix},
+	}
+}
+
+// NewPrependCommand prepends prefix to the string stored under key.
+func NewPrependCommand(key, prefix string) Command {
+	return Command{
+		Type: Prepend,
+		args: []string{key, prefix},
+	}
+}
+
+// NewInsertBeforeCommand inserts key right before the existing key.
+func NewInsertBeforeCommand(existing, key, value string) Command {
+	return Command{
+		Type: InsertBefore,
+		args: []string{existing, key, value},
+	}
+}
+
+// NewInsertAfterCommand inserts key right after the existing key.
+func NewInsertAfterCommand(existing, key, value string) Command {
+	return Command{
+		Type: InsertAfter,
+		args: []string{existing, key, value},
+	}
+}
+
+func NewMoveToFrontCommand(key string) Command {
+	return Command{
+		Type: MoveToFront,
+		args: []string{key},
+	}
+}
+
+func NewMoveToBackCommand(key string) Command {
+	return Command{
+		Type: MoveToBack,
+		args: []string{key},
+	}
+}
+
+func NewGetFirstCommand() Command {
+	return Command{
+		Type: GetFirst,
+		args: []string{},
+	}
+}
+
+func NewGetLastCommand() Command {
+	return Command{
+		Type: GetLast,
+		args: []string{},
+	}
+}
+
+func NewPopFirstCommand() Command {
+	return Command{
+		Type: PopFirst,
+		args: []string{},
+	}
+}
+
+func NewPopLastCommand() Command {
+	return Command{
+		Type: PopLast,
+		args: []string{},
+	}
+}
+
+// NewGetAtCommand reads the item at the given zero-based position.
+func NewGetAtCommand(index int) Command {
+	return Command{
+		Type: GetAt,
+		args: []string{strconv.Itoa(index)},
+	}
+}
+
+// NewGetRangeCommand reads the items whose key is in [from, to).
+// An empty to leaves the range unbounded.
+func NewGetRangeCommand(from, to string) Command {
+	args := []string{from}
+	if to != "" {
+		args = append(args, to)
+	}
+	return Command{
+		Type: GetRange,
+		args: args,
+	}
+}
+
+// NewGetPrefixCommand reads the items whose key starts with prefix.
+func NewGetPrefixCommand(prefix string) Command {
+	return Command{
+		Type: GetPrefix,
+		args: []string{prefix},
+	}
+}
+
+// NewOpenSnapshotCommand takes a snapshot of the map that later getPage
+// commands can read.
+func NewOpenSnapshotCommand() Command {
+	return Command{
+		Type: OpenSnapshot,
+		args: []string{},
+	}
+}
+
+// NewGetPageCommand reads up to limit items starting at offset from the
+// snapshot with the given id.
+func NewGetPageCommand(snapshotID string, offset, limit int) Command {
+	return Command{
+		Type: GetPage,
+		args: []string{snapshotID, strconv.Itoa(offset), strconv.Itoa(limit)},
+	}
+}
+
+// NewCloseSnapshotCommand releases the snapshot with the given id.
+func NewCloseSnapshotCommand(snapshotID string) Command {
+	return Command{
+		Type: CloseSnapshot,
+		args: []string{snapshotID},
+	}
+}
+
+// NewExpireCommand removes key after the given number of seconds.
+func NewExpireCommand(key string, seconds int) Command {
+	return Command{
+		Type: Expire,
+		args: []string{key, strconv.Itoa(seconds)},
+	}
+}
+
+// NewWatchCommand publishes the changes of key to the change feed queue,
+// starting at sequence number from, or with the next change if from is 0.
+func NewWatchCommand(key string, from uint64) Command {
+	return newWatchCommand(Watch, key, from)
+}
+
+// NewWatchPrefixCommand publishes the changes of keys starting with prefix
+// to the change feed queue.
+func NewWatchPrefixCommand(prefix string, from uint64) Command {
+	return newWatchCommand(WatchPrefix, prefix, from)
+}
+
+func newWatchCommand(commandType CommandType, key string, from uint64) Command {
+	args := []string{key}
+	if from > 0 {
+		args = append(args, strconv.FormatUint(from, 10))
+	}
+	return Command{
+		Type: commandType,
+		args: args,
+	}
+}
+
+func NewUnwatchCommand(key string) Command {
+	return Command{
+		Type: Unwatch,
+		args: []string{key},
+	}
+}
+
+func NewUnwatchPrefixCommand(prefix string) Command {
+	return Command{
+		Type: UnwatchPrefix,
+		args: []string{prefix},
+	}
+}
+
+// NewListNamespacesCommand lists the namespaces and their number of items.
+func NewListNamespacesCommand() Command {
+	return Command{
+		Type: ListNamespaces,
+		args: []string{},
+	}
+}
+
+// NewCreateNamespaceCommand creates a namespace that holds at most quota
+// items, or sets the quota of an existing one. A quota of 0 is unlimited.
+func NewCreateNamespaceCommand(name string, quota int) Command {
+	return Command{
+		Type: CreateNamespace,
+		args: []string{name, strconv.Itoa(quota)},
+	}
+}
+
+// NewDropNamespaceCommand removes a namespace and its items.
+func NewDropNamespaceCommand(name string) Command {
+	return Command{
+		Type: DropNamespace,
+		args: []string{name},
+	}
+}
+
+// NewClearNamespaceCommand removes the items of a namespace.
+func NewClearNamespaceCommand(name string) Command {
+	return Command{
+		Type: ClearNamespace,
+		args: []string{name},
+	}
+}
+
+// NewBatchCommand groups commands so that the server applies them atomically.
+func NewBatchCommand(commands ...Command) Command {
+	return Command{
+		Type:     Batch,
+		args:     []string{},
+		commands: commands,
+	}
+}
+
+// IsCommandType reports whether name is the name of a command, such as
+// "addItem".
+func IsCommandType(name string) bool {
+	return !strings.Contains(name, "(") && getCommand(name) != Undefined
+}
+
 func getCommand(message string) CommandType {
 	message = strings.TrimSpace(strings.Split(message, "(")[0])
 	switch message {
@@ -76,6 +412,62 @@ func getCommand(message string) CommandType {
 		return GetItem
 	case "getAllItems":
 		return GetAllItems
+	case "batch", transactionAlias:
+		return Batch
+	case "increment":
+		return Increment
+	case "decrement":
+		return Decrement
+	case "append":
+		return Append
+	case "prepend":
+		return Prepend
+	case "insertBefore":
+		return InsertBefore
+	case "insertAfter":
+		return InsertAfter
+	case "moveToFront":
+		return MoveToFront
+	case "moveToBack":
+		return MoveToBack
+	case "getFirst":
+		return GetFirst
+	case "getLast":
+		return GetLast
+	case "popFirst":
+		return PopFirst
+	case "popLast":
+		return PopLast
+	case "getAt":
+		return GetAt
+	case "getRange":
+		return GetRange
+	case "getPrefix":
+		return GetPrefix
+	case "openSnapshot":
+		return OpenSnapshot
+	case "getPage":
+		return GetPage
+	case "closeSnapshot":
+		return CloseSnapshot
+	case "expire":
+		return Expire
+	case "watch":
+		return Watch
+	case "watchPrefix":
+		return WatchPrefix
+	case "unwatch":
+		return Unwatch
+	case "unwatchPrefix":
+		return UnwatchPrefix
+	case "listNamespaces":
+		return ListNamespaces
+	case "createNamespace":
+		return CreateNamespace
+	case "dropNamespace":
+		return DropNamespace
+	case "clearNamespace":
+		return ClearNamespace
 	default:
 		return Undefined
 	}
@@ -98,40 +490,334 @@ func getArgs(message string) []string {
 	return parts
 }
 
+// parseBatch parses messages of the form batch([cmd1, cmd2, ...]).
+// Every sub-command is parsed and validated before the batch is accepted.
+func parseBatch(message string) (Command, error) {
+	body := strings.TrimSpace(message)
+	open := strings.Index(body, "(")
+	if open < 0 || !strings.HasSuffix(body, ")") {
+		return Command{}, fmt.Errorf("Invalid message: %s\n", message)
+	}
+	body = strings.TrimSpace(body[open+1 : len(body)-1])
+	if !strings.HasPrefix(body, "[") || !strings.HasSuffix(body, "]") {
+		return Command{}, fmt.Errorf("Invalid batch, expected a list of commands: %s\n", message)
+	}
+
+	var commands []Command
+	for i, part := range splitTopLevel(body[1 : len(body)-1]) {
+		sub, err := ParseCommand(part)
+		if err != nil {
+			return Command{}, fmt.Errorf("Invalid batch step %d: %v", i+1, err)
+		}
+		if sub.Type == Batch {
+			return Command{}, fmt.Errorf("Invalid batch step %d: batches cannot be nested\n", i+1)
+		}
+		commands = append(commands, sub)
+	}
+
+	command := NewBatchCommand(commands...)
+	if !command.isValid() {
+		return Command{}, fmt.Errorf("Invalid message: %s\n", message)
+	}
+	return command, nil
+}
+
+// splitTopLevel splits s on commas that are not nested inside quotes,
+// parentheses or brackets. Empty parts are dropped.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start, quoted := 0, 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			quoted = !quoted
+		case '(', '[':
+			if !quoted {
+				depth++
+			}
+		case ')', ']':
+			if !quoted {
+				depth--
+			}
+		case ',':
+			if !quoted && depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+
+	result := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// Validate returns an error if the command is not valid, for example a
+// command built by hand with missing arguments.
+func (c Command) Validate() error {
+	if !c.isValid() {
+		return fmt.Errorf("invalid command: %s", c)
+	}
+	return nil
+}
+
 func (c Command) isValid() bool {
+	if c.namespace != "" && c.managesNamespaces() {
+		return false
+	}
 	switch c.Type {
-	case AddItem:
+	case AddItem, Append, Prepend:
 		if len(c.args) == 2 {
 			return true
 		}
-	case DeleteItem, GetItem:
+	case Increment, Decrement:
 		if len(c.args) == 1 {
 			return true
 		}
-	case GetAllItems:
+		if len(c.args) == 2 {
+			_, err := strconv.ParseInt(c.args[1], 10, 64)
+			return err == nil
+		}
+	case InsertBefore, InsertAfter:
+		if len(c.args) == 3 {
+			return true
+		}
+	case GetRange:
+		if len(c.args) == 1 || len(c.args) == 2 {
+			return true
+		}
+	case Expire:
+		if len(c.args) == 2 {
+			seconds, err := strconv.Atoi(c.args[1])
+			return err == nil && seconds > 0
+		}
+	case Watch, WatchPrefix:
+		if len(c.args) == 1 {
+			return true
+		}
+		if len(c.args) == 2 {
+			_, err := strconv.ParseUint(c.args[1], 10, 64)
+			return err == nil
+		}
+	case GetPage:
+		if len(c.args) == 3 {
+			offset, err1 := strconv.Atoi(c.args[1])
+			limit, err2 := strconv.Atoi(c.args[2])
+			return err1 == nil && err2 == nil && offset >= 0 && limit > 0
+		}
+	case DeleteItem, GetItem, MoveToFront, MoveToBack, GetPrefix, CloseSnapshot, Unwatch, UnwatchPrefix:
+		if len(c.args) == 1 {
+			return true
+		}
+	case GetAt:
+		if len(c.args) == 1 {
+			index, err := strconv.Atoi(c.args[0])
+			return err == nil && index >= 0
+		}
+	case GetAllItems, GetFirst, GetLast, PopFirst, PopLast, OpenSnapshot, ListNamespaces:
 		if len(c.args) == 0 {
 			return true
 		}
+	case CreateNamespace:
+		if len(c.args) == 1 {
+			return IsNamespace(c.args[0])
+		}
+		if len(c.args) == 2 {
+			quota, err := strconv.Atoi(c.args[1])
+			return err == nil && quota >= 0 && IsNamespace(c.args[0])
+		}
+	case DropNamespace, ClearNamespace:
+		if len(c.args) == 1 {
+			return IsNamespace(c.args[0])
+		}
+	case Batch:
+		if len(c.commands) == 0 {
+			return false
+		}
+		for _, sub := range c.commands {
+			if !sub.isValid() || sub.namespace != "" {
+				// Steps run in the namespace of the batch.
+				return false
+			}
+			switch sub.Type {
+			case Batch, OpenSnapshot, GetPage, CloseSnapshot, Watch, WatchPrefix, Unwatch, UnwatchPrefix,
+				ListNamespaces, CreateNamespace, DropNamespace, ClearNamespace:
+				// Snapshots, watches and namespaces are not part of the
+				// map, so they cannot be changed transactionally.
+				return false
+			}
+		}
+		return true
 	}
 	return false
 }
 
+// HasKey reports whether the command targets the single key returned by Key.
+func (c Command) HasKey() bool {
+	switch c.Type {
+	case Undefined, GetAllItems, Batch, GetFirst, GetLast, PopFirst, PopLast, GetAt,
+		GetRange, GetPrefix, OpenSnapshot, GetPage, CloseSnapshot, WatchPrefix, UnwatchPrefix,
+		ListNamespaces, CreateNamespace, DropNamespace, ClearNamespace:
+		return false
+	}
+	return true
+}
+
+// managesNamespaces reports whether the command lists, creates, drops or
+// clears namespaces.
+func (c Command) managesNamespaces() bool {
+	switch c.Type {
+	case ListNamespaces, CreateNamespace, DropNamespace, ClearNamespace:
+		return true
+	}
+	return false
+}
+
+// Namespace returns the namespace the command applies to, or "" for the
+// default namespace. For createNamespace, dropNamespace and clearNamespace
+// it is the namespace they manage.
+func (c Command) Namespace() string {
+	switch c.Type {
+	case CreateNamespace, DropNamespace, ClearNamespace:
+		return c.args[0]
+	}
+	return c.namespace
+}
+
+// WithNamespace returns a copy of the command that applies to namespace.
+// Commands that manage namespaces are returned unchanged.
+func (c Command) WithNamespace(namespace string) Command {
+	if !c.managesNamespaces() {
+		c.namespace = namespace
+	}
+	return c
+}
+
+// Quota returns the quota set by a createNamespace command, and whether it
+// was given.
+func (c Command) Quota() (int, bool) {
+	if len(c.args) < 2 {
+		return 0, false
+	}
+	quota, _ := strconv.Atoi(c.args[1])
+	return quota, true
+}
+
 func (c Command) Key() string {
+	if c.Type == InsertBefore || c.Type == InsertAfter {
+		return c.args[1]
+	}
 	return c.args[0]
 }
 
 func (c Command) Value() string {
+	if c.Type == InsertBefore || c.Type == InsertAfter {
+		return c.args[2]
+	}
 	if len(c.args) < 2 {
 		return ""
 	}
 	return c.args[1]
 }
 
+// Pivot returns the existing key that insertBefore and insertAfter insert next to.
+func (c Command) Pivot() string {
+	return c.args[0]
+}
+
+// Index returns the position read by a getAt command.
+func (c Command) Index() int {
+	index, _ := strconv.Atoi(c.args[0])
+	return index
+}
+
+// RangeEnd returns the exclusive upper bound of a getRange command, or "" if
+// the range is unbounded. The lower bound is returned by Key.
+func (c Command) RangeEnd() string {
+	return c.Value()
+}
+
+// Offset returns the position of the first item read by a getPage command.
+func (c Command) Offset() int {
+	offset, _ := strconv.Atoi(c.args[1])
+	return offset
+}
+
+// Limit returns the maximum number of items read by a getPage command.
+func (c Command) Limit() int {
+	limit, _ := strconv.Atoi(c.args[2])
+	return limit
+}
+
+// Seconds returns the delay of an expire command.
+func (c Command) Seconds() int {
+	seconds, _ := strconv.Atoi(c.args[1])
+	return seconds
+}
+
+// From returns the sequence number a watch resumes at, or 0 to start with
+// the next change.
+func (c Command) From() uint64 {
+	if len(c.args) < 2 {
+		return 0
+	}
+	from, _ := strconv.ParseUint(c.args[1], 10, 64)
+	return from
+}
+
+// Delta returns the amount of an increment or decrement command, which
+// defaults to 1 when omitted.
+func (c Command) Delta() int64 {
+	if len(c.args) < 2 {
+		return 1
+	}
+	delta, _ := strconv.ParseInt(c.args[1], 10, 64)
+	return delta
+}
+
+// Commands returns the steps of a batch command.
+func (c Command) Commands() []Command {
+	return c.commands
+}
+
+// IsReadOnly reports whether executing the command leaves the data unchanged.
+func (c Command) IsReadOnly() bool {
+	switch c.Type {
+	case GetItem, GetAllItems, GetFirst, GetLast, GetAt, GetRange, GetPrefix, OpenSnapshot, GetPage, CloseSnapshot,
+		Watch, WatchPrefix, Unwatch, UnwatchPrefix, ListNamespaces:
+		return true
+	case Batch:
+		for _, sub := range c.commands {
+			if !sub.IsReadOnly() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func (c Command) String() string {
+	name := string(c.Type)
+	if c.namespace != "" {
+		name += "@" + c.namespace
+	}
+	if c.Type == Batch {
+		steps := make([]string, 0, len(c.commands))
+		for _, sub := range c.commands {
+			steps = append(steps, sub.String())
+		}
+		return fmt.Sprintf("%s([%s])", name, strings.Join(steps, ", "))
+	}
 	switch len(c.args) {
 	case 0:
-		return fmt.Sprintf("%s()", c.Type)
+		return fmt.Sprintf("%s()", name)
 	default:
-		return fmt.Sprintf("%s('%s')", c.Type, strings.Join(c.args, "', '"))
+		return fmt.Sprintf("%s('%s')", name, strings.Join(c.args, "', '"))
 	}
 }
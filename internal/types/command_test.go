@@ -69,6 +69,181 @@ func TestNewCommand(t *testing.T) {
 			expectedArgs:  nil,
 			expectedError: true,
 		},
+		{
+			name:          "Valid increment command",
+			message:       "increment('key', 5)",
+			expectedType:  Increment,
+			expectedArgs:  []string{"key", "5"},
+			expectedError: false,
+		},
+		{
+			name:          "Valid decrement command without delta",
+			message:       "decrement('key')",
+			expectedType:  Decrement,
+			expectedArgs:  []string{"key"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid increment delta",
+			message:       "increment('key', 'five')",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid append command",
+			message:       "append('key', 'suffix')",
+			expectedType:  Append,
+			expectedArgs:  []string{"key", "suffix"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid prepend command",
+			message:       "prepend('key')",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid insertBefore command",
+			message:       "insertBefore('existing', 'key', 'value')",
+			expectedType:  InsertBefore,
+			expectedArgs:  []string{"existing", "key", "value"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid insertAfter command",
+			message:       "insertAfter('existing', 'key')",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid popFirst command",
+			message:       "popFirst()",
+			expectedType:  PopFirst,
+			expectedArgs:  []string{},
+			expectedError: false,
+		},
+		{
+			name:          "Valid getAt command",
+			message:       "getAt(3)",
+			expectedType:  GetAt,
+			expectedArgs:  []string{"3"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid getAt index",
+			message:       "getAt(-1)",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid getRange command",
+			message:       "getRange('a', 'm')",
+			expectedType:  GetRange,
+			expectedArgs:  []string{"a", "m"},
+			expectedError: false,
+		},
+		{
+			name:          "Valid getPrefix command",
+			message:       "getPrefix('user:')",
+			expectedType:  GetPrefix,
+			expectedArgs:  []string{"user:"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid getRange command",
+			message:       "getRange()",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid getPage command",
+			message:       "getPage('a1b2', 0, 100)",
+			expectedType:  GetPage,
+			expectedArgs:  []string{"a1b2", "0", "100"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid getPage limit",
+			message:       "getPage('a1b2', 0, 0)",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Snapshot command in batch",
+			message:       "batch([openSnapshot()])",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid expire command",
+			message:       "expire('key', 30)",
+			expectedType:  Expire,
+			expectedArgs:  []string{"key", "30"},
+			expectedError: false,
+		},
+		{
+			name:          "Valid watchPrefix command with resume point",
+			message:       "watchPrefix('user:', 42)",
+			expectedType:  WatchPrefix,
+			expectedArgs:  []string{"user:", "42"},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid watch resume point",
+			message:       "watch('key', -1)",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Valid batch command",
+			message:       "batch([addItem('b', 'v'), deleteItem('a')])",
+			expectedType:  Batch,
+			expectedArgs:  []string{},
+			expectedError: false,
+		},
+		{
+			name:          "Expiry in batch",
+			message:       "batch([addItem('b', 'v'), expire('b', 30)])",
+			expectedType:  Batch,
+			expectedArgs:  []string{},
+			expectedError: false,
+		},
+		{
+			name:          "Valid transaction command",
+			message:       "transaction([getItem('a')])",
+			expectedType:  Batch,
+			expectedArgs:  []string{},
+			expectedError: false,
+		},
+		{
+			name:          "Invalid batch step",
+			message:       "batch([addItem('b'), deleteItem('a')])",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Empty batch",
+			message:       "batch([])",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
+		{
+			name:          "Nested batch",
+			message:       "batch([batch([getAllItems()])])",
+			expectedType:  Undefined,
+			expectedArgs:  nil,
+			expectedError: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -145,3 +320,87 @@ func TestCommand_isValid(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBatch(t *testing.T) {
+	message := "batch([addItem('b', 'v'), deleteItem('a'), getAllItems()])"
+	command, err := ParseCommand(message)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	expected := []Command{NewAddCommand("b", "v"), NewDeleteCommand("a"), NewGetAllCommand()}
+	if !reflect.DeepEqual(command.Commands(), expected) {
+		t.Errorf("Expected steps %v but got %v", expected, command.Commands())
+	}
+	if command.String() != message {
+		t.Errorf("Expected %s but got %s", message, command.String())
+	}
+	if command.IsReadOnly() {
+		t.Errorf("Expected batch with writes not to be read-only")
+	}
+	if !IsCommandType("addItem") || !IsCommandType("transaction") || IsCommandType("addItem('a', 'b')") || IsCommandType("delItem") {
+		t.Errorf("Unexpected command type names")
+	}
+	for _, commandType := range CommandTypes() {
+		if !IsCommandType(string(commandType)) {
+			t.Errorf("Expected %s to be a command type", commandType)
+		}
+	}
+	if command.HasKey() || !command.Commands()[0].HasKey() {
+		t.Errorf("Expected only the steps of the batch to have keys")
+	}
+	if err := command.Validate(); err != nil {
+		t.Errorf("Expected a parsed batch to be valid, got %v", err)
+	}
+	if err := NewBatchCommand(NewAddCommand("a", "b"), NewWatchCommand("a", 0)).Validate(); err == nil {
+		t.Errorf("Expected a batch with a watch to be invalid")
+	}
+	if err := NewExpireCommand("a", 0).Validate(); err == nil {
+		t.Errorf("Expected an expiry without seconds to be invalid")
+	}
+}
+
+func TestParseCommand_Namespace(t *testing.T) {
+	tests := []struct {
+		message   string
+		namespace string
+		valid     bool
+	}{
+		{"addItem@orders('k', 'v')", "orders", true},
+		{"getAllItems@orders()", "orders", true},
+		{"batch@orders([addItem('a', 'b'), deleteItem('c')])", "orders", true},
+		{"createNamespace('orders', '100')", "orders", true},
+		{"dropNamespace('orders')", "orders", true},
+		{"listNamespaces()", "", true},
+		{"addItem@('k', 'v')", "", false},
+		{"addItem@or/ders('k', 'v')", "", false},
+		{"batch([addItem@orders('a', 'b')])", "", false},
+		{"createNamespace@orders('other')", "", false},
+		{"createNamespace('orders', '-1')", "", false},
+		{"clearNamespace('')", "", false},
+	}
+	for _, test := range tests {
+		command, err := ParseCommand(test.message)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error %v", test.message, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if command.Namespace() != test.namespace {
+			t.Errorf("%s: expected namespace %q but got %q", test.message, test.namespace, command.Namespace())
+		}
+		if command.String() != test.message {
+			t.Errorf("Expected %s but got %s", test.message, command.String())
+		}
+	}
+
+	command := NewAddCommand("k", "v").WithNamespace("orders")
+	if command.String() != "addItem@orders('k', 'v')" {
+		t.Errorf("Unexpected command %s", command)
+	}
+	if quota, ok := NewCreateNamespaceCommand("orders", 5).Quota(); !ok || quota != 5 {
+		t.Errorf("Expected quota 5 but got %d", quota)
+	}
+}
@@ -0,0 +1,249 @@
+// Package audit writes an append-only log of data changes. Every record
+// carries the hash of the record before it, so removing, reordering or
+// editing records breaks the chain and is detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Record is one change of one key.
+type Record struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	MessageID string    `json:"messageId,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Command   string    `json:"command,omitempty"`
+	Op        string    `json:"op"`
+	Namespace string    `json:"namespace,omitempty"`
+	Key       string    `json:"key"`
+	OldHash   string    `json:"oldHash,omitempty"`
+	NewHash   string    `json:"newHash,omitempty"`
+	Prev      string    `json:"prev"`
+	Hash      string    `json:"hash"`
+}
+
+// HashValue returns the hex encoded SHA-256 hash of value, or "" if value
+// is nil. Values are hashed so that the log does not disclose them.
+func HashValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// hash returns the hash of r, which covers every field but Hash itself.
+func (r Record) hash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is an append-only audit log file. When the file grows beyond
+// maxSize it is rotated to <path>.1, the previous <path>.1 to <path>.2 and
+// so on; the chain continues in the new file.
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	seq      uint64
+	last     string
+}
+
+// Open opens the audit log at path, continuing the chain of the records it
+// already holds. maxSize is the size in bytes after which the file is
+// rotated, 0 for no rotation; at most maxFiles rotated files are kept, 0
+// for all of them.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	files, err := Files(path)
+	if err != nil {
+		return nil, err
+	}
+	// The last record is in the newest file that has one.
+	for i := len(files) - 1; i >= 0; i-- {
+		last, ok, err := lastRecord(files[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l.seq, l.last = last.Seq, last.Hash
+			break
+		}
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Append numbers records, chains them to the log and writes them in one
+// write. The records are only part of the chain if Append succeeds.
+func (l *Log) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+
+	seq, last := l.seq, l.last
+	var data []byte
+	for _, r := range records {
+		seq++
+		r.Seq, r.Prev = seq, last
+		r.Hash = r.hash()
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+		last = r.Hash
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating audit log: %w", err)
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq, l.last = seq, last
+	return nil
+}
+
+// rotate moves the current file to <path>.1 and starts a new one.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	files, err := Files(l.path)
+	if err != nil {
+		return err
+	}
+	// files holds the rotated files oldest first, then the current file.
+	// The oldest is moved first so that no file is overwritten.
+	for i := 0; i < len(files)-1; i++ {
+		n := len(files) - 1 - i
+		if l.maxFiles > 0 && n >= l.maxFiles {
+			if err := os.Remove(files[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(files[i], rotated(l.path, n+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, rotated(l.path, 1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func rotated(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// Files returns the files of the audit log at path, oldest first: the
+// rotated files <path>.N ... <path>.1, then path itself if it exists.
+func Files(path string) ([]string, error) {
+	var files []string
+	for n := 1; ; n++ {
+		name := rotated(path, n)
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		files = append([]string{name}, files...)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// lastRecord returns the last record of the file name.
+func lastRecord(name string) (Record, bool, error) {
+	var last Record
+	var found bool
+	err := scan(name, func(line int, r Record) error {
+		last, found = r, true
+		return nil
+	})
+	return last, found, err
+}
+
+// scan calls fn with every record of the file name and its line number.
+func scan(name string, fn func(line int, r Record) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return &VerifyError{File: name, Line: line, Reason: "invalid record: " + err.Error()}
+		}
+		if err := fn(line, r); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendRecords(t *testing.T, l *Log, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		r := Record{Time: time.Now(), Op: "set", Key: key, NewHash: HashValue(key)}
+		if err := l.Append(r); err != nil {
+			t.Fatalf("Expected no error but got: %v", err)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	appendRecords(t, l, "a", "b")
+	l.Close()
+
+	// Reopening continues the chain.
+	l, err = Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	appendRecords(t, l, "c")
+	l.Close()
+
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if report.Records != 3 || report.First != 1 || report.Last != 3 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if HashValue(nil) != "" || HashValue("a") == HashValue("b") {
+		t.Errorf("Unexpected value hashes")
+	}
+}
+
+func TestLog_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 200, 2)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	defer l.Close()
+	appendRecords(t, l, "a", "b", "c", "d", "e", "f")
+
+	files, _ := Files(path)
+	expected := []string{path + ".2", path + ".1", path}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected files %v but got %v", expected, files)
+	}
+
+	// The oldest files were removed; the rest of the chain still verifies.
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if report.Files != 3 || report.First == 1 || report.Last != 6 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func TestVerify_Tampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{name: "Edited", tamper: func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"key":"b"`, `"key":"x"`, 1)
+			return lines
+		}},
+		{name: "Removed", tamper: func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{name: "Reordered", tamper: func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			l, err := Open(path, 0, 0)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+			appendRecords(t, l, "a", "b", "c")
+			l.Close()
+
+			data, _ := os.ReadFile(path)
+			lines := test.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+
+			_, err = Verify(path)
+			var verr *VerifyError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected a VerifyError but got: %v", err)
+			}
+			if verr.Line != 2 {
+				t.Errorf("Expected line 2 but got %d", verr.Line)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"fmt"
+)
+
+// VerifyError reports where an audit log breaks its chain.
+type VerifyError struct {
+	File   string
+	Line   int
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// Report summarises a verified audit log.
+type Report struct {
+	Files   int
+	Records int
+	// First and Last are the sequence numbers of the oldest and newest
+	// records. First is larger than 1 if old files were removed by
+	// rotation; the chain is then only verified from First on.
+	First, Last uint64
+}
+
+// Verify checks that the records of the audit log at path, including its
+// rotated files, are numbered without gaps, that every record has the hash
+// of its content, and that it links to the hash of the record before it.
+// It returns a *VerifyError for the first record that breaks the chain.
+func Verify(path string) (Report, error) {
+	var report Report
+	files, err := Files(path)
+	if err != nil {
+		return report, err
+	}
+	var prev string
+	for _, name := range files {
+		report.Files++
+		err := scan(name, func(line int, r Record) error {
+			fail := func(format string, args ...interface{}) error {
+				return &VerifyError{File: name, Line: line, Reason: fmt.Sprintf(format, args...)}
+			}
+			if r.Hash != r.hash() {
+				return fail("record %d does not match its hash", r.Seq)
+			}
+			if report.Records == 0 {
+				report.First = r.Seq
+			} else {
+				if r.Seq != report.Last+1 {
+					return fail("expected record %d but found %d", report.Last+1, r.Seq)
+				}
+				if r.Prev != prev {
+					return fail("record %d does not link to record %d", r.Seq, report.Last)
+				}
+			}
+			if r.Seq == 1 && r.Prev != "" {
+				return fail("first record links to a previous record")
+			}
+			report.Records++
+			report.Last, prev = r.Seq, r.Hash
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"command-queue/internal/util/queue"
+)
+
+// Headers of a signed message. The client identity is sent in
+// queue.ClientIDHeader.
+const (
+	KeyIDHeader     = "signature-key"
+	SignatureHeader = "signature"
+	TimestampHeader = "signature-timestamp"
+	NonceHeader     = "signature-nonce"
+)
+
+// DefaultWindow is how far the timestamp of a message may be from the
+// server's clock.
+const DefaultWindow = 5 * time.Minute
+
+var (
+	// ErrUnsigned is returned for a message without a signature.
+	ErrUnsigned = errors.New("message is not signed")
+	// ErrBadSignature is returned for a signature that does not match.
+	ErrBadSignature = errors.New("invalid signature")
+	// ErrStale is returned for a message whose timestamp is outside the
+	// window or its key's validity.
+	ErrStale = errors.New("message timestamp out of range")
+	// ErrReplay is returned for a nonce that was already seen.
+	ErrReplay = errors.New("message replayed")
+)
+
+// payload returns the signed bytes of a message. The namespace and
+// idempotency key headers are signed too, if they are set, since they
+// select the data the body changes and whether it is applied at all.
+func payload(keyID, client, timestamp, nonce, body string, headers map[string]string) []byte {
+	parts := []string{keyID, client, timestamp, nonce, body}
+	if namespace := headers[queue.NamespaceHeader]; namespace != "" {
+		parts = append(parts, namespace)
+	}
+	if key := headers[queue.IdempotencyKeyHeader]; key != "" {
+		// Namespaces cannot contain ':', so the key is never mistaken
+		// for one.
+		parts = append(parts, queue.IdempotencyKeyHeader+":"+key)
+	}
+	return []byte(strings.Join(parts, "\n"))
+}
+
+// Signer signs messages with a key of a keyring.
+type Signer struct {
+	keys  *Keyring
+	keyID string
+	now   func() time.Time
+}
+
+// NewSigner creates a Signer using the key keyID of keys, or if keyID is
+// empty the last key in the key file that can sign, so that a new key is
+// picked up by adding it at the end and reloading.
+func NewSigner(keys *Keyring, keyID string) (*Signer, error) {
+	s := &Signer{keys: keys, keyID: keyID, now: time.Now}
+	if _, err := keys.signingKey(keyID, s.now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sign adds the signature of body and the identity of the key's client to
+// headers. The namespace header must be set before.
+func (s *Signer) Sign(body string, headers map[string]string) error {
+	now := s.now()
+	k, err := s.keys.signingKey(s.keyID, now)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	data := payload(k.ID, k.Client, timestamp, hex.EncodeToString(nonce), body, headers)
+
+	var signature []byte
+	switch k.Algorithm {
+	case HMACSHA256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(data)
+		signature = mac.Sum(nil)
+	case Ed25519:
+		signature = ed25519.Sign(ed25519.PrivateKey(k.PrivateKey), data)
+	}
+	headers[queue.ClientIDHeader] = k.Client
+	headers[KeyIDHeader] = k.ID
+	headers[TimestampHeader] = timestamp
+	headers[NonceHeader] = hex.EncodeToString(nonce)
+	headers[SignatureHeader] = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// Verifier checks the signatures of messages and rejects replays. Nonces
+// are remembered for twice the window, after which the timestamp check
+// rejects the message.
+type Verifier struct {
+	keys   *Keyring
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+// NewVerifier creates a Verifier of messages signed with keys whose
+// timestamps are within window of the server's clock.
+func NewVerifier(keys *Keyring, window time.Duration) *Verifier {
+	return &Verifier{
+		keys:   keys,
+		window: window,
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Verify checks the signature of message and returns the identity of the
+// client that signed it.
+func (v *Verifier) Verify(message queue.Message) (string, error) {
+	h := message.Headers
+	keyID, signature := h[KeyIDHeader], h[SignatureHeader]
+	if keyID == "" || signature == "" {
+		return "", ErrUnsigned
+	}
+	k, err := v.keys.Get(keyID)
+	if err != nil {
+		return "", err
+	}
+	client, timestamp, nonce := h[queue.ClientIDHeader], h[TimestampHeader], h[NonceHeader]
+	if client != k.Client {
+		return "", fmt.Errorf("%w: key %s does not belong to %q", ErrBadSignature, keyID, client)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	data := payload(keyID, client, timestamp, nonce, message.Body, h)
+	switch k.Algorithm {
+	case HMACSHA256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(data)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return "", ErrBadSignature
+		}
+	case Ed25519:
+		if !ed25519.Verify(ed25519.PublicKey(k.PublicKey), data, sig) {
+			return "", ErrBadSignature
+		}
+	}
+
+	// The signature covers timestamp and nonce, so they can be trusted now.
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrStale, timestamp)
+	}
+	sent, now := time.UnixMilli(ms), v.now()
+	if sent.Before(now.Add(-v.window)) || sent.After(now.Add(v.window)) {
+		return "", fmt.Errorf("%w: sent at %v", ErrStale, sent)
+	}
+	if !k.ValidAt(sent) {
+		return "", fmt.Errorf("%w: key %s is not valid at %v", ErrStale, keyID, sent)
+	}
+	if nonce == "" {
+		return "", fmt.Errorf("%w: missing nonce", ErrBadSignature)
+	}
+	if err := v.remember(keyID+"/"+nonce, now); err != nil {
+		return "", err
+	}
+	return client, nil
+}
+
+// remember records nonce and fails if it was seen before.
+func (v *Verifier) remember(nonce string, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.pruned) > v.window {
+		for n, expires := range v.nonces {
+			if now.After(expires) {
+				delete(v.nonces, n)
+			}
+		}
+		v.pruned = now
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplay
+	}
+	v.nonces[nonce] = now.Add(2 * v.window)
+	return nil
+}
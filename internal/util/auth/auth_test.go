@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"command-queue/internal/util/queue"
+)
+
+func sign(t *testing.T, s *Signer, body string) queue.Message {
+	t.Helper()
+	headers := make(map[string]string)
+	if err := s.Sign(body, headers); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	return queue.Message{Body: body, Headers: headers}
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, algorithm := range []Algorithm{HMACSHA256, Ed25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			k, err := GenerateKey("k1", "billing", algorithm)
+			if err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+			clientKeys, _ := NewKeyring(k)
+			serverKey := k
+			if algorithm == Ed25519 {
+				serverKey = k.Public()
+			}
+			serverKeys, _ := NewKeyring(serverKey)
+			signer, err := NewSigner(clientKeys, "")
+			if err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+			v := NewVerifier(serverKeys, DefaultWindow)
+
+			message := sign(t, signer, "addItem('a', 'b')")
+			client, err := v.Verify(message)
+			if err != nil || client != "billing" {
+				t.Errorf("Expected billing but got %q, %v", client, err)
+			}
+			if _, err := v.Verify(message); !errors.Is(err, ErrReplay) {
+				t.Errorf("Expected ErrReplay but got: %v", err)
+			}
+
+			tampered := sign(t, signer, "addItem('a', 'b')")
+			tampered.Body = "deleteItem('a')"
+			if _, err := v.Verify(tampered); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("Expected ErrBadSignature but got: %v", err)
+			}
+
+			impostor := sign(t, signer, "getAllItems()")
+			impostor.Headers[queue.ClientIDHeader] = "admin"
+			if _, err := v.Verify(impostor); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("Expected ErrBadSignature but got: %v", err)
+			}
+
+			for _, header := range []string{queue.NamespaceHeader, queue.IdempotencyKeyHeader} {
+				headers := map[string]string{header: "x"}
+				if err := signer.Sign("deleteItem('a')", headers); err != nil {
+					t.Fatalf("Expected no error but got: %v", err)
+				}
+				headers[header] = "y"
+				if _, err := v.Verify(queue.Message{Body: "deleteItem('a')", Headers: headers}); !errors.Is(err, ErrBadSignature) {
+					t.Errorf("Expected a changed %s header to be rejected, got: %v", header, err)
+				}
+			}
+
+			if _, err := v.Verify(queue.Message{Body: "getAllItems()"}); !errors.Is(err, ErrUnsigned) {
+				t.Errorf("Expected ErrUnsigned but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerify_Window(t *testing.T) {
+	k, _ := GenerateKey("k1", "billing", HMACSHA256)
+	keys, _ := NewKeyring(k)
+	signer, _ := NewSigner(keys, "k1")
+	signer.now = func() time.Time { return time.Now().Add(-10 * time.Minute) }
+
+	v := NewVerifier(keys, DefaultWindow)
+	if _, err := v.Verify(sign(t, signer, "getAllItems()")); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected ErrStale but got: %v", err)
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, _ := GenerateKey("k1", "billing", HMACSHA256)
+	next, _ := GenerateKey("k2", "billing", Ed25519)
+	lapsed := time.Now().Add(-time.Minute)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(keys ...Key) {
+		data, _ := json.Marshal(KeyFile{Keys: keys})
+		os.WriteFile(path, data, 0o600)
+	}
+
+	write(old)
+	keys, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	signer, _ := NewSigner(keys, "")
+	v := NewVerifier(keys, DefaultWindow)
+	if message := sign(t, signer, "getAllItems()"); message.Headers[KeyIDHeader] != "k1" {
+		t.Errorf("Expected k1 but got %s", message.Headers[KeyIDHeader])
+	}
+
+	// The new key is added at the end and the old one lapses.
+	old.NotAfter = &lapsed
+	write(old, next)
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	message := sign(t, signer, "getAllItems()")
+	if message.Headers[KeyIDHeader] != "k2" {
+		t.Errorf("Expected k2 but got %s", message.Headers[KeyIDHeader])
+	}
+	if _, err := v.Verify(message); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+
+	// An invalid file keeps the loaded keys.
+	os.WriteFile(path, []byte(`{"keys":[{"id":"k3"}]}`), 0o600)
+	if err := keys.Reload(); err == nil {
+		t.Errorf("Expected error for an invalid key file")
+	}
+	if _, err := keys.Get("k2"); err != nil {
+		t.Errorf("Expected k2 to be kept but got: %v", err)
+	}
+}
+
+func TestSignedQueue(t *testing.T) {
+	k, _ := GenerateKey("k1", "billing", HMACSHA256)
+	keys, _ := NewKeyring(k)
+	signer, err := NewSigner(keys, "")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	mem := queue.NewMemQueue(10)
+	q := NewSignedQueue(mem, signer)
+	headers := map[string]string{queue.NamespaceHeader: "orders"}
+	if err := q.SendMessageWithHeaders("addItem('a', 'b')", headers); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(headers) != 1 {
+		t.Errorf("Expected the headers to be unchanged but got: %v", headers)
+	}
+
+	messages, _ := queue.Receive(mem)
+	message := <-messages
+	client, err := NewVerifier(keys, DefaultWindow).Verify(message)
+	if err != nil || client != "billing" {
+		t.Errorf("Expected billing but got %q, %v", client, err)
+	}
+	if message.Headers[queue.NamespaceHeader] != "orders" {
+		t.Errorf("Expected the namespace header but got: %v", message.Headers)
+	}
+}
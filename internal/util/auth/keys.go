@@ -0,0 +1,197 @@
+// Package auth signs queue messages on behalf of a client and verifies them
+// on the server. A signature covers the body, the client identity, a
+// timestamp and a random nonce, and is sent in message headers next to the
+// body.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Algorithm is a signature algorithm.
+type Algorithm string
+
+const (
+	// HMACSHA256 signs with a secret shared by the client and the server.
+	HMACSHA256 Algorithm = "hmac-sha256"
+	// Ed25519 signs with the client's private key; the server only holds
+	// the public key.
+	Ed25519 Algorithm = "ed25519"
+)
+
+var (
+	// ErrUnknownKey is returned for a key ID that is not in the key file.
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrNoSigningKey is returned when no key in the key file can sign.
+	ErrNoSigningKey = errors.New("no signing key")
+)
+
+// Key is a named key of one client. Secrets and keys are base64 encoded
+// in key files. A key is only used between NotBefore and NotAfter, if set,
+// so that keys can be rotated by adding the new key and letting the old one
+// lapse.
+type Key struct {
+	ID         string     `json:"id"`
+	Client     string     `json:"client"`
+	Algorithm  Algorithm  `json:"algorithm"`
+	Secret     []byte     `json:"secret,omitempty"`
+	PublicKey  []byte     `json:"publicKey,omitempty"`
+	PrivateKey []byte     `json:"privateKey,omitempty"`
+	NotBefore  *time.Time `json:"notBefore,omitempty"`
+	NotAfter   *time.Time `json:"notAfter,omitempty"`
+}
+
+// ValidAt reports whether the key may be used at t.
+func (k Key) ValidAt(t time.Time) bool {
+	return (k.NotBefore == nil || !t.Before(*k.NotBefore)) && (k.NotAfter == nil || t.Before(*k.NotAfter))
+}
+
+// canSign reports whether the key holds the material to sign.
+func (k Key) canSign() bool {
+	switch k.Algorithm {
+	case HMACSHA256:
+		return len(k.Secret) > 0
+	case Ed25519:
+		return len(k.PrivateKey) == ed25519.PrivateKeySize
+	}
+	return false
+}
+
+// Public returns the key without the private key of an Ed25519 key pair,
+// for the server's key file.
+func (k Key) Public() Key {
+	k.PrivateKey = nil
+	return k
+}
+
+func (k Key) validate() error {
+	if k.ID == "" || k.Client == "" {
+		return errors.New("key without id or client")
+	}
+	switch k.Algorithm {
+	case HMACSHA256:
+		if len(k.Secret) < 16 {
+			return fmt.Errorf("key %s: secret shorter than 16 bytes", k.ID)
+		}
+	case Ed25519:
+		if len(k.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("key %s: invalid public key", k.ID)
+		}
+		if k.PrivateKey != nil && len(k.PrivateKey) != ed25519.PrivateKeySize {
+			return fmt.Errorf("key %s: invalid private key", k.ID)
+		}
+	default:
+		return fmt.Errorf("key %s: unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+	return nil
+}
+
+// GenerateKey creates a new key of client with algorithm.
+func GenerateKey(id, client string, algorithm Algorithm) (Key, error) {
+	k := Key{ID: id, Client: client, Algorithm: algorithm}
+	switch algorithm {
+	case HMACSHA256:
+		k.Secret = make([]byte, 32)
+		if _, err := rand.Read(k.Secret); err != nil {
+			return Key{}, err
+		}
+	case Ed25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Key{}, err
+		}
+		k.PublicKey, k.PrivateKey = public, private
+	}
+	return k, k.validate()
+}
+
+// KeyFile is the content of a key file.
+type KeyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// Keyring holds the keys of a key file. It can be reloaded while in use.
+type Keyring struct {
+	path string
+	keys atomic.Pointer[[]Key]
+}
+
+// LoadKeyring reads the key file at path.
+func LoadKeyring(path string) (*Keyring, error) {
+	r := &Keyring{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewKeyring returns a keyring holding keys.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	r := &Keyring{}
+	return r, r.set(keys)
+}
+
+// Reload reads the key file again. The keys in use are kept if the file
+// is invalid.
+func (r *Keyring) Reload() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	var file KeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("key file %s: %w", r.path, err)
+	}
+	return r.set(file.Keys)
+}
+
+func (r *Keyring) set(keys []Key) error {
+	ids := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if err := k.validate(); err != nil {
+			return err
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("duplicate key %s", k.ID)
+		}
+		ids[k.ID] = true
+	}
+	r.keys.Store(&keys)
+	return nil
+}
+
+// Get returns the key with id.
+func (r *Keyring) Get(id string) (Key, error) {
+	for _, k := range *r.keys.Load() {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return Key{}, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+}
+
+// signingKey returns the key with id, or if id is empty the last key in
+// the file that can sign at t.
+func (r *Keyring) signingKey(id string, t time.Time) (Key, error) {
+	if id != "" {
+		k, err := r.Get(id)
+		if err == nil && !k.canSign() {
+			err = fmt.Errorf("%w: key %s has no secret or private key", ErrNoSigningKey, id)
+		}
+		return k, err
+	}
+	keys := *r.keys.Load()
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i].canSign() && keys[i].ValidAt(t) {
+			return keys[i], nil
+		}
+	}
+	return Key{}, ErrNoSigningKey
+}
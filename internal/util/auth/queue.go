@@ -0,0 +1,51 @@
+package auth
+
+import "command-queue/internal/util/queue"
+
+// SignedQueue is a Queue decorator that signs every message it sends with
+// a Signer, after the other headers were set. Received messages are passed
+// on unchanged; the server verifies them.
+type SignedQueue struct {
+	queue  queue.Queue
+	signer *Signer
+}
+
+var _ queue.HeaderQueue = (*SignedQueue)(nil)
+
+// NewSignedQueue returns q signing with s.
+func NewSignedQueue(q queue.Queue, s *Signer) *SignedQueue {
+	return &SignedQueue{queue: q, signer: s}
+}
+
+// SendMessage signs message and sends it.
+func (q *SignedQueue) SendMessage(message string) error {
+	return q.SendMessageWithHeaders(message, nil)
+}
+
+// SendMessageWithHeaders signs message with headers and sends it. headers
+// is not changed.
+func (q *SignedQueue) SendMessageWithHeaders(message string, headers map[string]string) error {
+	h := make(map[string]string, len(headers)+4)
+	for key, value := range headers {
+		h[key] = value
+	}
+	if err := q.signer.Sign(message, h); err != nil {
+		return err
+	}
+	return queue.Send(q.queue, message, h)
+}
+
+// ReceiveMessages returns the messages of the underlying queue.
+func (q *SignedQueue) ReceiveMessages() (<-chan queue.Message, error) {
+	return queue.Receive(q.queue)
+}
+
+// ReceiveMessage returns the messages of the underlying queue.
+func (q *SignedQueue) ReceiveMessage() (<-chan string, error) {
+	return q.queue.ReceiveMessage()
+}
+
+// Close closes the underlying queue.
+func (q *SignedQueue) Close() error {
+	return q.queue.Close()
+}
@@ -0,0 +1,270 @@
+package changefeed
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSeqTooOld is returned when resuming from a sequence number whose events
+// are no longer retained.
+var ErrSeqTooOld = errors.New("changefeed: sequence number is no longer retained")
+
+// Op is the kind of change an Event describes.
+type Op string
+
+const (
+	OpSet    Op = "set"
+	OpDelete Op = "delete"
+	OpMove   Op = "move"
+	OpExpire Op = "expire"
+)
+
+// Event describes a change of one key. Seq is the version of the map after
+// the change; sequence numbers start at 1 and have no gaps. Namespace is ""
+// for keys of the default namespace.
+type Event struct {
+	Seq       uint64      `json:"seq"`
+	Op        Op          `json:"op"`
+	Namespace string      `json:"namespace,omitempty"`
+	Key       string      `json:"key"`
+	Old       interface{} `json:"old,omitempty"`
+	New       interface{} `json:"new,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// Filter selects the events of a subscription.
+type Filter struct {
+	Namespace string
+	// Key is the watched key, or the watched prefix if Prefix is set.
+	Key    string
+	Prefix bool
+}
+
+// Matches reports whether the filter selects events for key of namespace.
+func (f Filter) Matches(namespace, key string) bool {
+	if f.Namespace != namespace {
+		return false
+	}
+	if f.Prefix {
+		return strings.HasPrefix(key, f.Key)
+	}
+	return f.Key == key
+}
+
+// Feed orders change events, retains the most recent ones so that
+// subscribers can resume, and fans them out to subscriptions.
+type Feed struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	seq    uint64
+	log    []Event
+	retain int
+	subs   int
+}
+
+// New creates a Feed that retains the last retain events.
+func New(retain int) *Feed {
+	if retain < 1 {
+		retain = 1
+	}
+	f := &Feed{retain: retain}
+	f.cond = sync.NewCond(&f.mutex)
+	return f
+}
+
+// Publish assigns sequence numbers to events and hands them to the
+// subscriptions. It never blocks on slow subscribers.
+func (f *Feed) Publish(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.publish(events)
+}
+
+// PublishOrSkip publishes events if always is set or there are
+// subscriptions, and skips them like Skip otherwise. The decision is taken
+// under the feed's lock, so a subscription opened meanwhile either comes
+// after the skipped events or receives them.
+func (f *Feed) PublishOrSkip(always bool, events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if always || f.subs > 0 {
+		f.publish(events)
+	} else {
+		f.skip(len(events))
+	}
+}
+
+// Skip numbers n changes without retaining or delivering them, for changes
+// that nobody watches. Subscriptions cannot resume before them.
+func (f *Feed) Skip(n int) {
+	if n == 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.skip(n)
+}
+
+// publish appends events to the log. The caller must hold the mutex.
+func (f *Feed) publish(events []Event) {
+	for _, event := range events {
+		f.seq++
+		event.Seq = f.seq
+		f.log = append(f.log, event)
+	}
+	if len(f.log) > f.retain {
+		f.log = f.log[len(f.log)-f.retain:]
+	}
+	f.cond.Broadcast()
+}
+
+// skip numbers n changes. The caller must hold the mutex.
+func (f *Feed) skip(n int) {
+	f.seq += uint64(n)
+	f.log = nil
+}
+
+// Subscribers returns the number of open subscriptions.
+func (f *Feed) Subscribers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.subs
+}
+
+// Seq returns the sequence number of the last published event.
+func (f *Feed) Seq() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.seq
+}
+
+// Subscribe returns a subscription to the events selected by filter. With a
+// from of 0 only events published from now on are delivered; otherwise
+// delivery resumes at sequence number from, which must still be retained.
+func (f *Feed) Subscribe(filter Filter, from uint64) (*Subscription, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	next := f.seq + 1
+	if from > 0 {
+		if from < f.oldest() {
+			return nil, ErrSeqTooOld
+		}
+		next = from
+	}
+	ch := make(chan Event)
+	sub := &Subscription{
+		C:      ch,
+		feed:   f,
+		filter: filter,
+		next:   next,
+		done:   make(chan struct{}),
+	}
+	f.subs++
+	go sub.run(ch)
+	return sub, nil
+}
+
+// oldest returns the sequence number of the oldest retained event. The
+// caller must hold the mutex.
+func (f *Feed) oldest() uint64 {
+	if len(f.log) == 0 {
+		return f.seq + 1
+	}
+	return f.log[0].Seq
+}
+
+// pending waits until events from sequence number next on are available and
+// returns the retained ones. It returns false once the subscription is closed.
+func (f *Feed) pending(sub *Subscription) ([]Event, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for f.seq < sub.next && !sub.closed {
+		f.cond.Wait()
+	}
+	if sub.closed {
+		return nil, false
+	}
+	if sub.next < f.oldest() {
+		sub.err = ErrSeqTooOld
+		sub.end()
+		return nil, false
+	}
+	start := int(sub.next - f.log[0].Seq)
+	events := append([]Event(nil), f.log[start:]...)
+	sub.next = f.seq + 1
+	return events, true
+}
+
+// Subscription delivers the events selected by its filter on C, in order.
+// C is closed when the subscription is closed or falls so far behind that
+// events it has not received are no longer retained; Err tells which.
+type Subscription struct {
+	C <-chan Event
+
+	feed   *Feed
+	filter Filter
+	next   uint64 // guarded by feed.mutex
+	closed bool   // guarded by feed.mutex
+	ended  bool   // guarded by feed.mutex
+	err    error  // guarded by feed.mutex
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.feed.mutex.Lock()
+		s.closed = true
+		s.end()
+		s.feed.mutex.Unlock()
+		s.feed.cond.Broadcast()
+		close(s.done)
+	})
+}
+
+// end stops counting the subscription as a subscriber. The caller must hold
+// feed.mutex.
+func (s *Subscription) end() {
+	if !s.ended {
+		s.ended = true
+		s.feed.subs--
+	}
+}
+
+// Err returns ErrSeqTooOld if the subscription fell behind the retained events.
+func (s *Subscription) Err() error {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	return s.err
+}
+
+func (s *Subscription) run(ch chan<- Event) {
+	defer close(ch)
+	for {
+		events, ok := s.feed.pending(s)
+		if !ok {
+			return
+		}
+		for _, event := range events {
+			if !s.filter.Matches(event.Namespace, event.Key) {
+				continue
+			}
+			select {
+			case ch <- event:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
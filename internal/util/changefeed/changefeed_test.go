@@ -0,0 +1,164 @@
+package changefeed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.C:
+		if !ok {
+			t.Fatalf("Subscription closed: %v", sub.Err())
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+	return Event{}
+}
+
+func TestFeed(t *testing.T) {
+	f := New(10)
+	f.Publish(Event{Op: OpSet, Key: "a", New: "1"})
+
+	keySub, err := f.Subscribe(Filter{Key: "a"}, 0)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+	defer keySub.Close()
+	prefixSub, err := f.Subscribe(Filter{Key: "user:", Prefix: true}, 0)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+	defer prefixSub.Close()
+
+	f.Publish(
+		Event{Op: OpSet, Key: "user:1", New: "x"},
+		Event{Op: OpDelete, Key: "a", Old: "1"},
+	)
+
+	if event := receive(t, keySub); event.Seq != 3 || event.Op != OpDelete || event.Old != "1" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event := receive(t, prefixSub); event.Seq != 2 || event.Key != "user:1" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if f.Seq() != 3 {
+		t.Errorf("Expected seq 3, Got: %d", f.Seq())
+	}
+}
+
+func TestFeed_Resume(t *testing.T) {
+	f := New(3)
+	for i := 0; i < 5; i++ {
+		f.Publish(Event{Op: OpSet, Key: "a"})
+	}
+
+	// Events 3 to 5 are retained.
+	if _, err := f.Subscribe(Filter{Key: "a"}, 2); !errors.Is(err, ErrSeqTooOld) {
+		t.Errorf("Expected ErrSeqTooOld, Got: %v", err)
+	}
+	sub, err := f.Subscribe(Filter{Key: "a"}, 4)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+	if event := receive(t, sub); event.Seq != 4 {
+		t.Errorf("Expected seq 4, Got: %d", event.Seq)
+	}
+	if event := receive(t, sub); event.Seq != 5 {
+		t.Errorf("Expected seq 5, Got: %d", event.Seq)
+	}
+
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Errorf("Expected channel to be closed")
+	}
+	if sub.Err() != nil {
+		t.Errorf("Expected no error, Got: %v", sub.Err())
+	}
+}
+
+func TestFeed_SlowSubscriber(t *testing.T) {
+	f := New(2)
+	sub, err := f.Subscribe(Filter{Key: "a"}, 0)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+
+	// Events the subscriber has not received are dropped from the log, so
+	// the subscription ends instead of silently skipping them.
+	for i := 0; i < 10; i++ {
+		f.Publish(Event{Op: OpSet, Key: "a"})
+	}
+	for range sub.C {
+	}
+	if !errors.Is(sub.Err(), ErrSeqTooOld) {
+		t.Errorf("Expected ErrSeqTooOld, Got: %v", sub.Err())
+	}
+}
+
+func TestFeed_Skip(t *testing.T) {
+	f := New(10)
+	f.Publish(Event{Op: OpSet, Key: "a"})
+	f.Skip(3)
+	if f.Seq() != 4 {
+		t.Errorf("Expected seq 4, Got: %d", f.Seq())
+	}
+	// Skipped changes are not retained, so resuming before them fails.
+	if _, err := f.Subscribe(Filter{Key: "a"}, 1); !errors.Is(err, ErrSeqTooOld) {
+		t.Errorf("Expected ErrSeqTooOld, Got: %v", err)
+	}
+
+	sub, err := f.Subscribe(Filter{Key: "a"}, 0)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+	if f.Subscribers() != 1 {
+		t.Errorf("Expected 1 subscriber, Got: %d", f.Subscribers())
+	}
+	f.Publish(Event{Op: OpSet, Key: "a"})
+	if event := <-sub.C; event.Seq != 5 {
+		t.Errorf("Expected seq 5, Got: %d", event.Seq)
+	}
+	sub.Close()
+	sub.Close()
+	if f.Subscribers() != 0 {
+		t.Errorf("Expected 0 subscribers, Got: %d", f.Subscribers())
+	}
+}
+
+func TestFeed_PublishOrSkip(t *testing.T) {
+	f := New(10000)
+	f.PublishOrSkip(false, Event{Op: OpSet, Key: "a"})
+	if f.Seq() != 1 {
+		t.Errorf("Expected seq 1, Got: %d", f.Seq())
+	}
+
+	// A subscription opened while events are skipped receives the next one.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Microsecond):
+				f.PublishOrSkip(false, Event{Op: OpSet, Key: "a"})
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		sub, err := f.Subscribe(Filter{Key: "a"}, 0)
+		if err != nil {
+			t.Fatalf("Subscribe returned an error: %v", err)
+		}
+		receive(t, sub)
+		sub.Close()
+	}
+	close(done)
+	<-stopped
+}
@@ -0,0 +1,271 @@
+// Package election lets several instances agree on one leader through a
+// lease kept in a shared store. Every new leader gets a larger fencing
+// token, so side effects of a leader that lost its lease can be rejected.
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"command-queue/internal/util/logger"
+)
+
+var (
+	// ErrLeaseHeld is returned when another instance holds the lease.
+	ErrLeaseHeld = errors.New("election: lease is held by another instance")
+	// ErrLeaseLost is returned by fencing checks once the lease was lost.
+	ErrLeaseLost = errors.New("election: lease lost")
+)
+
+// Lease is the right to lead until Expires.
+type Lease struct {
+	Holder string `json:"holder"`
+	// Addr is where the holder serves its replication log, if anywhere.
+	Addr    string    `json:"addr,omitempty"`
+	Token   uint64    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps the lease shared by all instances.
+type Store interface {
+	// Acquire takes the lease for holder, or renews it if holder already
+	// has it, until ttl from now. It returns the current lease together
+	// with ErrLeaseHeld if another instance holds it.
+	Acquire(holder, addr string, ttl time.Duration) (Lease, error)
+	// Release ends the lease if holder holds it with token.
+	Release(holder string, token uint64) error
+	// Get returns the current lease.
+	Get() (Lease, error)
+}
+
+// acquire computes the lease after holder asks for it at now. The token
+// grows whenever the lease changes hands or lapsed, including a lapse of
+// holder's own lease, since another instance may have acted on it.
+func acquire(current Lease, holder, addr string, ttl time.Duration, now time.Time) (Lease, error) {
+	valid := now.Before(current.Expires)
+	if current.Holder != "" && current.Holder != holder && valid {
+		return current, ErrLeaseHeld
+	}
+	next := Lease{Holder: holder, Addr: addr, Token: current.Token, Expires: now.Add(ttl)}
+	if current.Holder != holder || !valid {
+		next.Token++
+	}
+	return next, nil
+}
+
+// release computes the lease after holder gives it up at now.
+func release(current Lease, holder string, token uint64, now time.Time) (Lease, bool) {
+	if current.Holder != holder || current.Token != token {
+		return current, false
+	}
+	return Lease{Token: current.Token, Expires: now}, true
+}
+
+// MemStore keeps the lease in memory. It coordinates instances within one
+// process, which is mostly useful for tests.
+type MemStore struct {
+	mutex sync.Mutex
+	lease Lease
+	now   func() time.Time
+}
+
+// NewMemStore creates an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{now: time.Now}
+}
+
+func (m *MemStore) Acquire(holder, addr string, ttl time.Duration) (Lease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	lease, err := acquire(m.lease, holder, addr, ttl, m.now())
+	if err == nil {
+		m.lease = lease
+	}
+	return lease, err
+}
+
+func (m *MemStore) Release(holder string, token uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lease, _ = release(m.lease, holder, token, m.now())
+	return nil
+}
+
+func (m *MemStore) Get() (Lease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lease, nil
+}
+
+// Elector campaigns for the lease of a Store on behalf of one instance.
+type Elector struct {
+	store Store
+	id    string
+	addr  string
+	ttl   time.Duration
+	log   logger.Logger
+	now   func() time.Time
+
+	// held is the token of the lease last acquired, which Check trusts
+	// without reading the store until heldUntil, a third of ttl before the
+	// lease expires. It is cleared as soon as the instance steps down.
+	mutex     sync.Mutex
+	held      uint64
+	heldUntil time.Time
+}
+
+// NewElector creates an Elector for the instance id. addr is published in
+// the lease while the instance leads. The lease is renewed every third of
+// ttl.
+func NewElector(store Store, id, addr string, ttl time.Duration, log logger.Logger) *Elector {
+	return &Elector{
+		store: store,
+		id:    id,
+		addr:  addr,
+		ttl:   ttl,
+		log:   log.Component("election"),
+		now:   time.Now,
+	}
+}
+
+// Check returns nil if the instance still holds the lease with token. It is
+// the fencing check made before every side effect of a leader. While the
+// lease was renewed recently enough it is answered from memory; otherwise
+// it reads the store, so a leader that missed renewals finds out that it
+// was replaced.
+func (e *Elector) Check(token uint64) error {
+	e.mutex.Lock()
+	held := token != 0 && token == e.held && e.now().Before(e.heldUntil)
+	e.mutex.Unlock()
+	if held {
+		return nil
+	}
+	lease, err := e.store.Get()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLeaseLost, err)
+	}
+	if lease.Holder != e.id || lease.Token != token || !e.now().Before(lease.Expires) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Run campaigns until ctx is done. Once the lease is acquired, lead runs
+// with its fencing token and a context that is cancelled when the lease is
+// lost; it must return then. follow is called with the lease whenever
+// another instance is found leading. Run returns the error of lead if lead
+// returns on its own, after giving up the lease.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context, token uint64) error, follow func(Lease)) error {
+	var (
+		current  *term
+		renewed  time.Time
+		followed Lease
+	)
+	stepDown := func() {
+		e.hold(0, time.Time{})
+		if current != nil {
+			_ = current.stop()
+			current = nil
+		}
+	}
+	release := func(t *term) {
+		e.hold(0, time.Time{})
+		if err := e.store.Release(e.id, t.token); err != nil {
+			e.log.Warn("Error releasing lease", logger.KeyError, err)
+		}
+	}
+	defer func() {
+		if current != nil {
+			_ = current.stop()
+			release(current)
+		}
+	}()
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		start := e.now()
+		lease, err := e.store.Acquire(e.id, e.addr, e.ttl)
+		switch {
+		case err == nil:
+			if current != nil && lease.Token != current.token {
+				e.log.Warn("Lease lapsed", "token", current.token)
+				stepDown()
+			}
+			renewed = start
+			e.hold(lease.Token, start.Add(e.ttl-e.ttl/3))
+			if current == nil {
+				e.log.Info("Elected leader", "token", lease.Token)
+				current = startTerm(ctx, lead, lease.Token)
+			}
+		case errors.Is(err, ErrLeaseHeld):
+			if current != nil {
+				e.log.Warn("Lease taken over", "token", current.token, "holder", lease.Holder)
+				stepDown()
+			}
+			if lease.Holder != followed.Holder || lease.Token != followed.Token {
+				followed = lease
+				follow(lease)
+			}
+		default:
+			e.log.Error("Error acquiring lease", logger.KeyError, err)
+			// The lease cannot be confirmed; stop leading once it may have
+			// expired.
+			if current != nil && !e.now().Before(renewed.Add(e.ttl)) {
+				stepDown()
+			}
+		}
+
+		var done <-chan struct{}
+		if current != nil {
+			done = current.done
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			t := current
+			current = nil
+			release(t)
+			return t.stop()
+		case <-ticker.C:
+		}
+	}
+}
+
+// hold records that the lease is held with token until the time Check
+// stops trusting it; a zero token clears it.
+func (e *Elector) hold(token uint64, until time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.held, e.heldUntil = token, until
+}
+
+// term is one period of leadership.
+type term struct {
+	token  uint64
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// startTerm runs lead in the background.
+func startTerm(ctx context.Context, lead func(context.Context, uint64) error, token uint64) *term {
+	ctx, cancel := context.WithCancel(ctx)
+	t := &term{token: token, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		t.err = lead(ctx, token)
+	}()
+	return t
+}
+
+// stop ends the term and waits for lead to return.
+func (t *term) stop() error {
+	t.cancel()
+	<-t.done
+	return t.err
+}
@@ -0,0 +1,183 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"command-queue/internal/util/logger"
+)
+
+func testStore(t *testing.T, store Store, setNow func(time.Time)) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(now)
+
+	lease, err := store.Acquire("a", "host-a:7000", 10*time.Second)
+	if err != nil || lease.Token != 1 || lease.Holder != "a" || lease.Addr != "host-a:7000" {
+		t.Fatalf("Unexpected lease %+v, %v", lease, err)
+	}
+	lease, err = store.Acquire("b", "", 10*time.Second)
+	if !errors.Is(err, ErrLeaseHeld) || lease.Holder != "a" {
+		t.Errorf("Expected ErrLeaseHeld with the lease of a, Got: %+v, %v", lease, err)
+	}
+
+	// Renewing keeps the token.
+	setNow(now.Add(5 * time.Second))
+	if lease, err = store.Acquire("a", "host-a:7000", 10*time.Second); err != nil || lease.Token != 1 {
+		t.Errorf("Unexpected renewed lease %+v, %v", lease, err)
+	}
+
+	// Once the lease expired another instance takes it with a new token.
+	setNow(now.Add(15 * time.Second))
+	if lease, err = store.Acquire("b", "", 10*time.Second); err != nil || lease.Token != 2 {
+		t.Errorf("Unexpected lease %+v, %v", lease, err)
+	}
+
+	// Releasing with a stale token does nothing.
+	if err := store.Release("b", 1); err != nil {
+		t.Errorf("Release returned an error: %v", err)
+	}
+	if lease, _ = store.Get(); lease.Holder != "b" {
+		t.Errorf("Expected b to hold the lease, Got: %+v", lease)
+	}
+	if err := store.Release("b", 2); err != nil {
+		t.Errorf("Release returned an error: %v", err)
+	}
+	if lease, err = store.Acquire("a", "", 10*time.Second); err != nil || lease.Token != 3 {
+		t.Errorf("Unexpected lease %+v, %v", lease, err)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	store := NewMemStore()
+	testStore(t, store, func(now time.Time) { store.now = func() time.Time { return now } })
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	store := NewFileStore(path)
+	testStore(t, store, func(now time.Time) { store.now = func() time.Time { return now } })
+
+	// Another store on the same file sees the lease.
+	lease, err := NewFileStore(path).Get()
+	if err != nil || lease.Holder != "a" || lease.Token != 3 {
+		t.Errorf("Unexpected lease %+v, %v", lease, err)
+	}
+}
+
+func TestElector(t *testing.T) {
+	store := NewMemStore()
+	ttl := 60 * time.Millisecond
+	var leader atomic.Value
+	var tokens [2]atomic.Uint64
+
+	run := func(ctx context.Context, id string, i int) <-chan error {
+		e := NewElector(store, id, id+":7000", ttl, logger.NewConsoleLogger())
+		done := make(chan error, 1)
+		go func() {
+			done <- e.Run(ctx, func(ctx context.Context, token uint64) error {
+				if err := e.Check(token); err != nil {
+					t.Errorf("Check failed for the new leader: %v", err)
+				}
+				tokens[i].Store(token)
+				leader.Store(id)
+				<-ctx.Done()
+				return nil
+			}, func(lease Lease) {})
+		}()
+		return done
+	}
+	waitFor := func(id string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for leader.Load() != id {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not elected", id)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	doneA := run(ctxA, "a", 0)
+	waitFor("a")
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	doneB := run(ctxB, "b", 1)
+
+	// b only takes over after a gave up the lease.
+	time.Sleep(2 * ttl)
+	if leader.Load() != "a" {
+		t.Fatalf("Expected a to keep leading")
+	}
+	stopA()
+	if err := <-doneA; err != nil {
+		t.Errorf("Run returned an error: %v", err)
+	}
+	waitFor("b")
+	if tokens[1].Load() <= tokens[0].Load() {
+		t.Errorf("Expected a larger token for b, Got: %d after %d", tokens[1].Load(), tokens[0].Load())
+	}
+
+	// The old leader's token is fenced off.
+	e := NewElector(store, "a", "", ttl, logger.NewConsoleLogger())
+	if err := e.Check(tokens[0].Load()); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, Got: %v", err)
+	}
+	stopB()
+	<-doneB
+}
+
+// countingStore counts the reads of a Store.
+type countingStore struct {
+	Store
+	gets atomic.Int32
+}
+
+func (c *countingStore) Get() (Lease, error) {
+	c.gets.Add(1)
+	return c.Store.Get()
+}
+
+func TestElector_CheckHeld(t *testing.T) {
+	mem := NewMemStore()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.now = func() time.Time { return now }
+	store := &countingStore{Store: mem}
+	e := NewElector(store, "a", "", 30*time.Second, logger.NewConsoleLogger())
+	e.now = func() time.Time { return now }
+
+	lease, err := store.Acquire("a", "", 30*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire returned an error: %v", err)
+	}
+	e.hold(lease.Token, now.Add(20*time.Second))
+
+	// A recently renewed lease is checked without reading the store.
+	if err := e.Check(lease.Token); err != nil || store.gets.Load() != 0 {
+		t.Errorf("Unexpected check: %v after %d reads", err, store.gets.Load())
+	}
+	// Another token is checked against the store.
+	if err := e.Check(lease.Token + 1); !errors.Is(err, ErrLeaseLost) || store.gets.Load() != 1 {
+		t.Errorf("Unexpected check: %v after %d reads", err, store.gets.Load())
+	}
+	// Close to the expiry the store is read again.
+	now = now.Add(25 * time.Second)
+	if err := e.Check(lease.Token); err != nil || store.gets.Load() != 2 {
+		t.Errorf("Unexpected check: %v after %d reads", err, store.gets.Load())
+	}
+	now = now.Add(10 * time.Second)
+	if err := e.Check(lease.Token); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, Got: %v", err)
+	}
+	// Stepping down clears the held lease.
+	now = now.Add(-30 * time.Second)
+	e.hold(0, time.Time{})
+	if err := e.Check(lease.Token); err != nil || store.gets.Load() != 4 {
+		t.Errorf("Unexpected check: %v after %d reads", err, store.gets.Load())
+	}
+}
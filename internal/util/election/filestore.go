@@ -0,0 +1,102 @@
+package election
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore keeps the lease in a JSON file, typically on a volume shared by
+// all instances. Reads and updates hold an exclusive lock on a companion
+// ".lock" file, which the operating system releases if the process dies.
+type FileStore struct {
+	path string
+	now  func() time.Time
+}
+
+// NewFileStore creates a FileStore keeping the lease at path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path, now: time.Now}
+}
+
+func (f *FileStore) Acquire(holder, addr string, ttl time.Duration) (Lease, error) {
+	var lease Lease
+	err := f.update(func(current Lease) (Lease, bool, error) {
+		var err error
+		lease, err = acquire(current, holder, addr, ttl, f.now())
+		return lease, err == nil, err
+	})
+	return lease, err
+}
+
+func (f *FileStore) Release(holder string, token uint64) error {
+	return f.update(func(current Lease) (Lease, bool, error) {
+		lease, ok := release(current, holder, token, f.now())
+		return lease, ok, nil
+	})
+}
+
+func (f *FileStore) Get() (Lease, error) {
+	var lease Lease
+	err := f.update(func(current Lease) (Lease, bool, error) {
+		lease = current
+		return current, false, nil
+	})
+	return lease, err
+}
+
+// update reads the lease and, if fn asks for it, writes the lease fn
+// returns, all while holding the lock.
+func (f *FileStore) update(fn func(Lease) (Lease, bool, error)) error {
+	lock, err := os.OpenFile(f.path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	var current Lease
+	data, err := os.ReadFile(f.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+	}
+
+	next, write, err := fn(current)
+	if !write {
+		return err
+	}
+	data, err = json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	// Write a temporary file and rename it, so a crash cannot leave a
+	// truncated lease behind.
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,78 @@
+// Package hashring assigns keys to shards by consistent hashing, so that
+// adding or removing a shard only moves the keys of that shard.
+package hashring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of points each shard has on the ring.
+const DefaultReplicas = 128
+
+// Ring maps keys to shard names.
+type Ring struct {
+	points []uint64
+	owners map[uint64]string
+	shards []string
+}
+
+// New creates a Ring of shards with replicas points per shard. Shards are
+// identified by name, so a Ring built from the same names always assigns
+// keys the same way regardless of their order.
+func New(shards []string, replicas int) *Ring {
+	if replicas < 1 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{
+		owners: make(map[uint64]string, len(shards)*replicas),
+		shards: append([]string(nil), shards...),
+	}
+	sort.Strings(r.shards)
+	for _, shard := range r.shards {
+		for i := 0; i < replicas; i++ {
+			point := hash(shard + "#" + strconv.Itoa(i))
+			// On a collision the shard sorting first keeps the point.
+			if _, ok := r.owners[point]; ok {
+				continue
+			}
+			r.owners[point] = shard
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Shards returns the names of the shards in lexical order.
+func (r *Ring) Shards() []string {
+	return append([]string(nil), r.shards...)
+}
+
+// Owner returns the shard key belongs to, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV spreads short, similar strings poorly over the high bits; mix
+	// them before placing points on the ring.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
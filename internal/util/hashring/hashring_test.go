@@ -0,0 +1,50 @@
+package hashring
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing_Owner(t *testing.T) {
+	if owner := New(nil, 0).Owner("a"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, Got: %q", owner)
+	}
+
+	r := New([]string{"s2", "s1", "s3"}, 0)
+	same := New([]string{"s1", "s2", "s3"}, 0)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner := r.Owner(key)
+		if owner != same.Owner(key) {
+			t.Fatalf("Key %q has different owners depending on shard order", key)
+		}
+		counts[owner]++
+	}
+	for _, shard := range r.Shards() {
+		// Each shard should get roughly a third of the keys.
+		if counts[shard] < 700 || counts[shard] > 1300 {
+			t.Errorf("Unbalanced ring: %v", counts)
+			break
+		}
+	}
+}
+
+func TestRing_AddShard(t *testing.T) {
+	before := New([]string{"s1", "s2", "s3"}, 0)
+	after := New([]string{"s1", "s2", "s3", "s4"}, 0)
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if before.Owner(key) != after.Owner(key) {
+			if after.Owner(key) != "s4" {
+				t.Fatalf("Key %q moved between existing shards", key)
+			}
+			moved++
+		}
+	}
+	// About a quarter of the keys move to the new shard.
+	if moved < 600 || moved > 1400 {
+		t.Errorf("Expected about 1000 keys to move, Got: %d", moved)
+	}
+}
@@ -0,0 +1,398 @@
+// Package lineedit reads lines from a terminal with Emacs-style editing,
+// history and tab completion. Input that is not a terminal is read line by
+// line without editing.
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInterrupted is returned by ReadLine when the user presses Ctrl-C.
+var ErrInterrupted = errors.New("interrupted")
+
+// DefaultHistorySize is the number of lines kept in the history.
+const DefaultHistorySize = 1000
+
+// Completer returns the completions of the text before the cursor. start is
+// the byte offset in before of the word that the candidates replace.
+type Completer func(before string) (start int, candidates []string)
+
+// Editor reads lines. It is not safe for concurrent use, except for Close.
+type Editor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int
+	editing     bool
+	complete    Completer
+	history     []string
+	historySize int
+	historyFile string
+
+	mu      sync.Mutex
+	restore func() error
+}
+
+// Option configures an Editor.
+type Option func(*Editor)
+
+// WithCompleter completes the word before the cursor with complete when the
+// user presses Tab.
+func WithCompleter(complete Completer) Option {
+	return func(e *Editor) {
+		e.complete = complete
+	}
+}
+
+// WithHistoryFile loads the history from path, if it exists, and appends
+// the lines added to the history to it.
+func WithHistoryFile(path string) Option {
+	return func(e *Editor) {
+		e.historyFile = path
+	}
+}
+
+// WithHistorySize keeps the last size lines in the history.
+func WithHistorySize(size int) Option {
+	return func(e *Editor) {
+		e.historySize = size
+	}
+}
+
+// IsTerminal reports whether f is a terminal that lines can be edited on.
+func IsTerminal(f *os.File) bool {
+	return isTerminal(int(f.Fd()))
+}
+
+// New returns an Editor that reads from in and echoes to out. Lines are only
+// edited if in is a terminal.
+func New(in io.Reader, out io.Writer, opts ...Option) (*Editor, error) {
+	e := &Editor{
+		in:          bufio.NewReader(in),
+		out:         out,
+		fd:          -1,
+		historySize: DefaultHistorySize,
+	}
+	if f, ok := in.(*os.File); ok && IsTerminal(f) {
+		e.fd = int(f.Fd())
+		e.editing = true
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.historyFile != "" {
+		if err := e.loadHistory(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *Editor) loadHistory() error {
+	data, err := os.ReadFile(e.historyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	e.trimHistory()
+	return nil
+}
+
+func (e *Editor) trimHistory() {
+	if len(e.history) > e.historySize {
+		e.history = append([]string(nil), e.history[len(e.history)-e.historySize:]...)
+	}
+}
+
+// History returns the lines of the history, oldest first.
+func (e *Editor) History() []string {
+	return append([]string(nil), e.history...)
+}
+
+// AddHistory adds line to the history unless it is empty or repeats the
+// last line, and appends it to the history file.
+func (e *Editor) AddHistory(line string) error {
+	if line == "" || strings.Contains(line, "\n") ||
+		(len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return nil
+	}
+	e.history = append(e.history, line)
+	e.trimHistory()
+	if e.historyFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return f.Close()
+}
+
+// Close restores the terminal if a ReadLine left it in raw mode, for
+// example when the process exits on a signal.
+func (e *Editor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.restore == nil {
+		return nil
+	}
+	err := e.restore()
+	e.restore = nil
+	return err
+}
+
+// ReadLine writes prompt and returns the next line without its line ending.
+// It returns io.EOF at the end of the input or when Ctrl-D is pressed on an
+// empty line, and ErrInterrupted when Ctrl-C is pressed.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	if e.editing && e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		e.mu.Lock()
+		e.restore = restore
+		e.mu.Unlock()
+		defer e.Close()
+	}
+	if !e.editing {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	return e.edit(prompt)
+}
+
+// line is the state of the line being edited.
+type line struct {
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+func (e *Editor) edit(prompt string) (string, error) {
+	l := &line{prompt: prompt}
+	// The history entries are browsed together with the new line, which is
+	// kept when the user moves away from it.
+	entries := append(e.History(), "")
+	index := len(entries) - 1
+	e.refresh(l)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(l.buf), nil
+		case ctrl('C'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case ctrl('D'):
+			if len(l.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.delete(l.pos, l.pos+1)
+		case ctrl('A'):
+			l.pos = 0
+		case ctrl('E'):
+			l.pos = len(l.buf)
+		case ctrl('B'):
+			l.move(-1)
+		case ctrl('F'):
+			l.move(1)
+		case ctrl('H'), 127:
+			if l.pos > 0 {
+				l.delete(l.pos-1, l.pos)
+			}
+		case ctrl('K'):
+			l.delete(l.pos, len(l.buf))
+		case ctrl('U'):
+			l.delete(0, l.pos)
+		case ctrl('W'):
+			l.delete(l.wordStart(), l.pos)
+		case ctrl('L'):
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case ctrl('P'), ctrl('N'):
+			delta := -1
+			if r == ctrl('N') {
+				delta = 1
+			}
+			index = e.browse(l, entries, index, delta)
+		case '\t':
+			e.completeWord(l)
+		case '\x1b':
+			switch e.readEscape() {
+			case "[A", "OA":
+				index = e.browse(l, entries, index, -1)
+			case "[B", "OB":
+				index = e.browse(l, entries, index, 1)
+			case "[C", "OC":
+				l.move(1)
+			case "[D", "OD":
+				l.move(-1)
+			case "[H", "OH", "[1~", "[7~":
+				l.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				l.pos = len(l.buf)
+			case "[3~":
+				l.delete(l.pos, l.pos+1)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				l.insert(string(r))
+			}
+		}
+		e.refresh(l)
+	}
+}
+
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// readEscape reads the rest of an escape sequence: "[" or "O", optional
+// digits and a final character.
+func (e *Editor) readEscape() string {
+	var seq strings.Builder
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return seq.String()
+		}
+		seq.WriteRune(r)
+		if seq.Len() > 1 && (r < '0' || r > '9') && r != ';' {
+			return seq.String()
+		}
+		if seq.Len() == 1 && r != '[' && r != 'O' {
+			return seq.String()
+		}
+	}
+}
+
+// browse replaces the line with the history entry delta away from index
+// and returns the new index.
+func (e *Editor) browse(l *line, entries []string, index, delta int) int {
+	next := index + delta
+	if next < 0 || next >= len(entries) {
+		return index
+	}
+	entries[index] = string(l.buf)
+	l.buf = []rune(entries[next])
+	l.pos = len(l.buf)
+	return next
+}
+
+// completeWord completes the word before the cursor if it has one
+// completion or the candidates share a longer prefix, and otherwise lists
+// the candidates.
+func (e *Editor) completeWord(l *line) {
+	if e.complete == nil {
+		return
+	}
+	before := string(l.buf[:l.pos])
+	start, candidates := e.complete(before)
+	if start < 0 || start > len(before) || len(candidates) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+	word := before[start:]
+	replacement := candidates[0]
+	for _, c := range candidates[1:] {
+		replacement = commonPrefix(replacement, c)
+	}
+	if len(candidates) == 1 || len(replacement) > len(word) {
+		l.delete(utf8.RuneCountInString(before[:start]), l.pos)
+		l.insert(replacement)
+		return
+	}
+	fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	for i > 0 && !utf8.ValidString(a[:i]) {
+		i--
+	}
+	return a[:i]
+}
+
+// refresh redraws the line and puts the cursor at its position.
+func (e *Editor) refresh(l *line) {
+	var b strings.Builder
+	b.WriteString("\r")
+	b.WriteString(l.prompt)
+	b.WriteString(string(l.buf))
+	b.WriteString("\x1b[K")
+	if back := len(l.buf) - l.pos; back > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", back)
+	}
+	fmt.Fprint(e.out, b.String())
+}
+
+func (l *line) insert(s string) {
+	r := []rune(s)
+	l.buf = append(l.buf[:l.pos], append(r, l.buf[l.pos:]...)...)
+	l.pos += len(r)
+}
+
+// delete removes the runes in [from, to).
+func (l *line) delete(from, to int) {
+	if to > len(l.buf) {
+		to = len(l.buf)
+	}
+	if from >= to {
+		return
+	}
+	l.buf = append(l.buf[:from], l.buf[to:]...)
+	if l.pos > to {
+		l.pos -= to - from
+	} else if l.pos > from {
+		l.pos = from
+	}
+}
+
+func (l *line) move(delta int) {
+	if pos := l.pos + delta; pos >= 0 && pos <= len(l.buf) {
+		l.pos = pos
+	}
+}
+
+// wordStart returns the position of the start of the word before the
+// cursor, skipping the spaces in between.
+func (l *line) wordStart() int {
+	i := l.pos
+	for i > 0 && unicode.IsSpace(l.buf[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(l.buf[i-1]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,151 @@
+package lineedit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newEditor returns an Editor that edits the keys typed in input as if it
+// read them from a terminal.
+func newEditor(t *testing.T, input string, opts ...Option) *Editor {
+	t.Helper()
+	e, err := New(strings.NewReader(input), io.Discard, opts...)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	e.editing = true
+	return e
+}
+
+func TestEditor_ReadLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  string
+	}{
+		{"Plain", "getItem('a')\r", "getItem('a')"},
+		{"Backspace", "getItem('ab\x7f')\r", "getItem('a')"},
+		{"Insert at start", "Item('a')\x01get\r", "getItem('a')"},
+		{"Arrows", "getItem'a')\x1b[D\x1b[D\x1b[D\x1b[D(\x1b[F\r", "getItem('a')"},
+		{"Delete", "getItem('ab')\x1b[D\x1b[D\x1b[D\x1b[3~\r", "getItem('a')"},
+		{"Kill to end", "getItem('a')junk\x1b[D\x1b[D\x1b[D\x1b[D\x0b\r", "getItem('a')"},
+		{"Kill to start", "junk \x15getItem('a')\r", "getItem('a')"},
+		{"Kill word", "getItem('a') junk\x17\x08\r", "getItem('a')"},
+		{"Unicode", "getItem('ä')\x02\x02\x08ö\r", "getItem('ö')"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, err := newEditor(t, test.input).ReadLine("> ")
+			if err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+			if line != test.line {
+				t.Errorf("Expected %q but got %q", test.line, line)
+			}
+		})
+	}
+}
+
+func TestEditor_Keys(t *testing.T) {
+	e := newEditor(t, "abc\x03\x04ab\x04\r")
+	if _, err := e.ReadLine("> "); !errors.Is(err, ErrInterrupted) {
+		t.Errorf("Expected Ctrl-C to interrupt, got %v", err)
+	}
+	if _, err := e.ReadLine("> "); err != io.EOF {
+		t.Errorf("Expected Ctrl-D on an empty line to end the input, got %v", err)
+	}
+	if line, _ := e.ReadLine("> "); line != "ab" {
+		t.Errorf("Expected Ctrl-D at the end of a line to do nothing, got %q", line)
+	}
+	if _, err := e.ReadLine("> "); err != io.EOF {
+		t.Errorf("Expected the end of the input, got %v", err)
+	}
+}
+
+func TestEditor_History(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("first\nsecond\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Up twice, down once, and edit the entry; then down to the new line.
+	e := newEditor(t, "\x1b[A\x1b[A\x1b[B!\rnew\x1b[A\x1b[B\r", WithHistoryFile(path), WithHistorySize(3))
+	line, err := e.ReadLine("> ")
+	if err != nil || line != "second!" {
+		t.Fatalf("Expected second! but got %q, %v", line, err)
+	}
+	if err := e.AddHistory(line); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if line, _ := e.ReadLine("> "); line != "new" {
+		t.Errorf("Expected the new line to be kept while browsing, got %q", line)
+	}
+	e.AddHistory("new")
+	e.AddHistory("new")
+	e.AddHistory("")
+	if want := []string{"second", "second!", "new"}; !reflect.DeepEqual(e.History(), want) {
+		t.Errorf("Expected history %v but got %v", want, e.History())
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "first\nsecond\nsecond!\nnew\n" {
+		t.Errorf("Expected lines to be appended to the history file, got %q", data)
+	}
+}
+
+func TestEditor_Complete(t *testing.T) {
+	complete := func(before string) (int, []string) {
+		start := strings.LastIndex(before, "'") + 1
+		var candidates []string
+		for _, key := range []string{"apple", "apricot", "banana"} {
+			if strings.HasPrefix(key, before[start:]) {
+				candidates = append(candidates, key)
+			}
+		}
+		return start, candidates
+	}
+	tests := []struct {
+		input string
+		line  string
+		out   string
+	}{
+		{"getItem('b\t')\r", "getItem('banana')", ""},
+		{"getItem('a\t\t')\r", "getItem('ap')", "apple  apricot"},
+		{"getItem('c\t')\r", "getItem('c')", "\a"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		e := newEditor(t, test.input, WithCompleter(complete))
+		e.out = &out
+		line, err := e.ReadLine("> ")
+		if err != nil || line != test.line {
+			t.Errorf("%q: expected %q but got %q, %v", test.input, test.line, line, err)
+		}
+		if !strings.Contains(out.String(), test.out) {
+			t.Errorf("%q: expected the output to contain %q, got %q", test.input, test.out, out.String())
+		}
+	}
+}
+
+func TestEditor_NotTerminal(t *testing.T) {
+	var out bytes.Buffer
+	e, err := New(strings.NewReader("getItem('a')\r\n\x01last"), &out)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	for _, want := range []string{"getItem('a')", "\x01last"} {
+		if line, err := e.ReadLine("> "); err != nil || line != want {
+			t.Errorf("Expected %q but got %q, %v", want, line, err)
+		}
+	}
+	if _, err := e.ReadLine("> "); err != io.EOF {
+		t.Errorf("Expected the end of the input, got %v", err)
+	}
+	if out.String() != "> > > " {
+		t.Errorf("Expected only prompts, got %q", out.String())
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package lineedit
+
+import "errors"
+
+// Line editing is only supported on Linux terminals; elsewhere lines are
+// read as the terminal delivers them.
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.New("raw mode is not supported")
+}
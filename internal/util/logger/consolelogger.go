@@ -1,18 +1,11 @@
 package logger
 
 import (
-	"log"
+	"log/slog"
 )
 
-// ConsoleLogger implements the Logger interface for logging to the console.
-type ConsoleLogger struct{}
-
-// NewConsoleLogger creates a new instance of ConsoleLogger.
+// NewConsoleLogger creates a logger that writes entries at info level and
+// above through the standard log package.
 func NewConsoleLogger() Logger {
-	return &ConsoleLogger{}
-}
-
-// Logf logs a formatted message to the console.
-func (c *ConsoleLogger) Printf(format string, args ...interface{}) {
-	log.Printf(format, args...)
+	return newSlogLogger(slog.Default(), Levels{Default: LevelInfo})
 }
@@ -1,5 +1,102 @@
 package logger
 
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level = slog.Level
+
+// Log levels, from the most to the least verbose.
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+)
+
+// Keys of the structured fields shared by all components.
+const (
+	KeyComponent      = "component"
+	KeyCommandType    = "command"
+	KeyNamespace      = "namespace"
+	KeyKey            = "key"
+	KeyMessageID      = "messageId"
+	KeyWorker         = "worker"
+	KeyClient         = "client"
+	KeyIdempotencyKey = "idempotencyKey"
+	KeyError          = "error"
+)
+
+// Logger logs leveled entries with structured fields. Fields are passed as
+// alternating keys and values, as with log/slog.
 type Logger interface {
+	// Printf logs a formatted message at info level.
 	Printf(format string, args ...interface{})
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+	// With returns a logger that adds the fields args to every entry.
+	With(args ...interface{}) Logger
+	// Component returns a logger for the named component. Its entries carry
+	// the component field and are filtered by the component's level.
+	Component(name string) Logger
+}
+
+// Levels holds the default log level and the levels of single components.
+type Levels struct {
+	Default    Level
+	Components map[string]Level
+}
+
+// For returns the level of component.
+func (l Levels) For(component string) Level {
+	if level, ok := l.Components[component]; ok {
+		return level
+	}
+	return l.Default
+}
+
+// min returns the most verbose of the levels.
+func (l Levels) min() Level {
+	level := l.Default
+	for _, c := range l.Components {
+		if c < level {
+			level = c
+		}
+	}
+	return level
+}
+
+// ParseLevels parses a comma separated list of levels such as
+// "info,replication=debug,election=warn". An entry without a component sets
+// the default level, which is info if it is not given.
+func ParseLevels(s string) (Levels, error) {
+	levels := Levels{Default: LevelInfo}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, name, found := strings.Cut(entry, "=")
+		if !found {
+			component, name = "", entry
+		}
+		var level Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return Levels{}, fmt.Errorf("invalid log level %q: %w", entry, err)
+		}
+		if component == "" {
+			levels.Default = level
+			continue
+		}
+		if levels.Components == nil {
+			levels.Components = make(map[string]Level)
+		}
+		levels.Components[component] = level
+	}
+	return levels, nil
 }
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("warn, replication=debug,election=error")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if levels.For("server") != LevelWarn {
+		t.Errorf("Expected default level warn but got %v", levels.For("server"))
+	}
+	if levels.For("replication") != LevelDebug {
+		t.Errorf("Expected replication level debug but got %v", levels.For("replication"))
+	}
+	if levels.For("election") != LevelError {
+		t.Errorf("Expected election level error but got %v", levels.For("election"))
+	}
+
+	levels, err = ParseLevels("")
+	if err != nil || levels.Default != LevelInfo {
+		t.Errorf("Expected info by default but got %v, %v", levels.Default, err)
+	}
+	if _, err := ParseLevels("server=loud"); err == nil {
+		t.Errorf("Expected error for an invalid level")
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	levels, _ := ParseLevels("info,replication=debug")
+	l, err := NewSlogLogger(&buf, FormatJSON, levels)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	l.Component("server").Debug("hidden")
+	l.Component("server").With(KeyWorker, 3).Info("processed", KeyCommandType, "addItem", KeyKey, "a")
+	l.Component("replication").Debug("shown")
+	l.Printf("formatted %d\n", 1)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 entries but got %d: %s", len(lines), buf.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Expected JSON but got: %v", err)
+	}
+	expected := map[string]interface{}{
+		"level": "INFO", "msg": "processed", KeyComponent: "server",
+		KeyWorker: 3.0, KeyCommandType: "addItem", KeyKey: "a",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s %v but got %v", key, value, entry[key])
+		}
+	}
+	if !strings.Contains(lines[1], `"msg":"shown"`) || !strings.Contains(lines[2], `"msg":"formatted 1"`) {
+		t.Errorf("Unexpected entries: %s", buf.String())
+	}
+
+	if _, err := NewSlogLogger(&buf, "xml", levels); err == nil {
+		t.Errorf("Expected error for an invalid format")
+	}
+}
+
+func TestSlogLogger_NestedComponent(t *testing.T) {
+	var buf bytes.Buffer
+	levels, _ := ParseLevels("warn,replication=debug")
+	l, err := NewSlogLogger(&buf, FormatText, levels)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	// A component of a component replaces it and takes its level, and
+	// keeps the fields of the outer logger.
+	server := l.Component("server").With(KeyWorker, 1)
+	server.Debug("hidden")
+	server.Component("replication").Debug("shown")
+
+	out := strings.TrimSpace(buf.String())
+	if strings.Contains(out, "hidden") || strings.Contains(out, "\n") {
+		t.Fatalf("Expected one entry but got: %s", out)
+	}
+	if strings.Count(out, KeyComponent+"=") != 1 || !strings.Contains(out, KeyComponent+"=replication") {
+		t.Errorf("Expected component replication once but got: %s", out)
+	}
+	if !strings.Contains(out, KeyWorker+"=1") {
+		t.Errorf("Expected the worker field but got: %s", out)
+	}
+}
+
+func TestTestLogger(t *testing.T) {
+	l := NewTestLogger()
+	l.Component("server").With(KeyMessageID, "m1").Warn("dropped", KeyError, "boom")
+	l.Debug("debug")
+
+	entry, ok := l.Find("dropped")
+	if !ok {
+		t.Fatalf("Expected entry to be captured")
+	}
+	if entry.Level != LevelWarn {
+		t.Errorf("Expected level warn but got %v", entry.Level)
+	}
+	expected := map[string]interface{}{KeyComponent: "server", KeyMessageID: "m1", KeyError: "boom"}
+	for key, value := range expected {
+		if entry.Fields[key] != value {
+			t.Errorf("Expected %s %v but got %v", key, value, entry.Fields[key])
+		}
+	}
+	if len(l.Entries()) != 2 {
+		t.Errorf("Expected 2 entries but got %d", len(l.Entries()))
+	}
+}
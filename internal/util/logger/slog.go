@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Format is the output format of a SlogLogger.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// SlogLogger implements the Logger interface on top of log/slog.
+type SlogLogger struct {
+	logger *slog.Logger
+	levels Levels
+	level  Level
+	// root is the logger without a component and args the fields added
+	// with With, so that Component replaces the component of an entry
+	// instead of adding a second one.
+	root *slog.Logger
+	args []interface{}
+}
+
+// NewSlogLogger creates a logger that writes entries to w in format, at the
+// levels of levels.
+func NewSlogLogger(w io.Writer, format Format, levels Levels) (*SlogLogger, error) {
+	// The handler lets every entry of an enabled component through; the
+	// logger filters by the level of its component.
+	opts := &slog.HandlerOptions{Level: levels.min()}
+	var handler slog.Handler
+	switch format {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return newSlogLogger(slog.New(handler), levels), nil
+}
+
+func newSlogLogger(logger *slog.Logger, levels Levels) *SlogLogger {
+	return &SlogLogger{logger: logger, levels: levels, level: levels.Default, root: logger}
+}
+
+// Printf logs a formatted message at info level.
+func (l *SlogLogger) Printf(format string, args ...interface{}) {
+	l.log(LevelInfo, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// Debug logs msg with the fields args at debug level.
+func (l *SlogLogger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args...)
+}
+
+// Info logs msg with the fields args at info level.
+func (l *SlogLogger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args...)
+}
+
+// Warn logs msg with the fields args at warn level.
+func (l *SlogLogger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args...)
+}
+
+// Error logs msg with the fields args at error level.
+func (l *SlogLogger) Error(msg string, args ...interface{}) {
+	l.log(LevelError, msg, args...)
+}
+
+// With returns a logger that adds the fields args to every entry.
+func (l *SlogLogger) With(args ...interface{}) Logger {
+	c := *l
+	c.logger = l.logger.With(args...)
+	c.args = append(append([]interface{}(nil), l.args...), args...)
+	return &c
+}
+
+// Component returns a logger for the named component, which replaces the
+// component of l. It keeps the fields added with With.
+func (l *SlogLogger) Component(name string) Logger {
+	c := *l
+	c.logger = l.root.With(KeyComponent, name).With(l.args...)
+	c.level = l.levels.For(name)
+	return &c
+}
+
+func (l *SlogLogger) log(level Level, msg string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.logger.Log(context.Background(), level, msg, args...)
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Entry is a log entry captured by a TestLogger.
+type Entry struct {
+	Level   Level
+	Message string
+	Fields  map[string]interface{}
+}
+
+// TestLogger is a Logger that captures entries of all levels, for
+// assertions in tests. Loggers derived from it with With or Component
+// capture into the same list.
+type TestLogger struct {
+	*SlogLogger
+	entries *entries
+}
+
+// NewTestLogger creates a TestLogger.
+func NewTestLogger() *TestLogger {
+	e := &entries{}
+	return &TestLogger{
+		SlogLogger: newSlogLogger(slog.New(&captureHandler{entries: e}), Levels{Default: LevelDebug}),
+		entries:    e,
+	}
+}
+
+// Entries returns the captured entries in the order they were logged.
+func (t *TestLogger) Entries() []Entry {
+	t.entries.mu.Lock()
+	defer t.entries.mu.Unlock()
+	return append([]Entry(nil), t.entries.list...)
+}
+
+// Find returns the first captured entry with message msg.
+func (t *TestLogger) Find(msg string) (Entry, bool) {
+	for _, e := range t.Entries() {
+		if e.Message == msg {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+type entries struct {
+	mu   sync.Mutex
+	list []Entry
+}
+
+// captureHandler is a slog.Handler that appends records to entries.
+type captureHandler struct {
+	entries *entries
+	attrs   []slog.Attr
+	group   string
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	e := Entry{Level: r.Level, Message: r.Message, Fields: make(map[string]interface{})}
+	for _, a := range h.attrs {
+		e.Fields[a.Key] = a.Value.Any()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		e.Fields[h.group+a.Key] = a.Value.Any()
+		return true
+	})
+	h.entries.mu.Lock()
+	h.entries.list = append(h.entries.list, e)
+	h.entries.mu.Unlock()
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, slog.Attr{Key: h.group + a.Key, Value: a.Value})
+	}
+	return &c
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.group = h.group + name + "."
+	return &c
+}
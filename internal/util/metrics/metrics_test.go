@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	commands := r.NewCounterVec("commands_total", "Commands by type.", "type")
+	commands.With("getItem").Inc()
+	commands.With("addItem").Add(2)
+	commands.With(`a"b`).Inc()
+	r.NewGaugeFunc("items", "Items in the map.", func() float64 { return 3 })
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText returned an error: %v", err)
+	}
+	expected := `# HELP commands_total Commands by type.
+# TYPE commands_total counter
+commands_total{type="a\"b"} 1
+commands_total{type="addItem"} 2
+commands_total{type="getItem"} 1
+# HELP items Items in the map.
+# TYPE items gauge
+items 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+`
+	if sb.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, sb.String())
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewHistogramVec("seconds", "Seconds.", []float64{1}, "type").With("get").Observe(2)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), `seconds_bucket{type="get",le="+Inf"} 1`) {
+		t.Errorf("Missing bucket in:\n%s", rec.Body.String())
+	}
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("a", "A.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for a duplicate metric")
+		}
+	}()
+	r.NewCounter("a", "A.")
+}
@@ -0,0 +1,67 @@
+package orderedmap
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+const benchKeys = 10000
+
+// benchmarkMixed runs a workload of gets, sets and deletes on random keys
+// from many goroutines. Every scanEvery-th operation reads the whole map.
+func benchmarkMixed(b *testing.B, m Map, getPct, setPct int, scanEvery int) {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		m.Set(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(rand.Int63()))
+		ops := 0
+		for pb.Next() {
+			ops++
+			if scanEvery > 0 && ops%scanEvery == 0 {
+				m.GetAll()
+				continue
+			}
+			key := keys[rnd.Intn(len(keys))]
+			switch op := rnd.Intn(100); {
+			case op < getPct:
+				m.Get(key)
+			case op < getPct+setPct:
+				m.Set(key, op)
+			default:
+				m.DeleteItem(key)
+			}
+		}
+	})
+}
+
+func BenchmarkMap(b *testing.B) {
+	workloads := []struct {
+		name           string
+		getPct, setPct int
+		scanEvery      int
+	}{
+		{name: "ReadHeavy", getPct: 90, setPct: 8},
+		{name: "WriteHeavy", getPct: 20, setPct: 60},
+		{name: "WithScans", getPct: 60, setPct: 30, scanEvery: 1000},
+	}
+	maps := []struct {
+		name string
+		new  func() Map
+	}{
+		{name: "OrderedMap", new: func() Map { return NewOrderedMap() }},
+		{name: "ShardedMap16", new: func() Map { return NewShardedMap(16) }},
+		{name: "ShardedMap64", new: func() Map { return NewShardedMap(64) }},
+	}
+	for _, w := range workloads {
+		for _, m := range maps {
+			b.Run(w.name+"/"+m.name, func(b *testing.B) {
+				benchmarkMixed(b, m.new(), w.getPct, w.setPct, w.scanEvery)
+			})
+		}
+	}
+}
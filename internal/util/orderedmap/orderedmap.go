@@ -1,97 +1,74 @@
 package orderedmap
 
 import (
-	"sync"
+	"errors"
 )
 
-type node struct {
-	key   string
-	value interface{}
-	prev  *node
-	next  *node
-}
+var (
+	// ErrKeyNotFound is returned when an operation refers to a missing key.
+	ErrKeyNotFound = errors.New("orderedmap: key not found")
+	// ErrKeyExists is returned when inserting a key that is already present.
+	ErrKeyExists = errors.New("orderedmap: key already exists")
+	// ErrNotPositional is returned when placing an item at a chosen position
+	// in a map whose order is given by its keys.
+	ErrNotPositional = errors.New("orderedmap: map is sorted by key")
+)
 
-type OrderedMap struct {
-	head   *node
-	tail   *node
-	values map[string]*node
-	mutex  sync.RWMutex // Mutex for concurrent access
-}
+// Map is implemented by the ordered maps of this package. The order in which
+// items are returned depends on the implementation.
+type Map interface {
+	// Set stores value under key. Updating an existing key keeps its position.
+	Set(key string, value interface{})
+	Get(key string) (interface{}, bool)
+	DeleteItem(key string)
+	Len() int
 
-func NewOrderedMap() OrderedMap {
-	return OrderedMap{
-		values: make(map[string]*node),
-		mutex:  sync.RWMutex{},
-	}
-}
+	// GetAll returns every item in map order.
+	GetAll() ([]string, []interface{})
+	// GetRange returns the items whose key is in [from, to), in map order.
+	// An empty to leaves the range unbounded.
+	GetRange(from, to string) ([]string, []interface{})
+	// GetPrefix returns the items whose key starts with prefix, in map order.
+	GetPrefix(prefix string) ([]string, []interface{})
+	// Snapshot returns a read-only view of the map as it is now. Later
+	// changes to the map are not visible through the snapshot.
+	Snapshot() *Snapshot
 
-func (om *OrderedMap) Set(key string, value interface{}) {
-	newNode := &node{
-		key:   key,
-		value: value,
-	}
-	om.mutex.Lock()
-	defer om.mutex.Unlock()
-	om.values[key] = newNode
+	// Update runs fn inside an atomic read-write transaction.
+	Update(fn func(tx *Tx) error) error
+	// View runs fn inside a read-only transaction.
+	View(fn func(tx *Tx) error) error
+}
 
-	if om.head == nil {
-		om.head = newNode
-		om.tail = newNode
-	} else {
-		om.tail.next = newNode
-		newNode.prev = om.tail
-		om.tail = newNode
-	}
+// OrderedMap is a map that remembers the order in which keys were inserted.
+// Items are kept in a treap indexed by position, so besides constant time
+// lookups by key it supports positional reads and inserts in O(log n).
+type OrderedMap struct {
+	store
 }
 
-func (om *OrderedMap) Get(key string) (interface{}, bool) {
-	om.mutex.RLock()
-	defer om.mutex.RUnlock()
+var _ Map = (*OrderedMap)(nil)
 
-	if n, ok := om.values[key]; ok {
-		return n.value, true
-	}
-	return nil, false
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{store: newStore(false)}
 }
 
-func (om *OrderedMap) DeleteItem(key string) {
-	om.mutex.Lock()
-	defer om.mutex.Unlock()
-
-	if n, ok := om.values[key]; ok {
-		if n.prev != nil {
-			n.prev.next = n.next
-		} else {
-			om.head = n.next
-		}
-		if n.next != nil {
-			n.next.prev = n.prev
-		} else {
-			om.tail = n.prev
-		}
-		delete(om.values, key)
-	}
+// InsertBefore inserts key right before the existing key.
+func (om *OrderedMap) InsertBefore(existing, key string, value interface{}) error {
+	return om.Update(func(tx *Tx) error { return tx.InsertBefore(existing, key, value) })
 }
 
-func (om *OrderedMap) keys() []string {
-	om.mutex.RLock()
-	defer om.mutex.RUnlock()
+// InsertAfter inserts key right after the existing key.
+func (om *OrderedMap) InsertAfter(existing, key string, value interface{}) error {
+	return om.Update(func(tx *Tx) error { return tx.InsertAfter(existing, key, value) })
+}
 
-	keys := make([]string, 0, len(om.values))
-	for n := om.head; n != nil; n = n.next {
-		keys = append(keys, n.key)
-	}
-	return keys
+// MoveToFront moves key to the first position.
+func (om *OrderedMap) MoveToFront(key string) error {
+	return om.Update(func(tx *Tx) error { return tx.MoveToFront(key) })
 }
 
-func (om *OrderedMap) GetAll() ([]string, []interface{}) {
-	om.mutex.RLock()
-	defer om.mutex.RUnlock()
-	keys := make([]string, 0, len(om.values))
-	values := make([]interface{}, 0, len(om.values))
-	for n := om.head; n != nil; n = n.next {
-		keys = append(keys, n.key)
-		values = append(values, n.value)
-	}
-	return keys, values
+// MoveToBack moves key to the last position.
+func (om *OrderedMap) MoveToBack(key string) error {
+	return om.Update(func(tx *Tx) error { return tx.MoveToBack(key) })
 }
@@ -1,7 +1,10 @@
 package orderedmap
 
 import (
+	"errors"
+	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -42,4 +45,245 @@ func TestOrderedMap(t *testing.T) {
 	if value, ok := om.Get("b"); !ok || value != 42 {
 		t.Errorf("Failed to update value for key 'b'. Expected: 42, Got: %v", value)
 	}
+	if keys := om.keys(); !reflect.DeepEqual(keys, expectedKeysAfterDelete) {
+		t.Errorf("Keys mismatch after update. Expected: %v, Got: %v", expectedKeysAfterDelete, keys)
+	}
+}
+
+func TestOrderedMap_Update(t *testing.T) {
+	om := NewOrderedMap()
+	om.Set("a", 1)
+	om.Set("b", 2)
+	om.Set("c", 3)
+
+	// A failing transaction leaves the map untouched.
+	errAbort := errors.New("abort")
+	err := om.Update(func(tx *Tx) error {
+		if _, err := tx.Delete("b"); err != nil {
+			return err
+		}
+		if err := tx.Set("a", 10); err != nil {
+			return err
+		}
+		if err := tx.Set("d", 4); err != nil {
+			return err
+		}
+		if _, err := tx.Delete("c"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("Expected abort error, Got: %v", err)
+	}
+	keys, values := om.GetAll()
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) || !reflect.DeepEqual(values, []interface{}{1, 2, 3}) {
+		t.Errorf("Map changed after rollback. Got: %v %v", keys, values)
+	}
+
+	// A successful transaction applies every change.
+	var changes []Change
+	err = om.Update(func(tx *Tx) error {
+		value, _ := tx.Get("a")
+		if err := tx.Set("d", value); err != nil {
+			return err
+		}
+		_, err := tx.Delete("a")
+		changes = tx.Changes()
+		return err
+	})
+	expectedChanges := []Change{
+		{Op: ChangeSet, Key: "d", New: 1},
+		{Op: ChangeDelete, Key: "a", Old: 1},
+	}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("Changes mismatch. Expected: %v, Got: %v", expectedChanges, changes)
+	}
+	if err != nil {
+		t.Errorf("Update returned an error: %v", err)
+	}
+	keys, values = om.GetAll()
+	if !reflect.DeepEqual(keys, []string{"b", "c", "d"}) || !reflect.DeepEqual(values, []interface{}{2, 3, 1}) {
+		t.Errorf("Unexpected map after commit. Got: %v %v", keys, values)
+	}
+
+	// Writes are rejected in read-only transactions.
+	err = om.View(func(tx *Tx) error {
+		return tx.Set("e", 5)
+	})
+	if !errors.Is(err, ErrTxReadOnly) {
+		t.Errorf("Expected ErrTxReadOnly, Got: %v", err)
+	}
+}
+
+func TestOrderedMap_Positional(t *testing.T) {
+	om := NewOrderedMap()
+	om.Set("b", 2)
+	om.Set("d", 4)
+
+	if err := om.InsertBefore("d", "c", 3); err != nil {
+		t.Fatalf("InsertBefore returned an error: %v", err)
+	}
+	if err := om.InsertBefore("b", "a", 1); err != nil {
+		t.Fatalf("InsertBefore returned an error: %v", err)
+	}
+	if err := om.InsertAfter("d", "e", 5); err != nil {
+		t.Fatalf("InsertAfter returned an error: %v", err)
+	}
+	if keys := om.keys(); !reflect.DeepEqual(keys, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("Keys mismatch after inserts. Got: %v", keys)
+	}
+
+	if err := om.InsertAfter("missing", "x", 0); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, Got: %v", err)
+	}
+	if err := om.InsertAfter("a", "b", 0); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists, Got: %v", err)
+	}
+
+	if err := om.MoveToFront("d"); err != nil {
+		t.Fatalf("MoveToFront returned an error: %v", err)
+	}
+	if err := om.MoveToBack("a"); err != nil {
+		t.Fatalf("MoveToBack returned an error: %v", err)
+	}
+	if keys := om.keys(); !reflect.DeepEqual(keys, []string{"d", "b", "c", "e", "a"}) {
+		t.Errorf("Keys mismatch after moves. Got: %v", keys)
+	}
+
+	if key, value, ok := om.GetAt(2); !ok || key != "c" || value != 3 {
+		t.Errorf("GetAt(2) mismatch. Got: %v %v %v", key, value, ok)
+	}
+	if _, _, ok := om.GetAt(5); ok {
+		t.Errorf("GetAt(5) should be out of range")
+	}
+	if key, _, _ := om.GetFirst(); key != "d" {
+		t.Errorf("GetFirst mismatch. Got: %v", key)
+	}
+	if key, _, _ := om.GetLast(); key != "a" {
+		t.Errorf("GetLast mismatch. Got: %v", key)
+	}
+	if key, value, ok := om.PopFirst(); !ok || key != "d" || value != 4 {
+		t.Errorf("PopFirst mismatch. Got: %v %v %v", key, value, ok)
+	}
+	if key, value, ok := om.PopLast(); !ok || key != "a" || value != 1 {
+		t.Errorf("PopLast mismatch. Got: %v %v %v", key, value, ok)
+	}
+	if keys := om.keys(); !reflect.DeepEqual(keys, []string{"b", "c", "e"}) {
+		t.Errorf("Keys mismatch after pops. Got: %v", keys)
+	}
+
+	// Positional changes are rolled back with the rest of a transaction.
+	_ = om.Update(func(tx *Tx) error {
+		_ = tx.MoveToBack("b")
+		_, _, _, _ = tx.PopFirst()
+		_ = tx.InsertBefore("e", "x", 0)
+		return errors.New("abort")
+	})
+	if keys := om.keys(); !reflect.DeepEqual(keys, []string{"b", "c", "e"}) {
+		t.Errorf("Keys mismatch after rollback. Got: %v", keys)
+	}
+}
+
+func TestOrderedMap_RandomInserts(t *testing.T) {
+	om := NewOrderedMap()
+	var expected []string
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := strconv.Itoa(i)
+		if len(expected) == 0 {
+			om.Set(key, i)
+			expected = append(expected, key)
+			continue
+		}
+		pos := rnd.Intn(len(expected))
+		pivot := expected[pos]
+		if rnd.Intn(2) == 0 {
+			_ = om.InsertBefore(pivot, key, i)
+		} else {
+			pos++
+			_ = om.InsertAfter(pivot, key, i)
+		}
+		expected = append(expected[:pos], append([]string{key}, expected[pos:]...)...)
+	}
+	if keys := om.keys(); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Keys mismatch after random inserts")
+	}
+	for i, key := range expected {
+		if got, _, _ := om.GetAt(i); got != key {
+			t.Fatalf("GetAt(%d) mismatch. Expected: %v, Got: %v", i, key, got)
+		}
+	}
+}
+
+func TestOrderedMap_InsertsAtOnePosition(t *testing.T) {
+	for name, m := range map[string]Map{"ordered": NewOrderedMap(), "sharded": NewShardedMap(4)} {
+		m.Set("a", 0)
+		m.Set("b", 0)
+		// Every item goes right after a or right before b, which lengthens
+		// the labels next to them until the map is relabeled.
+		expected := []string{"a", "b"}
+		for i := 0; i < 5000; i++ {
+			key := strconv.Itoa(i)
+			_ = m.Update(func(tx *Tx) error {
+				if i%2 == 0 {
+					return tx.InsertAfter("a", key, i)
+				}
+				return tx.InsertBefore("b", key, i)
+			})
+			pos := 1
+			if i%2 == 1 {
+				pos = len(expected) - 1
+			}
+			expected = append(expected[:pos], append([]string{key}, expected[pos:]...)...)
+		}
+		longest := 0
+		walkMerged(m.Snapshot().roots, func(n *node) bool {
+			if len(n.label) > longest {
+				longest = len(n.label)
+			}
+			return true
+		})
+		if longest > maxLabelLength {
+			t.Errorf("%s: Expected labels of at most %d bytes but got %d", name, maxLabelLength, longest)
+		}
+		if keys, _ := m.GetAll(); !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("%s: Keys mismatch after inserts at one position", name)
+		}
+
+		// A rolled back relabeling restores the previous labels.
+		before := m.Snapshot()
+		_ = m.Update(func(tx *Tx) error {
+			for i := 0; i < 1000; i++ {
+				_ = tx.InsertAfter("a", "x"+strconv.Itoa(i), i)
+			}
+			_, _ = tx.Delete("b")
+			return errors.New("abort")
+		})
+		beforeKeys, _ := before.GetAll()
+		if keys, _ := m.GetAll(); !reflect.DeepEqual(keys, beforeKeys) {
+			t.Errorf("%s: Keys mismatch after rollback", name)
+		}
+		if _ = m.Update(func(tx *Tx) error { return tx.InsertBefore("b", "y", 0) }); m.Len() != len(expected)+1 {
+			t.Errorf("%s: Expected an insert after the rollback", name)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	pairs := [][2]string{
+		{"a", "b"},
+		{"a", "c"},
+		{"a", "a\x01"},
+		{"a\x01", "b"},
+		{"a\xff", "b"},
+		{"a\xff\xff", "b"},
+		{counterLabel(labelOrigin), counterLabel(labelOrigin + 1)},
+	}
+	for _, pair := range pairs {
+		label := between(pair[0], pair[1])
+		if label <= pair[0] || label >= pair[1] || label[len(label)-1] == 0 {
+			t.Errorf("between(%q, %q) = %q", pair[0], pair[1], label)
+		}
+	}
 }
@@ -0,0 +1,295 @@
+package orderedmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ShardedMap is an insertion ordered map that spreads its keys over shards
+// with their own locks, so writes to different shards do not contend.
+//
+// Labels are drawn from counters shared by all shards, which keeps a global
+// insertion order: every shard is ordered by label and reads that span the
+// whole map merge the shards. Reads and writes of a single key lock only
+// its shard; transactions and scans lock every shard. Positional reads other
+// than the first and last item are linear in the position.
+type ShardedMap struct {
+	shards []shard
+	front  atomic.Uint64 // label counter for prepended items, counts down
+	back   atomic.Uint64 // label counter for appended items, counts up
+}
+
+type shard struct {
+	tree
+	mutex sync.RWMutex
+}
+
+var _ Map = (*ShardedMap)(nil)
+
+// NewShardedMap creates a ShardedMap with the given number of shards.
+func NewShardedMap(shards int) *ShardedMap {
+	if shards < 1 {
+		shards = 1
+	}
+	m := &ShardedMap{shards: make([]shard, shards)}
+	for i := range m.shards {
+		m.shards[i].tree = newTree()
+	}
+	m.front.Store(labelOrigin - 1)
+	m.back.Store(labelOrigin)
+	return m
+}
+
+// Set stores value under key. Updating an existing key keeps its position.
+func (m *ShardedMap) Set(key string, value interface{}) {
+	sh := m.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	m.set(key, value)
+}
+
+func (m *ShardedMap) Get(key string) (interface{}, bool) {
+	sh := m.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	if n, ok := sh.lookup(key); ok {
+		return n.value, true
+	}
+	return nil, false
+}
+
+func (m *ShardedMap) DeleteItem(key string) {
+	sh := m.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if n, ok := sh.lookup(key); ok {
+		sh.unlink(n)
+	}
+}
+
+// Len returns the number of items in the map.
+func (m *ShardedMap) Len() int {
+	m.rlockAll()
+	defer m.runlockAll()
+	return m.count()
+}
+
+func (m *ShardedMap) GetAll() ([]string, []interface{}) {
+	return m.Snapshot().GetAll()
+}
+
+// GetRange returns the items whose key is in [from, to), in insertion order.
+func (m *ShardedMap) GetRange(from, to string) ([]string, []interface{}) {
+	return m.Snapshot().GetRange(from, to)
+}
+
+// GetPrefix returns the items whose key starts with prefix, in insertion order.
+func (m *ShardedMap) GetPrefix(prefix string) ([]string, []interface{}) {
+	return m.Snapshot().GetPrefix(prefix)
+}
+
+// Snapshot returns a consistent view of the map. Shards are only locked while
+// their roots are collected.
+func (m *ShardedMap) Snapshot() *Snapshot {
+	m.rlockAll()
+	defer m.runlockAll()
+	return m.snapshot()
+}
+
+// Update runs fn inside a read-write transaction with every shard locked.
+func (m *ShardedMap) Update(fn func(tx *Tx) error) error {
+	m.lockAll()
+	defer m.unlockAll()
+
+	tx := &Tx{b: m, writable: true}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// View runs fn inside a read-only transaction with every shard read locked.
+func (m *ShardedMap) View(fn func(tx *Tx) error) error {
+	m.rlockAll()
+	defer m.runlockAll()
+
+	return fn(&Tx{b: m})
+}
+
+func (m *ShardedMap) shardFor(key string) *shard {
+	// FNV-1a, inlined to avoid allocating a hash.Hash per call.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &m.shards[h%uint32(len(m.shards))]
+}
+
+// Shards are always locked in the same order to avoid deadlocks.
+func (m *ShardedMap) lockAll() {
+	for i := range m.shards {
+		m.shards[i].mutex.Lock()
+	}
+}
+
+func (m *ShardedMap) unlockAll() {
+	for i := len(m.shards) - 1; i >= 0; i-- {
+		m.shards[i].mutex.Unlock()
+	}
+}
+
+func (m *ShardedMap) rlockAll() {
+	for i := range m.shards {
+		m.shards[i].mutex.RLock()
+	}
+}
+
+func (m *ShardedMap) runlockAll() {
+	for i := len(m.shards) - 1; i >= 0; i-- {
+		m.shards[i].mutex.RUnlock()
+	}
+}
+
+// The methods below implement backend. The caller holds the lock of the
+// shards involved.
+
+func (m *ShardedMap) lookup(key string) (*node, bool) {
+	return m.shardFor(key).lookup(key)
+}
+
+func (m *ShardedMap) set(key string, value interface{}) (interface{}, bool) {
+	sh := m.shardFor(key)
+	if old, ok := sh.update(key, value); ok {
+		return old, true
+	}
+	sh.link(&node{label: m.nextBackLabel(), key: key, value: value})
+	return nil, false
+}
+
+func (m *ShardedMap) link(n *node) {
+	m.shardFor(n.key).link(n)
+}
+
+func (m *ShardedMap) unlink(n *node) {
+	m.shardFor(n.key).unlink(n)
+}
+
+func (m *ShardedMap) count() int {
+	total := 0
+	for i := range m.shards {
+		total += m.shards[i].count()
+	}
+	return total
+}
+
+func (m *ShardedMap) positional() bool {
+	return true
+}
+
+// at finds the first and last items by comparing the ends of every shard;
+// other positions are found by merging shards up to index.
+func (m *ShardedMap) at(index int) *node {
+	var found *node
+	switch total := m.count(); {
+	case index < 0 || index >= total:
+		return nil
+	case index == 0:
+		for i := range m.shards {
+			if n := m.shards[i].at(0); n != nil && (found == nil || n.label < found.label) {
+				found = n
+			}
+		}
+	case index == total-1:
+		for i := range m.shards {
+			if n := m.shards[i].at(m.shards[i].count() - 1); n != nil && (found == nil || n.label > found.label) {
+				found = n
+			}
+		}
+	default:
+		m.walk(func(n *node) bool {
+			if index == 0 {
+				found = n
+				return false
+			}
+			index--
+			return true
+		})
+	}
+	return found
+}
+
+func (m *ShardedMap) labelBefore(n *node) string {
+	var prev *node
+	for i := range m.shards {
+		if p := m.shards[i].before(n.label); p != nil && (prev == nil || p.label > prev.label) {
+			prev = p
+		}
+	}
+	if prev == nil {
+		return m.nextFrontLabel()
+	}
+	return between(prev.label, n.label)
+}
+
+func (m *ShardedMap) labelAfter(n *node) string {
+	var next *node
+	for i := range m.shards {
+		if p := m.shards[i].after(n.label); p != nil && (next == nil || p.label < next.label) {
+			next = p
+		}
+	}
+	if next == nil {
+		return m.nextBackLabel()
+	}
+	return between(n.label, next.label)
+}
+
+// relabel orders the items of all shards by their labels and gives them
+// fresh labels in that order.
+func (m *ShardedMap) relabel() func() {
+	roots, back := m.roots(), m.back.Load()
+	old := make([]tree, len(m.shards))
+	for i := range m.shards {
+		old[i] = m.shards[i].tree
+		m.shards[i].tree = newTree()
+	}
+	next := relabel(m.front.Load()+1, func(fn func(n *node) bool) { walkMerged(roots, fn) }, m.link)
+	if next > back {
+		m.back.Store(next)
+	}
+	return func() {
+		for i := range m.shards {
+			m.shards[i].tree = old[i]
+		}
+		m.back.Store(back)
+	}
+}
+
+func (m *ShardedMap) nextFrontLabel() string {
+	return counterLabel(m.front.Add(^uint64(0)) + 1)
+}
+
+func (m *ShardedMap) nextBackLabel() string {
+	return counterLabel(m.back.Add(1) - 1)
+}
+
+func (m *ShardedMap) snapshot() *Snapshot {
+	return &Snapshot{roots: m.roots()}
+}
+
+// walk calls fn for every item in global label order until fn returns false.
+func (m *ShardedMap) walk(fn func(n *node) bool) {
+	walkMerged(m.roots(), fn)
+}
+
+func (m *ShardedMap) roots() []*node {
+	roots := make([]*node, len(m.shards))
+	for i := range m.shards {
+		roots[i] = m.shards[i].root
+	}
+	return roots
+}
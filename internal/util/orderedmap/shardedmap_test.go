@@ -0,0 +1,112 @@
+package orderedmap
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestShardedMap(t *testing.T) {
+	m := NewShardedMap(4)
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.Set("5", 50)
+	m.DeleteItem("7")
+
+	keys, values := m.GetAll()
+	if len(keys) != 99 || m.Len() != 99 {
+		t.Fatalf("Expected 99 items, Got: %d (Len %d)", len(keys), m.Len())
+	}
+	for i, key := range keys {
+		expected := i
+		if i >= 7 {
+			expected++
+		}
+		if key != strconv.Itoa(expected) {
+			t.Fatalf("Insertion order lost at %d. Expected: %d, Got: %v", i, expected, key)
+		}
+	}
+	if values[5] != 50 {
+		t.Errorf("Failed to update value for key '5'. Got: %v", values[5])
+	}
+
+	if keys, _ := m.GetPrefix("9"); !reflect.DeepEqual(keys, []string{"9", "90", "91", "92", "93", "94", "95", "96", "97", "98", "99"}) {
+		t.Errorf("GetPrefix mismatch. Got: %v", keys)
+	}
+
+	err := m.Update(func(tx *Tx) error {
+		if err := tx.MoveToFront("50"); err != nil {
+			return err
+		}
+		if err := tx.InsertAfter("50", "x", "x"); err != nil {
+			return err
+		}
+		return tx.InsertBefore("99", "y", "y")
+	})
+	if err != nil {
+		t.Fatalf("Update returned an error: %v", err)
+	}
+	keys, _ = m.GetAll()
+	if keys[0] != "50" || keys[1] != "x" || keys[len(keys)-2] != "y" || keys[len(keys)-1] != "99" {
+		t.Errorf("Unexpected order after positional updates: %v", keys)
+	}
+
+	_ = m.View(func(tx *Tx) error {
+		if key, _, _ := tx.GetAt(2); key != "0" {
+			t.Errorf("GetAt(2) mismatch. Got: %v", key)
+		}
+		if key, _, _ := tx.GetLast(); key != "99" {
+			t.Errorf("GetLast mismatch. Got: %v", key)
+		}
+		return nil
+	})
+
+	errAbort := errors.New("abort")
+	err = m.Update(func(tx *Tx) error {
+		_, _, _, _ = tx.PopFirst()
+		_ = tx.Set("z", "z")
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("Expected abort error, Got: %v", err)
+	}
+	if after, _ := m.GetAll(); !reflect.DeepEqual(after, keys) {
+		t.Errorf("Map changed after rollback")
+	}
+}
+
+func TestShardedMap_Concurrent(t *testing.T) {
+	m := NewShardedMap(8)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := strconv.Itoa(w) + ":" + strconv.Itoa(i)
+				m.Set(key, i)
+				if i%3 == 0 {
+					m.DeleteItem(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	keys, values := m.GetAll()
+	if len(keys) != 8*333 {
+		t.Fatalf("Expected %d items, Got: %d", 8*333, len(keys))
+	}
+	// Items written by the same goroutine keep their relative order.
+	last := map[byte]int{}
+	for i, key := range keys {
+		if v := values[i].(int); v < last[key[0]] {
+			t.Fatalf("Order of %s broken", key)
+		} else {
+			last[key[0]] = v
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package orderedmap
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSnapshotNotFound is returned for unknown, released or expired
+	// snapshot ids.
+	ErrSnapshotNotFound = errors.New("orderedmap: snapshot not found or expired")
+	// ErrSnapshotNotOwned is returned for a snapshot id used by another
+	// owner than the one that registered it.
+	ErrSnapshotNotOwned = errors.New("orderedmap: snapshot belongs to another namespace or client")
+)
+
+// Snapshot is a read-only view of a map at the moment it was taken. Because
+// the treaps of the maps are persistent, a snapshot only holds on to their
+// roots: taking one is cheap and reading it never blocks writers.
+type Snapshot struct {
+	roots  []*node
+	sorted bool
+}
+
+// Len returns the number of items in the snapshot.
+func (s *Snapshot) Len() int {
+	total := 0
+	for _, root := range s.roots {
+		total += size(root)
+	}
+	return total
+}
+
+// GetAll returns every item in map order.
+func (s *Snapshot) GetAll() ([]string, []interface{}) {
+	total := s.Len()
+	keys := make([]string, 0, total)
+	values := make([]interface{}, 0, total)
+	s.walk(func(n *node) bool {
+		keys = append(keys, n.key)
+		values = append(values, n.value)
+		return true
+	})
+	return keys, values
+}
+
+// GetPage returns up to limit items starting at the zero-based offset.
+func (s *Snapshot) GetPage(offset, limit int) ([]string, []interface{}) {
+	var keys []string
+	var values []interface{}
+	if offset < 0 || limit <= 0 {
+		return keys, values
+	}
+	collect := func(n *node) bool {
+		keys = append(keys, n.key)
+		values = append(values, n.value)
+		return len(keys) < limit
+	}
+	if len(s.roots) == 1 {
+		walkFrom(s.roots[0], offset, collect)
+		return keys, values
+	}
+	skip := offset
+	walkMerged(s.roots, func(n *node) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		return collect(n)
+	})
+	return keys, values
+}
+
+// GetRange returns the items whose key is in [from, to), in map order.
+// An empty to leaves the range unbounded.
+func (s *Snapshot) GetRange(from, to string) ([]string, []interface{}) {
+	var keys []string
+	var values []interface{}
+	collect := func(n *node) bool {
+		if n.key >= from && (to == "" || n.key < to) {
+			keys = append(keys, n.key)
+			values = append(values, n.value)
+		}
+		return true
+	}
+	if s.sorted {
+		// Labels are keys, so only the matching part of the tree is visited.
+		walkRange(s.roots[0], from, to, collect)
+	} else {
+		s.walk(collect)
+	}
+	return keys, values
+}
+
+// GetPrefix returns the items whose key starts with prefix, in map order.
+func (s *Snapshot) GetPrefix(prefix string) ([]string, []interface{}) {
+	return s.GetRange(prefix, prefixEnd(prefix))
+}
+
+func (s *Snapshot) walk(fn func(n *node) bool) {
+	if len(s.roots) == 1 {
+		walk(s.roots[0], fn)
+		return
+	}
+	walkMerged(s.roots, fn)
+}
+
+// SnapshotOwner is the namespace a registered snapshot was taken of and the
+// client that registered it. Only the same owner can use the snapshot.
+type SnapshotOwner struct {
+	Namespace string
+	Client    string
+}
+
+// SnapshotRegistry keeps snapshots alive between commands under random ids.
+// A snapshot expires when it has not been used for the registry's ttl.
+type SnapshotRegistry struct {
+	mutex   sync.Mutex
+	handles map[string]*handle
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+type handle struct {
+	snapshot *Snapshot
+	owner    SnapshotOwner
+	expires  time.Time
+}
+
+// NewSnapshotRegistry creates a registry whose snapshots expire after ttl.
+func NewSnapshotRegistry(ttl time.Duration) *SnapshotRegistry {
+	return &SnapshotRegistry{
+		handles: make(map[string]*handle),
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// Register stores snapshot for owner and returns its id.
+func (r *SnapshotRegistry) Register(snapshot *Snapshot, owner SnapshotOwner) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire()
+
+	id := newSnapshotID()
+	r.handles[id] = &handle{snapshot: snapshot, owner: owner, expires: r.now().Add(r.ttl)}
+	return id
+}
+
+// Get returns the snapshot registered under id by owner and extends its
+// lifetime.
+func (r *SnapshotRegistry) Get(id string, owner SnapshotOwner) (*Snapshot, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h, err := r.lookup(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	h.expires = r.now().Add(r.ttl)
+	return h.snapshot, nil
+}
+
+// Owner returns the owner of the snapshot registered under id.
+func (r *SnapshotRegistry) Owner(id string) (SnapshotOwner, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire()
+
+	h, ok := r.handles[id]
+	if !ok {
+		return SnapshotOwner{}, ErrSnapshotNotFound
+	}
+	return h.owner, nil
+}
+
+// Release drops the snapshot registered under id by owner.
+func (r *SnapshotRegistry) Release(id string, owner SnapshotOwner) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, err := r.lookup(id, owner); err != nil {
+		return err
+	}
+	delete(r.handles, id)
+	return nil
+}
+
+// lookup returns the live handle of id if it belongs to owner. The caller
+// must hold the mutex.
+func (r *SnapshotRegistry) lookup(id string, owner SnapshotOwner) (*handle, error) {
+	r.expire()
+	h, ok := r.handles[id]
+	if !ok {
+		return nil, ErrSnapshotNotFound
+	}
+	if h.owner != owner {
+		return nil, ErrSnapshotNotOwned
+	}
+	return h, nil
+}
+
+// Len returns the number of live snapshots.
+func (r *SnapshotRegistry) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire()
+	return len(r.handles)
+}
+
+// expire drops expired snapshots. The caller must hold the mutex.
+func (r *SnapshotRegistry) expire() {
+	now := r.now()
+	for id, h := range r.handles {
+		if !now.Before(h.expires) {
+			delete(r.handles, id)
+		}
+	}
+}
+
+func newSnapshotID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// walkMerged calls fn for the nodes of several treaps in global label order
+// until fn returns false.
+func walkMerged(roots []*node, fn func(n *node) bool) {
+	var h iteratorHeap
+	for _, root := range roots {
+		it := newIterator(root)
+		if it.peek() != nil {
+			h = append(h, it)
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		it := h[0]
+		if !fn(it.next()) {
+			return
+		}
+		if it.peek() == nil {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+}
+
+// iterator walks a treap in label order.
+type iterator struct {
+	stack []*node
+}
+
+func newIterator(root *node) *iterator {
+	it := &iterator{}
+	it.pushLeft(root)
+	return it
+}
+
+func (it *iterator) pushLeft(n *node) {
+	for ; n != nil; n = n.left {
+		it.stack = append(it.stack, n)
+	}
+}
+
+func (it *iterator) peek() *node {
+	if len(it.stack) == 0 {
+		return nil
+	}
+	return it.stack[len(it.stack)-1]
+}
+
+func (it *iterator) next() *node {
+	n := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	it.pushLeft(n.right)
+	return n
+}
+
+// iteratorHeap orders iterators by the label of their next node.
+type iteratorHeap []*iterator
+
+func (h iteratorHeap) Len() int           { return len(h) }
+func (h iteratorHeap) Less(i, j int) bool { return h[i].peek().label < h[j].peek().label }
+func (h iteratorHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *iteratorHeap) Push(x interface{}) { *h = append(*h, x.(*iterator)) }
+
+func (h *iteratorHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,112 @@
+package orderedmap
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	maps := []struct {
+		name string
+		m    Map
+	}{
+		{name: "OrderedMap", m: NewOrderedMap()},
+		{name: "SortedMap", m: NewSortedMap()},
+		{name: "ShardedMap", m: NewShardedMap(4)},
+	}
+	for _, tt := range maps {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				tt.m.Set(strconv.Itoa(i), i)
+			}
+			snapshot := tt.m.Snapshot()
+
+			// Changes made after the snapshot was taken are not visible.
+			tt.m.Set("3", 30)
+			tt.m.DeleteItem("4")
+			tt.m.Set("10", 10)
+			_ = tt.m.Update(func(tx *Tx) error {
+				_, _, _, err := tx.PopFirst()
+				return err
+			})
+
+			keys, values := snapshot.GetAll()
+			if !reflect.DeepEqual(keys, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}) {
+				t.Errorf("Snapshot keys changed. Got: %v", keys)
+			}
+			if values[3] != 3 || snapshot.Len() != 10 {
+				t.Errorf("Snapshot values changed. Got: %v", values)
+			}
+
+			keys, _ = snapshot.GetPage(4, 3)
+			if !reflect.DeepEqual(keys, []string{"4", "5", "6"}) {
+				t.Errorf("GetPage(4, 3) mismatch. Got: %v", keys)
+			}
+			keys, _ = snapshot.GetPage(8, 5)
+			if !reflect.DeepEqual(keys, []string{"8", "9"}) {
+				t.Errorf("GetPage(8, 5) mismatch. Got: %v", keys)
+			}
+			if keys, _ = snapshot.GetPrefix("1"); !reflect.DeepEqual(keys, []string{"1"}) {
+				t.Errorf("GetPrefix mismatch. Got: %v", keys)
+			}
+
+			keys, _ = tt.m.GetAll()
+			if len(keys) != 9 {
+				t.Errorf("Map mismatch. Got: %v", keys)
+			}
+		})
+	}
+}
+
+func TestSnapshotRegistry(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewSnapshotRegistry(time.Minute)
+	r.now = func() time.Time { return now }
+
+	snapshot := NewOrderedMap().Snapshot()
+	owner := SnapshotOwner{Namespace: "orders", Client: "a"}
+	id1 := r.Register(snapshot, owner)
+	id2 := r.Register(snapshot, owner)
+	if id1 == id2 {
+		t.Fatalf("Expected distinct ids, Got: %v", id1)
+	}
+
+	// Using a snapshot extends its lifetime.
+	now = now.Add(40 * time.Second)
+	if s, err := r.Get(id1, owner); err != nil || s != snapshot {
+		t.Errorf("Get returned: %v %v", s, err)
+	}
+	now = now.Add(40 * time.Second)
+	if _, err := r.Get(id2, owner); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Expected id2 to expire, Got: %v", err)
+	}
+	if _, err := r.Get(id1, owner); err != nil {
+		t.Errorf("Expected id1 to be alive, Got: %v", err)
+	}
+
+	// Other namespaces and clients cannot use or release it.
+	for _, other := range []SnapshotOwner{{Namespace: "users", Client: "a"}, {Namespace: "orders", Client: "b"}} {
+		if _, err := r.Get(id1, other); !errors.Is(err, ErrSnapshotNotOwned) {
+			t.Errorf("Expected ErrSnapshotNotOwned for %+v, Got: %v", other, err)
+		}
+		if err := r.Release(id1, other); !errors.Is(err, ErrSnapshotNotOwned) {
+			t.Errorf("Expected ErrSnapshotNotOwned for %+v, Got: %v", other, err)
+		}
+	}
+	if o, err := r.Owner(id1); err != nil || o != owner {
+		t.Errorf("Owner returned: %+v %v", o, err)
+	}
+
+	if err := r.Release(id1, owner); err != nil {
+		t.Errorf("Release returned an error: %v", err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("Expected no live snapshots, Got: %d", r.Len())
+	}
+	if err := r.Release(id1, owner); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Expected ErrSnapshotNotFound, Got: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package orderedmap
+
+// SortedMap is a map that keeps its items in lexical key order. It shares
+// the treap of OrderedMap, using keys as labels, so range and prefix scans
+// only visit the matching items.
+type SortedMap struct {
+	store
+}
+
+var _ Map = (*SortedMap)(nil)
+
+func NewSortedMap() *SortedMap {
+	return &SortedMap{store: newStore(true)}
+}
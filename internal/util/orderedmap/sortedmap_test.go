@@ -0,0 +1,60 @@
+package orderedmap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSortedMap(t *testing.T) {
+	sm := NewSortedMap()
+	for _, key := range []string{"user:3", "b", "user:1", "a", "user:2", "order:1"} {
+		sm.Set(key, key)
+	}
+
+	expectedKeys := []string{"a", "b", "order:1", "user:1", "user:2", "user:3"}
+	if keys := sm.keys(); !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Keys mismatch. Expected: %v, Got: %v", expectedKeys, keys)
+	}
+
+	if keys, _ := sm.GetRange("b", "user:2"); !reflect.DeepEqual(keys, []string{"b", "order:1", "user:1"}) {
+		t.Errorf("GetRange mismatch. Got: %v", keys)
+	}
+	if keys, _ := sm.GetRange("user:", ""); !reflect.DeepEqual(keys, []string{"user:1", "user:2", "user:3"}) {
+		t.Errorf("Unbounded GetRange mismatch. Got: %v", keys)
+	}
+	if keys, values := sm.GetPrefix("user:"); !reflect.DeepEqual(keys, []string{"user:1", "user:2", "user:3"}) ||
+		!reflect.DeepEqual(values, []interface{}{"user:1", "user:2", "user:3"}) {
+		t.Errorf("GetPrefix mismatch. Got: %v %v", keys, values)
+	}
+
+	if key, _, _ := sm.GetAt(2); key != "order:1" {
+		t.Errorf("GetAt(2) mismatch. Got: %v", key)
+	}
+	if key, _, _ := sm.PopLast(); key != "user:3" {
+		t.Errorf("PopLast mismatch. Got: %v", key)
+	}
+
+	// Items cannot be placed at a chosen position.
+	err := sm.Update(func(tx *Tx) error {
+		return tx.InsertAfter("a", "z", "z")
+	})
+	if !errors.Is(err, ErrNotPositional) {
+		t.Errorf("Expected ErrNotPositional, Got: %v", err)
+	}
+}
+
+func TestOrderedMap_GetPrefix(t *testing.T) {
+	om := NewOrderedMap()
+	for _, key := range []string{"user:3", "b", "user:1", "a"} {
+		om.Set(key, key)
+	}
+
+	// Insertion order maps return matches in insertion order.
+	if keys, _ := om.GetPrefix("user:"); !reflect.DeepEqual(keys, []string{"user:3", "user:1"}) {
+		t.Errorf("GetPrefix mismatch. Got: %v", keys)
+	}
+	if keys, _ := om.GetRange("a", "c"); !reflect.DeepEqual(keys, []string{"b", "a"}) {
+		t.Errorf("GetRange mismatch. Got: %v", keys)
+	}
+}
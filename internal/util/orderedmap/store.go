@@ -0,0 +1,230 @@
+package orderedmap
+
+import (
+	"sync"
+)
+
+// store holds the items of a map in a treap. The order of the items is given
+// by their labels: in insertion order maps labels come from counters, in
+// sorted maps the label of an item is its key.
+type store struct {
+	tree
+	sorted bool
+	front  uint64       // label counter for prepended items, counts down
+	back   uint64       // label counter for appended items, counts up
+	mutex  sync.RWMutex // Mutex for concurrent access
+}
+
+func newStore(sorted bool) store {
+	return store{
+		tree:   newTree(),
+		sorted: sorted,
+		front:  labelOrigin - 1,
+		back:   labelOrigin,
+		mutex:  sync.RWMutex{},
+	}
+}
+
+// Set stores value under key. Updating an existing key keeps its position.
+func (s *store) Set(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.set(key, value)
+}
+
+func (s *store) Get(key string) (interface{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if n, ok := s.values[key]; ok {
+		return n.value, true
+	}
+	return nil, false
+}
+
+func (s *store) DeleteItem(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n, ok := s.lookup(key); ok {
+		s.unlink(n)
+	}
+}
+
+// Len returns the number of items in the map.
+func (s *store) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.values)
+}
+
+// GetFirst returns the first item of the map.
+func (s *store) GetFirst() (string, interface{}, bool) {
+	return s.GetAt(0)
+}
+
+// GetLast returns the last item of the map.
+func (s *store) GetLast() (string, interface{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return item(s.at(s.count() - 1))
+}
+
+// GetAt returns the item at the given zero-based position.
+func (s *store) GetAt(index int) (string, interface{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return item(s.at(index))
+}
+
+// PopFirst removes and returns the first item of the map.
+func (s *store) PopFirst() (key string, value interface{}, ok bool) {
+	_ = s.Update(func(tx *Tx) error {
+		key, value, ok, _ = tx.PopFirst()
+		return nil
+	})
+	return key, value, ok
+}
+
+// PopLast removes and returns the last item of the map.
+func (s *store) PopLast() (key string, value interface{}, ok bool) {
+	_ = s.Update(func(tx *Tx) error {
+		key, value, ok, _ = tx.PopLast()
+		return nil
+	})
+	return key, value, ok
+}
+
+func (s *store) keys() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]string, 0, len(s.values))
+	walk(s.root, func(n *node) bool {
+		keys = append(keys, n.key)
+		return true
+	})
+	return keys
+}
+
+// GetAll returns every item in map order. It reads a snapshot, so writers
+// are not blocked while the items are collected.
+func (s *store) GetAll() ([]string, []interface{}) {
+	return s.Snapshot().GetAll()
+}
+
+// GetRange returns the items whose key is in [from, to), in map order.
+// An empty to leaves the range unbounded.
+func (s *store) GetRange(from, to string) ([]string, []interface{}) {
+	return s.Snapshot().GetRange(from, to)
+}
+
+// GetPrefix returns the items whose key starts with prefix, in map order.
+func (s *store) GetPrefix(prefix string) ([]string, []interface{}) {
+	return s.Snapshot().GetPrefix(prefix)
+}
+
+// Snapshot returns a view of the map as it is now. Taking a snapshot is O(1).
+func (s *store) Snapshot() *Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.snapshot()
+}
+
+// Update runs fn inside a read-write transaction. All changes made through
+// the transaction are applied atomically: if fn returns an error, every
+// change is rolled back and the error is returned.
+func (s *store) Update(fn func(tx *Tx) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tx := &Tx{b: s, writable: true}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// View runs fn inside a read-only transaction.
+func (s *store) View(fn func(tx *Tx) error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return fn(&Tx{b: s})
+}
+
+// set stores value under key and returns the value it replaced, if any.
+// The caller must hold the write lock.
+func (s *store) set(key string, value interface{}) (interface{}, bool) {
+	if old, ok := s.update(key, value); ok {
+		return old, true
+	}
+	label := key
+	if !s.sorted {
+		label = s.nextBackLabel()
+	}
+	s.link(&node{label: label, key: key, value: value})
+	return nil, false
+}
+
+func (s *store) positional() bool {
+	return !s.sorted
+}
+
+func (s *store) nextFrontLabel() string {
+	label := counterLabel(s.front)
+	s.front--
+	return label
+}
+
+func (s *store) nextBackLabel() string {
+	label := counterLabel(s.back)
+	s.back++
+	return label
+}
+
+// labelBefore returns a label that sorts right before n.
+func (s *store) labelBefore(n *node) string {
+	prev := s.before(n.label)
+	if prev == nil {
+		return s.nextFrontLabel()
+	}
+	return between(prev.label, n.label)
+}
+
+// labelAfter returns a label that sorts right after n.
+func (s *store) labelAfter(n *node) string {
+	next := s.after(n.label)
+	if next == nil {
+		return s.nextBackLabel()
+	}
+	return between(n.label, next.label)
+}
+
+func (s *store) relabel() func() {
+	old, back := s.tree, s.back
+	s.tree = newTree()
+	next := relabel(s.front+1, func(fn func(n *node) bool) { walk(old.root, fn) }, s.tree.link)
+	if next > s.back {
+		s.back = next
+	}
+	return func() { s.tree, s.back = old, back }
+}
+
+func (s *store) snapshot() *Snapshot {
+	return &Snapshot{roots: []*node{s.root}, sorted: s.sorted}
+}
+
+// prefixEnd returns the smallest string greater than every string starting
+// with prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
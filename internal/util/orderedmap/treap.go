@@ -0,0 +1,314 @@
+package orderedmap
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// node is an entry of a treap ordered by label. Every node also tracks the
+// size of its subtree, which turns the treap into an order-statistic tree:
+// finding the position of a label or the label at a position takes
+// O(log n) expected time.
+//
+// The treap is persistent: nodes are never modified once they are part of a
+// tree. Updates copy the O(log n) nodes on the path they change and share
+// the rest, so holding on to an old root gives a consistent snapshot.
+type node struct {
+	label    string
+	key      string
+	value    interface{}
+	priority uint32
+	size     int
+	left     *node
+	right    *node
+}
+
+func newNode(label, key string, value interface{}) *node {
+	return &node{
+		label:    label,
+		key:      key,
+		value:    value,
+		priority: rand.Uint32(),
+		size:     1,
+	}
+}
+
+func size(n *node) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// with returns a copy of n with the given children.
+func (n *node) with(left, right *node) *node {
+	c := *n
+	c.left, c.right = left, right
+	c.size = 1 + size(left) + size(right)
+	return &c
+}
+
+// split splits t into the nodes whose label is less than label and the rest.
+func split(t *node, label string) (*node, *node) {
+	if t == nil {
+		return nil, nil
+	}
+	if t.label < label {
+		l, r := split(t.right, label)
+		return t.with(t.left, l), r
+	}
+	l, r := split(t.left, label)
+	return l, t.with(r, t.right)
+}
+
+// merge joins a and b, where every label in a is less than every label in b.
+func merge(a, b *node) *node {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		return a.with(a.left, merge(a.right, b))
+	}
+	return b.with(merge(a, b.left), b.right)
+}
+
+// insert adds n to t and returns the new root. n.label must not be in t.
+func insert(t, n *node) *node {
+	l, r := split(t, n.label)
+	return merge(merge(l, n), r)
+}
+
+// remove removes the node with the given label from t and returns the new root.
+func remove(t *node, label string) *node {
+	l, r := split(t, label)
+	_, r = split(r, label+"\x00")
+	return merge(l, r)
+}
+
+// replace returns t with the value of the node with the given label replaced.
+func replace(t *node, label string, value interface{}) *node {
+	if t == nil {
+		return nil
+	}
+	switch {
+	case label < t.label:
+		return t.with(replace(t.left, label, value), t.right)
+	case label > t.label:
+		return t.with(t.left, replace(t.right, label, value))
+	}
+	c := t.with(t.left, t.right)
+	c.value = value
+	return c
+}
+
+// rank returns the number of nodes in t whose label is less than label.
+func rank(t *node, label string) int {
+	r := 0
+	for t != nil {
+		if t.label < label {
+			r += size(t.left) + 1
+			t = t.right
+		} else {
+			t = t.left
+		}
+	}
+	return r
+}
+
+// at returns the node at position i of t, or nil if i is out of range.
+func at(t *node, i int) *node {
+	if i < 0 || i >= size(t) {
+		return nil
+	}
+	for t != nil {
+		switch left := size(t.left); {
+		case i < left:
+			t = t.left
+		case i == left:
+			return t
+		default:
+			i -= left + 1
+			t = t.right
+		}
+	}
+	return nil
+}
+
+// tree is a treap whose items are also indexed by key. It does no locking.
+type tree struct {
+	root   *node
+	values map[string]entry
+}
+
+// entry is the index record of an item.
+type entry struct {
+	label string
+	value interface{}
+}
+
+func newTree() tree {
+	return tree{values: make(map[string]entry)}
+}
+
+// lookup returns a detached node describing the item stored under key.
+func (t *tree) lookup(key string) (*node, bool) {
+	e, ok := t.values[key]
+	if !ok {
+		return nil, false
+	}
+	return &node{label: e.label, key: key, value: e.value}, true
+}
+
+// link adds an item with the label, key and value of n.
+func (t *tree) link(n *node) {
+	t.root = insert(t.root, newNode(n.label, n.key, n.value))
+	t.values[n.key] = entry{label: n.label, value: n.value}
+}
+
+// unlink removes the item described by n. n keeps its label so that it can
+// be linked back in place.
+func (t *tree) unlink(n *node) {
+	t.root = remove(t.root, n.label)
+	delete(t.values, n.key)
+}
+
+// update replaces the value of an existing item and returns the old value.
+func (t *tree) update(key string, value interface{}) (interface{}, bool) {
+	e, ok := t.values[key]
+	if !ok {
+		return nil, false
+	}
+	t.root = replace(t.root, e.label, value)
+	t.values[key] = entry{label: e.label, value: value}
+	return e.value, true
+}
+
+func (t *tree) at(index int) *node {
+	return at(t.root, index)
+}
+
+func (t *tree) count() int {
+	return len(t.values)
+}
+
+// before returns the node with the greatest label less than label.
+func (t *tree) before(label string) *node {
+	return at(t.root, rank(t.root, label)-1)
+}
+
+// after returns the node with the smallest label greater than label.
+func (t *tree) after(label string) *node {
+	return at(t.root, rank(t.root, label+"\x00"))
+}
+
+// walk calls fn for every node of t in label order until fn returns false.
+func walk(t *node, fn func(n *node) bool) bool {
+	if t == nil {
+		return true
+	}
+	return walk(t.left, fn) && fn(t) && walk(t.right, fn)
+}
+
+// walkFrom calls fn in label order for the nodes of t from position i on
+// until fn returns false.
+func walkFrom(t *node, i int, fn func(n *node) bool) bool {
+	if t == nil {
+		return true
+	}
+	switch left := size(t.left); {
+	case i < left:
+		return walkFrom(t.left, i, fn) && fn(t) && walk(t.right, fn)
+	case i == left:
+		return fn(t) && walk(t.right, fn)
+	default:
+		return walkFrom(t.right, i-left-1, fn)
+	}
+}
+
+// walkRange calls fn in label order for the nodes of t whose label is in
+// [from, to) until fn returns false. An empty to leaves the range unbounded.
+func walkRange(t *node, from, to string, fn func(n *node) bool) bool {
+	if t == nil {
+		return true
+	}
+	if t.label < from {
+		return walkRange(t.right, from, to, fn)
+	}
+	if to != "" && t.label >= to {
+		return walkRange(t.left, from, to, fn)
+	}
+	return walkRange(t.left, from, to, fn) && fn(t) && walkRange(t.right, from, to, fn)
+}
+
+// Labels order the entries of an OrderedMap. Items appended or prepended get
+// fixed-width hexadecimal labels from two counters that move away from each
+// other, so a new label is always greater (or less) than every existing one.
+// Items inserted next to an existing item get a label between the labels of
+// their neighbours, which is always possible because labels never end in a
+// zero byte. Repeated inserts at one position lengthen those labels, so
+// once a label would exceed maxLabelLength the map is relabeled with counter
+// labels relabelSpacing apart.
+const (
+	labelOrigin    = uint64(1) << 63
+	maxLabelLength = 64
+	relabelSpacing = uint64(1) << 16
+)
+
+func counterLabel(n uint64) string {
+	return fmt.Sprintf("%016x", n)
+}
+
+// relabel links a copy of every node walk visits with a fresh counter
+// label, in order, starting at first. It returns the counter after the last
+// label.
+func relabel(first uint64, walk func(fn func(n *node) bool), link func(n *node)) uint64 {
+	next := first
+	walk(func(n *node) bool {
+		link(&node{label: counterLabel(next), key: n.key, value: n.value})
+		next += relabelSpacing
+		return true
+	})
+	return next
+}
+
+// between returns a label strictly between a and b, where a < b.
+func between(a, b string) string {
+	n := 0
+	for n < len(b) && digit(a, n) == b[n] {
+		n++
+	}
+	da, db := int(digit(a, n)), int(b[n])
+	if db-da > 1 {
+		return b[:n] + string([]byte{byte((da + db) / 2)})
+	}
+	if n+1 < len(b) {
+		// b[:n+1] is a proper prefix of b and greater than a at position n.
+		return b[:n+1]
+	}
+	tail := ""
+	if n+1 < len(a) {
+		tail = a[n+1:]
+	}
+	return b[:n] + string([]byte{byte(da)}) + above(tail)
+}
+
+// above returns a short label greater than s that does not end in a zero byte.
+func above(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0xff {
+			return s[:i] + string([]byte{byte((int(s[i]) + 0x100) / 2)})
+		}
+	}
+	return s + "\x80"
+}
+
+// digit returns the i-th byte of s, treating s as padded with zero bytes.
+func digit(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return 0
+}
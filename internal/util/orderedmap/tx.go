@@ -0,0 +1,250 @@
+package orderedmap
+
+import "errors"
+
+// ErrTxReadOnly is returned when a write is attempted in a read-only transaction.
+var ErrTxReadOnly = errors.New("orderedmap: write in read-only transaction")
+
+// backend is the storage of a map as seen by a Tx. Its methods are only
+// called while the map's locks are held.
+type backend interface {
+	lookup(key string) (*node, bool)
+	// set stores value under key and returns the value it replaced, if any.
+	set(key string, value interface{}) (interface{}, bool)
+	link(n *node)
+	unlink(n *node)
+	at(index int) *node
+	count() int
+	// positional reports whether items can be placed at a chosen position.
+	positional() bool
+	labelBefore(n *node) string
+	labelAfter(n *node) string
+	// relabel gives every item a fresh label in the same order and returns
+	// a function that restores the previous labels.
+	relabel() (restore func())
+	nextFrontLabel() string
+	nextBackLabel() string
+	snapshot() *Snapshot
+}
+
+// ChangeOp is the kind of a Change.
+type ChangeOp string
+
+const (
+	ChangeSet    ChangeOp = "set"
+	ChangeDelete ChangeOp = "delete"
+	ChangeMove   ChangeOp = "move"
+)
+
+// Change describes one modification made through a Tx. Old is nil for new
+// keys and New is nil for deleted keys.
+type Change struct {
+	Op  ChangeOp
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+// Tx gives access to a map while its lock is held by Update or View.
+// A Tx must not be used after the callback it was passed to returns.
+type Tx struct {
+	b        backend
+	writable bool
+	undo     []func()
+	changes  []Change
+}
+
+// Get returns the value stored under key.
+func (tx *Tx) Get(key string) (interface{}, bool) {
+	if n, ok := tx.b.lookup(key); ok {
+		return n.value, true
+	}
+	return nil, false
+}
+
+// Set stores value under key. Updating an existing key keeps its position.
+func (tx *Tx) Set(key string, value interface{}) error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	old, replaced := tx.b.set(key, value)
+	tx.changes = append(tx.changes, Change{Op: ChangeSet, Key: key, Old: old, New: value})
+	if replaced {
+		tx.undo = append(tx.undo, func() { tx.b.set(key, old) })
+	} else {
+		tx.undo = append(tx.undo, func() {
+			n, _ := tx.b.lookup(key)
+			tx.b.unlink(n)
+		})
+	}
+	return nil
+}
+
+// Delete removes key and reports whether it was present.
+func (tx *Tx) Delete(key string) (bool, error) {
+	if !tx.writable {
+		return false, ErrTxReadOnly
+	}
+	n, ok := tx.b.lookup(key)
+	if !ok {
+		return false, nil
+	}
+	tx.remove(n)
+	return true, nil
+}
+
+// InsertBefore inserts key right before the existing key.
+func (tx *Tx) InsertBefore(existing, key string, value interface{}) error {
+	return tx.insertNextTo(existing, key, value, tx.b.labelBefore)
+}
+
+// InsertAfter inserts key right after the existing key.
+func (tx *Tx) InsertAfter(existing, key string, value interface{}) error {
+	return tx.insertNextTo(existing, key, value, tx.b.labelAfter)
+}
+
+// MoveToFront moves key to the first position.
+func (tx *Tx) MoveToFront(key string) error {
+	return tx.move(key, tx.b.nextFrontLabel)
+}
+
+// MoveToBack moves key to the last position.
+func (tx *Tx) MoveToBack(key string) error {
+	return tx.move(key, tx.b.nextBackLabel)
+}
+
+// GetAt returns the item at the given zero-based position.
+func (tx *Tx) GetAt(index int) (string, interface{}, bool) {
+	return item(tx.b.at(index))
+}
+
+// GetFirst returns the first item of the map.
+func (tx *Tx) GetFirst() (string, interface{}, bool) {
+	return item(tx.b.at(0))
+}
+
+// GetLast returns the last item of the map.
+func (tx *Tx) GetLast() (string, interface{}, bool) {
+	return item(tx.b.at(tx.b.count() - 1))
+}
+
+// PopFirst removes and returns the first item of the map.
+func (tx *Tx) PopFirst() (string, interface{}, bool, error) {
+	return tx.popAt(0)
+}
+
+// PopLast removes and returns the last item of the map.
+func (tx *Tx) PopLast() (string, interface{}, bool, error) {
+	return tx.popAt(tx.b.count() - 1)
+}
+
+// Len returns the number of items in the map.
+func (tx *Tx) Len() int {
+	return tx.b.count()
+}
+
+// GetAll returns all keys and values in map order.
+func (tx *Tx) GetAll() ([]string, []interface{}) {
+	return tx.b.snapshot().GetAll()
+}
+
+// GetRange returns the items whose key is in [from, to), in map order.
+func (tx *Tx) GetRange(from, to string) ([]string, []interface{}) {
+	return tx.b.snapshot().GetRange(from, to)
+}
+
+// GetPrefix returns the items whose key starts with prefix, in map order.
+func (tx *Tx) GetPrefix(prefix string) ([]string, []interface{}) {
+	return tx.b.snapshot().GetPrefix(prefix)
+}
+
+// Changes returns the modifications made through the transaction so far,
+// in order.
+func (tx *Tx) Changes() []Change {
+	return tx.changes
+}
+
+func (tx *Tx) insertNextTo(existing, key string, value interface{}, labelNextTo func(*node) string) error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	if !tx.b.positional() {
+		return ErrNotPositional
+	}
+	pivot, ok := tx.b.lookup(existing)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if _, ok := tx.b.lookup(key); ok {
+		return ErrKeyExists
+	}
+	label := labelNextTo(pivot)
+	if len(label) > maxLabelLength {
+		tx.undo = append(tx.undo, tx.b.relabel())
+		pivot, _ = tx.b.lookup(existing)
+		label = labelNextTo(pivot)
+	}
+	n := &node{label: label, key: key, value: value}
+	tx.b.link(n)
+	tx.changes = append(tx.changes, Change{Op: ChangeSet, Key: key, New: value})
+	tx.undo = append(tx.undo, func() { tx.b.unlink(n) })
+	return nil
+}
+
+func (tx *Tx) move(key string, label func() string) error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	if !tx.b.positional() {
+		return ErrNotPositional
+	}
+	n, ok := tx.b.lookup(key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	old := n.label
+	tx.b.unlink(n)
+	n.label = label()
+	tx.b.link(n)
+	tx.changes = append(tx.changes, Change{Op: ChangeMove, Key: key, Old: n.value, New: n.value})
+	tx.undo = append(tx.undo, func() {
+		tx.b.unlink(n)
+		n.label = old
+		tx.b.link(n)
+	})
+	return nil
+}
+
+func (tx *Tx) popAt(index int) (string, interface{}, bool, error) {
+	if !tx.writable {
+		return "", nil, false, ErrTxReadOnly
+	}
+	n := tx.b.at(index)
+	if n == nil {
+		return "", nil, false, nil
+	}
+	tx.remove(n)
+	return n.key, n.value, true, nil
+}
+
+func (tx *Tx) remove(n *node) {
+	tx.b.unlink(n)
+	tx.changes = append(tx.changes, Change{Op: ChangeDelete, Key: n.key, Old: n.value})
+	// n keeps its label, so linking it back restores its position.
+	tx.undo = append(tx.undo, func() { tx.b.link(n) })
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+	tx.changes = nil
+}
+
+func item(n *node) (string, interface{}, bool) {
+	if n == nil {
+		return "", nil, false
+	}
+	return n.key, n.value, true
+}
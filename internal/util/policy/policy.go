@@ -0,0 +1,222 @@
+// Package policy decides which clients may run which commands on which keys.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrDenied is returned for a request that the policy does not allow.
+var ErrDenied = errors.New("denied by policy")
+
+// Admin is the permission to act on what other clients own, such as
+// closing their snapshots. A rule grants it by naming it in its commands;
+// "*" and the default effect never do.
+const Admin = "admin"
+
+// Effect is the decision of a rule.
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Rule matches requests by client, command, namespace and key. Clients,
+// namespaces and keys are patterns in which "*" matches any sequence of
+// characters, so "teamA:*" matches every key under "teamA:". The default
+// namespace is "". An empty list matches everything; a rule with keys only
+// matches requests that name keys.
+type Rule struct {
+	Clients    []string `json:"clients,omitempty"`
+	Commands   []string `json:"commands,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	Keys       []string `json:"keys,omitempty"`
+	Effect     Effect   `json:"effect,omitempty"`
+}
+
+// File is the content of a policy file. The first rule matching a request
+// decides; requests that no rule matches get Default, which is deny unless
+// set.
+type File struct {
+	Default Effect `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Request is one command of one client. Namespace is the namespace the
+// command applies to. Keys holds the keys the command reads or writes; it
+// is empty for commands on the whole map.
+type Request struct {
+	Client    string
+	Command   string
+	Namespace string
+	Keys      []string
+}
+
+// Policy holds the rules of a policy file. It can be reloaded while in use.
+type Policy struct {
+	path     string
+	validate func(command string) bool
+	file     atomic.Pointer[File]
+}
+
+// Load reads the policy file at path. validate, if not nil, reports
+// whether a command name in a rule exists, so that typos are caught.
+func Load(path string, validate func(command string) bool) (*Policy, error) {
+	p := &Policy{path: path, validate: validate}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// New returns a policy with the rules of f.
+func New(f File) (*Policy, error) {
+	p := &Policy{}
+	return p, p.set(f)
+}
+
+// Reload reads the policy file again. The rules in use are kept if the file
+// is invalid.
+func (p *Policy) Reload() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("policy file %s: %w", p.path, err)
+	}
+	return p.set(f)
+}
+
+func (p *Policy) set(f File) error {
+	if f.Default == "" {
+		f.Default = Deny
+	}
+	if f.Default != Allow && f.Default != Deny {
+		return fmt.Errorf("invalid default effect %q", f.Default)
+	}
+	for i := range f.Rules {
+		r := &f.Rules[i]
+		if r.Effect == "" {
+			r.Effect = Allow
+		}
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %d: invalid effect %q", i+1, r.Effect)
+		}
+		for _, command := range r.Commands {
+			if command != "*" && command != Admin && p.validate != nil && !p.validate(command) {
+				return fmt.Errorf("rule %d: unknown command %q", i+1, command)
+			}
+		}
+	}
+	p.file.Store(&f)
+	return nil
+}
+
+// Authorize returns nil if the policy allows req, and an error wrapping
+// ErrDenied otherwise.
+func (p *Policy) Authorize(req Request) error {
+	f := p.file.Load()
+	for i, r := range f.Rules {
+		if r.matches(req) {
+			if r.Effect == Deny {
+				return fmt.Errorf("%w: rule %d denies %s to %q", ErrDenied, i+1, req.Command, req.Client)
+			}
+			return nil
+		}
+	}
+	if f.Default == Deny {
+		return fmt.Errorf("%w: no rule allows %s %v to %q", ErrDenied, req.Command, req.Keys, req.Client)
+	}
+	return nil
+}
+
+// IsAdmin reports whether the policy grants client the Admin permission:
+// the first rule that names Admin and matches client must allow it.
+func (p *Policy) IsAdmin(client string) bool {
+	for _, r := range p.file.Load().Rules {
+		if !matchAny(r.Clients, client) {
+			continue
+		}
+		for _, command := range r.Commands {
+			if command == Admin {
+				return r.Effect == Allow
+			}
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(req Request) bool {
+	if !matchAny(r.Clients, req.Client) {
+		return false
+	}
+	if len(r.Commands) > 0 && !contains(r.Commands, req.Command) {
+		return false
+	}
+	if !matchAny(r.Namespaces, req.Namespace) {
+		return false
+	}
+	if len(r.Keys) == 0 {
+		return true
+	}
+	if len(req.Keys) == 0 {
+		return false
+	}
+	for _, key := range req.Keys {
+		if !matchAny(r.Keys, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether s matches one of patterns, or patterns is empty.
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether s matches pattern, in which "*" matches any
+// sequence of characters.
+func match(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s || v == "*" {
+			return true
+		}
+	}
+	return false
+}
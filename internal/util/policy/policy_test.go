@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	p, err := New(File{Rules: []Rule{
+		{Clients: []string{"reporting"}, Commands: []string{"getItem", "getAllItems"}},
+		{Clients: []string{"teamA"}, Keys: []string{"teamA:*"}},
+		{Clients: []string{"teamB"}, Commands: []string{"deleteItem"}, Effect: Deny},
+		{Clients: []string{"team*"}, Commands: []string{"getItem"}},
+		{Clients: []string{"billing"}, Namespaces: []string{"billing-*"}},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		request Request
+		allowed bool
+	}{
+		{name: "Reporting reads", request: Request{Client: "reporting", Command: "getAllItems"}, allowed: true},
+		{name: "Reporting writes", request: Request{Client: "reporting", Command: "addItem", Keys: []string{"a"}}, allowed: false},
+		{name: "Team writes own key", request: Request{Client: "teamA", Command: "addItem", Keys: []string{"teamA:x/y"}}, allowed: true},
+		{name: "Team writes other key", request: Request{Client: "teamA", Command: "addItem", Keys: []string{"teamB:x"}}, allowed: false},
+		{name: "Team moves across teams", request: Request{Client: "teamA", Command: "insertBefore", Keys: []string{"teamA:x", "teamB:y"}}, allowed: false},
+		{name: "Team scans", request: Request{Client: "teamA", Command: "getAllItems"}, allowed: false},
+		{name: "Deny rule first", request: Request{Client: "teamB", Command: "deleteItem", Keys: []string{"a"}}, allowed: false},
+		{name: "Wildcard client", request: Request{Client: "teamB", Command: "getItem", Keys: []string{"a"}}, allowed: true},
+		{name: "Anonymous", request: Request{Command: "getItem", Keys: []string{"a"}}, allowed: false},
+		{name: "Own namespace", request: Request{Client: "billing", Command: "dropNamespace", Namespace: "billing-eu"}, allowed: true},
+		{name: "Default namespace", request: Request{Client: "billing", Command: "addItem", Keys: []string{"a"}}, allowed: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := p.Authorize(test.request)
+			if test.allowed && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+			if !test.allowed && !errors.Is(err, ErrDenied) {
+				t.Errorf("Expected ErrDenied but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	p, err := New(File{Default: Allow, Rules: []Rule{
+		{Clients: []string{"ops-intern"}, Commands: []string{Admin}, Effect: Deny},
+		{Clients: []string{"ops-*"}, Commands: []string{Admin}},
+		{Clients: []string{"teamA"}, Commands: []string{"*"}},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	for client, admin := range map[string]bool{"ops-alice": true, "ops-intern": false, "teamA": false, "other": false} {
+		if got := p.IsAdmin(client); got != admin {
+			t.Errorf("Expected IsAdmin(%q) to be %v but got %v", client, admin, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"default": "allow", "rules": [{"commands": ["deleteItem"], "effect": "deny"}]}`), 0o600)
+	valid := func(command string) bool { return command == "deleteItem" || command == "addItem" }
+
+	p, err := Load(path, valid)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if err := p.Authorize(Request{Command: "addItem", Keys: []string{"a"}}); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+	if err := p.Authorize(Request{Command: "deleteItem", Keys: []string{"a"}}); !errors.Is(err, ErrDenied) {
+		t.Errorf("Expected ErrDenied but got: %v", err)
+	}
+
+	// An invalid file keeps the loaded rules.
+	os.WriteFile(path, []byte(`{"rules": [{"commands": ["delItem"]}]}`), 0o600)
+	if err := p.Reload(); err == nil {
+		t.Errorf("Expected error for an unknown command")
+	}
+	if err := p.Authorize(Request{Command: "addItem", Keys: []string{"a"}}); err != nil {
+		t.Errorf("Expected the old rules to be kept but got: %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"teamA:*", "teamA:", true},
+		{"teamA:*", "teamB:x", false},
+		{"*", "", true},
+		{"a*b*c", "axbyc", true},
+		{"a*b*c", "axbyd", false},
+		{"*a*a", "xa", false},
+		{"exact", "exact", true},
+	}
+	for _, test := range tests {
+		if match(test.pattern, test.s) != test.expected {
+			t.Errorf("Expected match(%q, %q) to be %v", test.pattern, test.s, test.expected)
+		}
+	}
+}
@@ -36,10 +36,26 @@ func NewSQSQueue(region, queueURL string, bufferLength int64) (*SQSQueue, error)
 
 // SendMessage sends a message to the AWS SQS queue.
 func (s *SQSQueue) SendMessage(message string) error {
-	_, err := s.svc.SendMessage(&sqs.SendMessageInput{
+	return s.SendMessageWithHeaders(message, nil)
+}
+
+// SendMessageWithHeaders sends a message to the AWS SQS queue with the
+// headers as string message attributes.
+func (s *SQSQueue) SendMessageWithHeaders(message string, headers map[string]string) error {
+	input := &sqs.SendMessageInput{
 		MessageBody: aws.String(message),
 		QueueUrl:    aws.String(s.queueURL),
-	})
+	}
+	if len(headers) > 0 {
+		input.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(headers))
+		for key, value := range headers {
+			input.MessageAttributes[key] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+		}
+	}
+	_, err := s.svc.SendMessage(input)
 	return err
 }
 
@@ -65,6 +81,40 @@ func (s *SQSQueue) ReceiveMessage() (<-chan string, error) {
 	return messageChannel, nil
 }
 
+// ReceiveMessages receives a channel of messages with their string message
+// attributes as headers.
+func (s *SQSQueue) ReceiveMessages() (<-chan Message, error) {
+	messageChannel := make(chan Message, s.bufferLength)
+	go func() {
+		for {
+			receiveParams := &sqs.ReceiveMessageInput{
+				QueueUrl:              aws.String(s.queueURL),
+				MaxNumberOfMessages:   aws.Int64(10),
+				MessageAttributeNames: aws.StringSlice([]string{"All"}),
+			}
+			receiveResp, err := s.svc.ReceiveMessageWithContext(s.ctx, receiveParams)
+			if err != nil {
+				close(messageChannel)
+				return
+			}
+
+			for _, msg := range receiveResp.Messages {
+				message := Message{ID: aws.StringValue(msg.MessageId), Body: aws.StringValue(msg.Body)}
+				for key, value := range msg.MessageAttributes {
+					if value.StringValue != nil {
+						if message.Headers == nil {
+							message.Headers = make(map[string]string)
+						}
+						message.Headers[key] = *value.StringValue
+					}
+				}
+				messageChannel <- message
+			}
+		}
+	}()
+	return messageChannel, nil
+}
+
 func (s *SQSQueue) Close() error {
 	// Cancel the context to signal stop receiving messages
 	s.ctx.Done()
@@ -0,0 +1,418 @@
+package queue
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ContentEncodingHeader names the codec the body of a compressed message
+	// is compressed with.
+	ContentEncodingHeader = "content-encoding"
+	// ClaimCheckHeader marks a message whose body is the name of a blob that
+	// holds the real body.
+	ClaimCheckHeader = "claim-check"
+
+	// DefaultCompressionThreshold is the body size from which messages are
+	// compressed.
+	DefaultCompressionThreshold = 1 << 10
+	// DefaultMaxMessageSize is the largest body sent to the broker in
+	// claim-check mode. It leaves room below the 256 KiB limit of SQS for
+	// headers and the envelope.
+	DefaultMaxMessageSize = 240 << 10
+
+	// maxDecompressedSize bounds the size of a decompressed body.
+	maxDecompressedSize = 64 << 20
+)
+
+// ErrUnknownCodec is reported for a message compressed with a codec that is
+// not known.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec compresses message bodies.
+type Codec interface {
+	// Name is the value of the ContentEncodingHeader of messages compressed
+	// with the codec.
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Named compression levels, after those of zstd encoders. Codecs map them
+// to their own levels.
+const (
+	LevelFastest = "fastest"
+	LevelDefault = "default"
+	LevelBetter  = "better"
+	LevelBest    = "best"
+)
+
+// flateLevels maps named levels to the levels of compress/flate.
+var flateLevels = map[string]int{
+	LevelFastest: flate.BestSpeed,
+	LevelDefault: flate.DefaultCompression,
+	LevelBetter:  7,
+	LevelBest:    flate.BestCompression,
+}
+
+type gzipCodec struct{ level int }
+
+// Gzip returns a codec that compresses with gzip at level, one of
+// compress/flate's levels.
+func Gzip(level int) Codec {
+	return gzipCodec{level: level}
+}
+
+func (c gzipCodec) Name() string { return "gzip" }
+
+func (c gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+type deflateCodec struct{ level int }
+
+// Deflate returns a codec that compresses with raw DEFLATE at level, one of
+// compress/flate's levels. It saves gzip's header and checksum, which
+// matters for small messages.
+func Deflate(level int) Codec {
+	return deflateCodec{level: level}
+}
+
+func (c deflateCodec) Name() string { return "deflate" }
+
+func (c deflateCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c deflateCodec) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readLimited(r)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed body exceeds %d bytes", maxDecompressedSize)
+	}
+	return data, nil
+}
+
+// ParseCodec parses a codec name with an optional level, such as "gzip",
+// "deflate:fastest" or "gzip:9".
+func ParseCodec(spec string) (Codec, error) {
+	name, level, _ := strings.Cut(spec, ":")
+	l := flate.DefaultCompression
+	if level != "" {
+		var ok bool
+		if l, ok = flateLevels[level]; !ok {
+			n, err := strconv.Atoi(level)
+			if err != nil || n < flate.HuffmanOnly || n > flate.BestCompression {
+				return nil, fmt.Errorf("invalid compression level %q", level)
+			}
+			l = n
+		}
+	}
+	switch name {
+	case "gzip":
+		return Gzip(l), nil
+	case "deflate":
+		return Deflate(l), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+// CompressedQueue is a Queue decorator that compresses message bodies of at
+// least a threshold size, and in claim-check mode stores bodies that are
+// still too large in a blob directory and sends their name instead. The
+// codec travels in the ContentEncodingHeader header; smaller messages are
+// sent as they are.
+type CompressedQueue struct {
+	queue     Queue
+	codec     Codec
+	codecs    map[string]Codec
+	threshold int
+	blobDir   string
+	maxSize   int
+	onError   func(error)
+}
+
+var _ HeaderQueue = (*CompressedQueue)(nil)
+
+// CompressionOption configures a CompressedQueue.
+type CompressionOption func(*CompressedQueue)
+
+// WithCodec compresses with codec instead of gzip.
+func WithCodec(codec Codec) CompressionOption {
+	return func(q *CompressedQueue) {
+		q.codec = codec
+		q.codecs[codec.Name()] = codec
+	}
+}
+
+// WithThreshold sets the body size from which messages are compressed.
+func WithThreshold(size int) CompressionOption {
+	return func(q *CompressedQueue) {
+		q.threshold = size
+	}
+}
+
+// WithClaimCheck stores bodies larger than maxSize after compression as
+// blobs in dir. Senders and receivers must share the directory.
+func WithClaimCheck(dir string, maxSize int) CompressionOption {
+	return func(q *CompressedQueue) {
+		q.blobDir = dir
+		q.maxSize = maxSize
+	}
+}
+
+// NewCompressedQueue returns q compressing with gzip at the default
+// threshold, unless configured otherwise. Messages compressed with gzip or
+// deflate are decompressed whatever the codec used for sending. Received
+// messages that cannot be decompressed are dropped and reported to onError,
+// if it is not nil.
+func NewCompressedQueue(q Queue, onError func(error), opts ...CompressionOption) *CompressedQueue {
+	c := &CompressedQueue{
+		queue:     q,
+		codec:     Gzip(flate.DefaultCompression),
+		threshold: DefaultCompressionThreshold,
+		onError:   onError,
+	}
+	c.codecs = map[string]Codec{"gzip": c.codec, "deflate": Deflate(flate.DefaultCompression)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SendMessage compresses message if it is large enough and sends it.
+func (q *CompressedQueue) SendMessage(message string) error {
+	return q.SendMessageWithHeaders(message, nil)
+}
+
+// SendMessageWithHeaders compresses message if it is large enough and sends
+// it with headers.
+func (q *CompressedQueue) SendMessageWithHeaders(message string, headers map[string]string) error {
+	if len(message) < q.threshold {
+		return q.send(message, headers)
+	}
+	compressed, err := q.codec.Compress([]byte(message))
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(compressed)
+	if len(encoded) >= len(message) {
+		// The message does not compress.
+		return q.send(message, headers)
+	}
+	h := make(map[string]string, len(headers)+2)
+	for key, value := range headers {
+		h[key] = value
+	}
+	h[ContentEncodingHeader] = q.codec.Name()
+	if q.blobDir == "" || len(encoded) <= q.maxSize {
+		return Send(q.queue, encoded, h)
+	}
+	name, err := q.store(compressed)
+	if err != nil {
+		return err
+	}
+	h[ClaimCheckHeader] = "true"
+	return Send(q.queue, name, h)
+}
+
+// send sends an uncompressed message, claim-checking it if it is too large.
+func (q *CompressedQueue) send(message string, headers map[string]string) error {
+	if q.blobDir == "" || len(message) <= q.maxSize {
+		return Send(q.queue, message, headers)
+	}
+	name, err := q.store([]byte(message))
+	if err != nil {
+		return err
+	}
+	h := make(map[string]string, len(headers)+1)
+	for key, value := range headers {
+		h[key] = value
+	}
+	h[ClaimCheckHeader] = "true"
+	return Send(q.queue, name, h)
+}
+
+// store writes data to a blob named by its SHA-256 hash and returns the
+// name. The blob is written to a temporary file first, so receivers never
+// read a partial blob.
+func (q *CompressedQueue) store(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:])
+	path := filepath.Join(q.blobDir, name)
+	if _, err := os.Stat(path); err == nil {
+		return name, nil
+	}
+	f, err := os.CreateTemp(q.blobDir, name+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+// load reads the blob name and checks it against its hash.
+func (q *CompressedQueue) load(name string) ([]byte, error) {
+	if q.blobDir == "" {
+		return nil, fmt.Errorf("claim-checked message %s without a blob directory", name)
+	}
+	if b, err := hex.DecodeString(name); err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid blob name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(q.blobDir, name))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != name {
+		return nil, fmt.Errorf("blob %s does not match its hash", name)
+	}
+	return data, nil
+}
+
+// decode returns the original body of message.
+func (q *CompressedQueue) decode(message Message) (string, error) {
+	encoding := message.Headers[ContentEncodingHeader]
+	codec, ok := q.codecs[encoding]
+	if encoding != "" && !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownCodec, encoding)
+	}
+	var data []byte
+	switch {
+	case message.Headers[ClaimCheckHeader] != "":
+		var err error
+		if data, err = q.load(message.Body); err != nil {
+			return "", err
+		}
+	case encoding != "":
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(message.Body); err != nil {
+			return "", fmt.Errorf("malformed %s body: %w", encoding, err)
+		}
+	default:
+		return message.Body, nil
+	}
+	if encoding == "" {
+		return string(data), nil
+	}
+	data, err := codec.Decompress(data)
+	if err != nil {
+		return "", fmt.Errorf("decompressing %s body: %w", encoding, err)
+	}
+	return string(data), nil
+}
+
+// ReceiveMessages returns the decompressed messages of the queue.
+func (q *CompressedQueue) ReceiveMessages() (<-chan Message, error) {
+	messages, err := Receive(q.queue)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Message, cap(messages))
+	go func() {
+		defer close(out)
+		for message := range messages {
+			body, err := q.decode(message)
+			if err != nil {
+				if q.onError != nil {
+					q.onError(err)
+				}
+				continue
+			}
+			headers := make(map[string]string, len(message.Headers))
+			for key, value := range message.Headers {
+				if key != ContentEncodingHeader && key != ClaimCheckHeader {
+					headers[key] = value
+				}
+			}
+			if len(headers) == 0 {
+				headers = nil
+			}
+			out <- Message{ID: message.ID, Body: body, Headers: headers}
+		}
+	}()
+	return out, nil
+}
+
+// ReceiveMessage returns the decompressed bodies of the messages of the
+// queue.
+func (q *CompressedQueue) ReceiveMessage() (<-chan string, error) {
+	messages, err := q.ReceiveMessages()
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan string, cap(messages))
+	go func() {
+		defer close(out)
+		for message := range messages {
+			out <- message.Body
+		}
+	}()
+	return out, nil
+}
+
+// Close closes the underlying queue.
+func (q *CompressedQueue) Close() error {
+	return q.queue.Close()
+}
@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressedQueue(t *testing.T) {
+	dir := t.TempDir()
+	broker := NewMemQueue(10)
+	var errs []error
+	q := NewCompressedQueue(broker, func(err error) { errs = append(errs, err) },
+		WithThreshold(100), WithClaimCheck(dir, 1000))
+
+	small := "addItem('a', 'b')"
+	large := "addItem('a', '" + strings.Repeat("x", 5000) + "')"
+	huge := "addItem('a', '" + randomText(5000) + "')"
+	headers := map[string]string{"traceparent": "t"}
+	for _, m := range []string{small, large, huge} {
+		if err := q.SendMessageWithHeaders(m, headers); err != nil {
+			t.Fatalf("Expected no error but got: %v", err)
+		}
+	}
+	Send(broker, "garbage", map[string]string{ContentEncodingHeader: "zstd"})
+	broker.Close()
+
+	raw, _ := broker.ReceiveMessage()
+	var sent []Message
+	for m := range raw {
+		sent = append(sent, Unwrap(m))
+	}
+	if sent[0].Body != small || sent[0].Headers[ContentEncodingHeader] != "" {
+		t.Errorf("Expected a small message to be sent as it is, got %v", sent[0])
+	}
+	if sent[1].Headers[ContentEncodingHeader] != "gzip" || len(sent[1].Body) >= 1000 {
+		t.Errorf("Expected a compressed message, got %v", sent[1])
+	}
+	if sent[2].Headers[ClaimCheckHeader] == "" {
+		t.Fatalf("Expected a claim-checked message, got %v", sent[2])
+	}
+	if _, err := os.Stat(filepath.Join(dir, sent[2].Body)); err != nil {
+		t.Errorf("Expected the blob to be stored: %v", err)
+	}
+
+	// A receiver gets the messages the broker saw.
+	replay := NewMemQueue(10)
+	for _, m := range sent {
+		Send(replay, m.Body, m.Headers)
+	}
+	replay.Close()
+	messages, err := NewCompressedQueue(replay, func(err error) { errs = append(errs, err) },
+		WithClaimCheck(dir, 1000)).ReceiveMessages()
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	var received []Message
+	for m := range messages {
+		received = append(received, m)
+	}
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 messages but got %d", len(received))
+	}
+	for i, want := range []string{small, large, huge} {
+		if received[i].Body != want {
+			t.Errorf("Expected message %d to be restored, got %.40s", i, received[i].Body)
+		}
+		if len(received[i].Headers) != 1 || received[i].Headers["traceparent"] != "t" {
+			t.Errorf("Expected only the sender's headers, got %v", received[i].Headers)
+		}
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrUnknownCodec) {
+		t.Errorf("Expected the unknown codec to be reported, got %v", errs)
+	}
+}
+
+func TestCompressedQueue_TamperedBlob(t *testing.T) {
+	dir := t.TempDir()
+	broker := NewMemQueue(10)
+	var errs []error
+	q := NewCompressedQueue(broker, func(err error) { errs = append(errs, err) },
+		WithCodec(Deflate(1)), WithClaimCheck(dir, 10))
+	if err := q.SendMessage("addItem('a', '" + randomText(100) + "')"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	Send(broker, "../../etc/passwd", map[string]string{ClaimCheckHeader: "true"})
+	broker.Close()
+
+	blobs, _ := os.ReadDir(dir)
+	if len(blobs) != 1 {
+		t.Fatalf("Expected one blob but got %d", len(blobs))
+	}
+	os.WriteFile(filepath.Join(dir, blobs[0].Name()), []byte("changed"), 0o644)
+
+	messages, _ := q.ReceiveMessage()
+	for m := range messages {
+		t.Errorf("Expected no message but got %s", m)
+	}
+	if len(errs) != 2 {
+		t.Errorf("Expected 2 errors but got %v", errs)
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		spec string
+		name string
+		ok   bool
+	}{
+		{"gzip", "gzip", true},
+		{"deflate:fastest", "deflate", true},
+		{"gzip:9", "gzip", true},
+		{"gzip:10", "", false},
+		{"gzip:fast", "", false},
+		{"zstd", "", false},
+	}
+	for _, test := range tests {
+		codec, err := ParseCodec(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseCodec(%q): unexpected error %v", test.spec, err)
+			continue
+		}
+		if err == nil && codec.Name() != test.name {
+			t.Errorf("ParseCodec(%q): expected %s but got %s", test.spec, test.name, codec.Name())
+		}
+	}
+}
+
+// randomText returns n characters that do not compress well.
+func randomText(n int) string {
+	var b strings.Builder
+	x := uint32(1)
+	for i := 0; i < n; i++ {
+		x = x*1664525 + 1013904223
+		b.WriteByte(byte('a' + x>>24%26))
+	}
+	return b.String()
+}
@@ -0,0 +1,242 @@
+package queue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// EncryptionKeyHeader names the key the body of an encrypted message is
+// encrypted with.
+const EncryptionKeyHeader = "encryption-key"
+
+var (
+	// ErrNotEncrypted is reported for a message received without an
+	// encryption key header.
+	ErrNotEncrypted = errors.New("message is not encrypted")
+	// ErrUnknownEncryptionKey is reported for a message encrypted with a
+	// key that is not loaded.
+	ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+)
+
+// EncryptionKey is a named AES key of 16, 24 or 32 bytes, base64 encoded in
+// key files.
+type EncryptionKey struct {
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+}
+
+// EncryptionKeyFile is the content of an encryption key file. Messages are
+// encrypted with the key Current, or the last key if it is not set, and
+// decrypted with any of the keys.
+type EncryptionKeyFile struct {
+	Current string          `json:"current,omitempty"`
+	Keys    []EncryptionKey `json:"keys"`
+}
+
+type keySet struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// EncryptionKeys holds the keys of an encryption key file. It can be
+// reloaded while in use.
+type EncryptionKeys struct {
+	path string
+	set  atomic.Pointer[keySet]
+}
+
+// LoadEncryptionKeys reads the encryption key file at path.
+func LoadEncryptionKeys(path string) (*EncryptionKeys, error) {
+	k := &EncryptionKeys{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewEncryptionKeys returns the keys of f.
+func NewEncryptionKeys(f EncryptionKeyFile) (*EncryptionKeys, error) {
+	k := &EncryptionKeys{}
+	return k, k.load(f)
+}
+
+// Reload reads the key file again. The keys in use are kept if the file is
+// invalid.
+func (k *EncryptionKeys) Reload() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	var f EncryptionKeyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("encryption key file %s: %w", k.path, err)
+	}
+	return k.load(f)
+}
+
+func (k *EncryptionKeys) load(f EncryptionKeyFile) error {
+	if len(f.Keys) == 0 {
+		return errors.New("no encryption keys")
+	}
+	set := &keySet{current: f.Current, aeads: make(map[string]cipher.AEAD, len(f.Keys))}
+	if set.current == "" {
+		set.current = f.Keys[len(f.Keys)-1].ID
+	}
+	for _, key := range f.Keys {
+		if key.ID == "" {
+			return errors.New("encryption key without id")
+		}
+		if _, ok := set.aeads[key.ID]; ok {
+			return fmt.Errorf("duplicate encryption key %s", key.ID)
+		}
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return fmt.Errorf("encryption key %s: %w", key.ID, err)
+		}
+		if set.aeads[key.ID], err = cipher.NewGCM(block); err != nil {
+			return fmt.Errorf("encryption key %s: %w", key.ID, err)
+		}
+	}
+	if _, ok := set.aeads[set.current]; !ok {
+		return fmt.Errorf("current encryption key %s is not in the file", set.current)
+	}
+	k.set.Store(set)
+	return nil
+}
+
+// encrypt seals plaintext with the current key. The key ID is
+// authenticated with the body.
+func (k *EncryptionKeys) encrypt(plaintext string) (string, string, error) {
+	set := k.set.Load()
+	aead := set.aeads[set.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(set.current))
+	return base64.StdEncoding.EncodeToString(sealed), set.current, nil
+}
+
+func (k *EncryptionKeys) decrypt(ciphertext, id string) (string, error) {
+	aead, ok := k.set.Load().aeads[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownEncryptionKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed ciphertext with key %s", id)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("decrypting with key %s: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// EncryptedQueue is a Queue decorator that encrypts message bodies with
+// AES-GCM before they reach the broker and decrypts them on receipt. The
+// key ID travels in the EncryptionKeyHeader header, so messages encrypted
+// with an older key can be read while keys are rotated. Headers are not
+// encrypted.
+type EncryptedQueue struct {
+	queue   Queue
+	keys    *EncryptionKeys
+	onError func(error)
+}
+
+var _ HeaderQueue = (*EncryptedQueue)(nil)
+
+// NewEncryptedQueue returns q encrypting with keys. Received messages that
+// are not encrypted or cannot be decrypted are dropped and reported to
+// onError, if it is not nil.
+func NewEncryptedQueue(q Queue, keys *EncryptionKeys, onError func(error)) *EncryptedQueue {
+	return &EncryptedQueue{queue: q, keys: keys, onError: onError}
+}
+
+// SendMessage encrypts message and sends it.
+func (q *EncryptedQueue) SendMessage(message string) error {
+	return q.SendMessageWithHeaders(message, nil)
+}
+
+// SendMessageWithHeaders encrypts message and sends it with headers.
+func (q *EncryptedQueue) SendMessageWithHeaders(message string, headers map[string]string) error {
+	ciphertext, id, err := q.keys.encrypt(message)
+	if err != nil {
+		return err
+	}
+	h := make(map[string]string, len(headers)+1)
+	for key, value := range headers {
+		h[key] = value
+	}
+	h[EncryptionKeyHeader] = id
+	return Send(q.queue, ciphertext, h)
+}
+
+// ReceiveMessages returns the decrypted messages of the queue.
+func (q *EncryptedQueue) ReceiveMessages() (<-chan Message, error) {
+	messages, err := Receive(q.queue)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Message, cap(messages))
+	go func() {
+		defer close(out)
+		for message := range messages {
+			id, ok := message.Headers[EncryptionKeyHeader]
+			if !ok {
+				q.report(ErrNotEncrypted)
+				continue
+			}
+			body, err := q.keys.decrypt(message.Body, id)
+			if err != nil {
+				q.report(err)
+				continue
+			}
+			headers := make(map[string]string, len(message.Headers)-1)
+			for key, value := range message.Headers {
+				if key != EncryptionKeyHeader {
+					headers[key] = value
+				}
+			}
+			if len(headers) == 0 {
+				headers = nil
+			}
+			out <- Message{ID: message.ID, Body: body, Headers: headers}
+		}
+	}()
+	return out, nil
+}
+
+// ReceiveMessage returns the decrypted bodies of the messages of the queue.
+func (q *EncryptedQueue) ReceiveMessage() (<-chan string, error) {
+	messages, err := q.ReceiveMessages()
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan string, cap(messages))
+	go func() {
+		defer close(out)
+		for message := range messages {
+			out <- message.Body
+		}
+	}()
+	return out, nil
+}
+
+// Close closes the underlying queue.
+func (q *EncryptedQueue) Close() error {
+	return q.queue.Close()
+}
+
+func (q *EncryptedQueue) report(err error) {
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
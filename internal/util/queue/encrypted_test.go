@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedQueue(t *testing.T) {
+	k1 := EncryptionKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}
+	k2 := EncryptionKey{ID: "k2", Key: bytes.Repeat([]byte{2}, 16)}
+	oldKeys, err := NewEncryptionKeys(EncryptionKeyFile{Keys: []EncryptionKey{k1}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	newKeys, err := NewEncryptionKeys(EncryptionKeyFile{Keys: []EncryptionKey{k1, k2}})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	broker := NewMemQueue(10)
+	var errs []error
+	receiver := NewEncryptedQueue(broker, newKeys, func(err error) { errs = append(errs, err) })
+
+	// During rotation the receiver reads both keys.
+	headers := map[string]string{"traceparent": "t"}
+	if err := NewEncryptedQueue(broker, oldKeys, nil).SendMessageWithHeaders("addItem('a', 'secret')", headers); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if err := receiver.SendMessage("getItem('a')"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	broker.SendMessage("deleteItem('a')")
+
+	// Tampering is detected by the authentication tag.
+	raw, _ := broker.ReceiveMessage()
+	Send(broker, "getAllItems()", nil)
+	first := <-raw
+	if strings.Contains(first, "secret") {
+		t.Errorf("Expected the broker to see only ciphertext, got %s", first)
+	}
+	second, third, fourth := <-raw, <-raw, <-raw
+	tampered := Unwrap(second)
+	tampered.Body = "A" + tampered.Body[1:]
+	for _, m := range []Message{Unwrap(first), tampered, Unwrap(second), {Body: third}, {Body: fourth}} {
+		Send(broker, m.Body, m.Headers)
+	}
+	broker.Close()
+
+	messages, err := receiver.ReceiveMessages()
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	var received []Message
+	for m := range messages {
+		received = append(received, m)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 messages but got %v", received)
+	}
+	if received[0].Body != "addItem('a', 'secret')" || received[0].Headers["traceparent"] != "t" {
+		t.Errorf("Unexpected message %v", received[0])
+	}
+	if received[1].Body != "getItem('a')" || received[1].Headers != nil {
+		t.Errorf("Unexpected message %v", received[1])
+	}
+	if len(errs) != 3 || !errors.Is(errs[1], ErrNotEncrypted) || !errors.Is(errs[2], ErrNotEncrypted) {
+		t.Errorf("Expected a tampered and two plaintext messages to be reported, got %v", errs)
+	}
+}
+
+func TestEncryptionKeys_Invalid(t *testing.T) {
+	tests := []EncryptionKeyFile{
+		{},
+		{Keys: []EncryptionKey{{ID: "k1", Key: []byte("short")}}},
+		{Current: "k2", Keys: []EncryptionKey{{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}}},
+	}
+	for _, f := range tests {
+		if _, err := NewEncryptionKeys(f); err == nil {
+			t.Errorf("Expected error for %+v", f)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Message is a queue message with metadata headers, such as trace context.
+type Message struct {
+	// ID is the broker's ID of the message, if it assigns one.
+	ID      string
+	Body    string
+	Headers map[string]string
+}
+
+// ClientIDHeader is the header naming the client that sent a message.
+const ClientIDHeader = "client-id"
+
+// NamespaceHeader is the header naming the namespace the command of a
+// message applies to, unless the command names one itself.
+const NamespaceHeader = "namespace"
+
+// IdempotencyKeyHeader is the header carrying a key that the client chose
+// for a message. Servers apply only one message with the same key from the
+// same client within their idempotency window.
+const IdempotencyKeyHeader = "idempotency-key"
+
+// HeaderQueue is implemented by queues that carry headers next to the
+// message body natively.
+type HeaderQueue interface {
+	Queue
+	// SendMessageWithHeaders sends a message with headers to the queue.
+	SendMessageWithHeaders(message string, headers map[string]string) error
+	// ReceiveMessages receives a channel of messages with their headers.
+	ReceiveMessages() (<-chan Message, error)
+}
+
+// envelope carries the headers of a message through queues that only
+// transport a body. Commands never start with "{", so an envelope cannot be
+// mistaken for a plain command.
+type envelope struct {
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Send sends body with headers to q, natively if q is a HeaderQueue and as a
+// JSON envelope otherwise. Without headers the body is sent as is.
+func Send(q Queue, body string, headers map[string]string) error {
+	if hq, ok := q.(HeaderQueue); ok {
+		return hq.SendMessageWithHeaders(body, headers)
+	}
+	if len(headers) == 0 {
+		return q.SendMessage(body)
+	}
+	data, err := json.Marshal(envelope{Body: body, Headers: headers})
+	if err != nil {
+		return err
+	}
+	return q.SendMessage(string(data))
+}
+
+// Receive returns the messages of q with their headers, unwrapping JSON
+// envelopes written by Send.
+func Receive(q Queue) (<-chan Message, error) {
+	if hq, ok := q.(HeaderQueue); ok {
+		return hq.ReceiveMessages()
+	}
+	messages, err := q.ReceiveMessage()
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Message, cap(messages))
+	go func() {
+		defer close(out)
+		for message := range messages {
+			out <- Unwrap(message)
+		}
+	}()
+	return out, nil
+}
+
+// Unwrap returns the message carried by a JSON envelope, or message itself
+// without headers if it is not an envelope.
+func Unwrap(message string) Message {
+	if strings.HasPrefix(message, "{") {
+		var e envelope
+		if err := json.Unmarshal([]byte(message), &e); err == nil {
+			return Message{Body: e.Body, Headers: e.Headers}
+		}
+	}
+	return Message{Body: message}
+}
+
+var (
+	_ HeaderQueue = (*RabbitMQQueue)(nil)
+	_ HeaderQueue = (*SQSQueue)(nil)
+)
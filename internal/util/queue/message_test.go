@@ -0,0 +1,50 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSendReceive_Envelope(t *testing.T) {
+	q := NewMemQueue(5)
+	headers := map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	if err := Send(q, "getItem('a')", headers); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := Send(q, "getItem('b')", nil); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	// Queues without headers carry them in an envelope.
+	raw, _ := q.ReceiveMessage()
+	if message := <-raw; message[0] != '{' {
+		t.Errorf("Expected an envelope, got %s", message)
+	}
+	if message := <-raw; message != "getItem('b')" {
+		t.Errorf("Expected a plain message without headers, got %s", message)
+	}
+
+	if err := Send(q, "getItem('a')", headers); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	q.SendMessage("getItem('c')")
+	q.Close()
+	messages, err := Receive(q)
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	expected := []Message{{Body: "getItem('a')", Headers: headers}, {Body: "getItem('c')"}}
+	var received []Message
+	for message := range messages {
+		received = append(received, message)
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, got %v", expected, received)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	if message := Unwrap("{not json"); message.Body != "{not json" || message.Headers != nil {
+		t.Errorf("Unexpected message %v", message)
+	}
+}
@@ -49,15 +49,7 @@ func NewRabbitMQQueue(ctx context.Context, url string, queueName string, bufferL
 
 // ReceiveMessage receives a channel of messages from the RabbitMQ queue.
 func (q *RabbitMQQueue) ReceiveMessage() (<-chan string, error) {
-	msgs, err := q.channel.Consume(
-		q.queueName, // queue
-		"",          // consumer
-		true,        // auto-ack
-		false,       // exclusive
-		false,       // no-local
-		false,       // no-wait
-		nil,         // args
-	)
+	msgs, err := q.consume()
 	if err != nil {
 		return nil, err
 	}
@@ -81,14 +73,75 @@ func (q *RabbitMQQueue) ReceiveMessage() (<-chan string, error) {
 	return msgChan, nil
 }
 
+// ReceiveMessages receives a channel of messages with their AMQP headers.
+// Headers that are not strings are dropped.
+func (q *RabbitMQQueue) ReceiveMessages() (<-chan Message, error) {
+	msgs, err := q.consume()
+	if err != nil {
+		return nil, err
+	}
+
+	msgChan := make(chan Message, q.bufferLength)
+	go func() {
+		defer close(msgChan)
+		for {
+			select {
+			case <-q.ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				message := Message{ID: msg.MessageId, Body: string(msg.Body)}
+				for key, value := range msg.Headers {
+					if s, ok := value.(string); ok {
+						if message.Headers == nil {
+							message.Headers = make(map[string]string)
+						}
+						message.Headers[key] = s
+					}
+				}
+				msgChan <- message
+			}
+		}
+	}()
+
+	return msgChan, nil
+}
+
+func (q *RabbitMQQueue) consume() (<-chan amqp.Delivery, error) {
+	return q.channel.Consume(
+		q.queueName, // queue
+		"",          // consumer
+		true,        // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+}
+
 // SendMessage sends a message to the RabbitMQ queue.
 func (q *RabbitMQQueue) SendMessage(message string) error {
+	return q.SendMessageWithHeaders(message, nil)
+}
+
+// SendMessageWithHeaders sends a message with AMQP headers to the RabbitMQ queue.
+func (q *RabbitMQQueue) SendMessageWithHeaders(message string, headers map[string]string) error {
+	var table amqp.Table
+	if len(headers) > 0 {
+		table = make(amqp.Table, len(headers))
+		for key, value := range headers {
+			table[key] = value
+		}
+	}
 	return q.channel.Publish(
 		"",            // exchange
 		q.routingName, // routing key
 		false,         // mandatory
 		false,         // immediate
 		amqp.Publishing{
+			Headers:     table,
 			ContentType: "text/plain",
 			Body:        []byte(message),
 		})
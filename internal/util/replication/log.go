@@ -0,0 +1,128 @@
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrSeqTooOld is returned when reading entries that are no longer retained.
+var ErrSeqTooOld = errors.New("replication: sequence number is no longer retained")
+
+// Entry is a mutating command applied by the primary. Sequence numbers start
+// at 1 and have no gaps.
+type Entry struct {
+	Seq     uint64 `json:"seq"`
+	Command string `json:"command"`
+}
+
+// Log keeps the most recent entries applied by a primary. Every log has a
+// random ID, so a replica can tell whether it still follows the log its
+// sequence number belongs to.
+type Log struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	id      string
+	seq     uint64
+	entries []Entry
+	retain  int
+}
+
+// NewLog creates a Log that retains the last retain entries.
+func NewLog(retain int) *Log {
+	if retain < 1 {
+		retain = 1
+	}
+	l := &Log{id: newID(), retain: retain}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+// ID returns the ID of the log.
+func (l *Log) ID() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.id
+}
+
+// Reset drops every entry and gives the log a new ID. It is used when the
+// state of the primary no longer follows from the entries, for example
+// after it was promoted from replica, so that its replicas resync.
+func (l *Log) Reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.id = newID()
+	l.entries = nil
+	l.cond.Broadcast()
+}
+
+// Append adds command to the log and returns its sequence number. Callers
+// must append in the order the commands were applied.
+func (l *Log) Append(command string) uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.seq++
+	l.entries = append(l.entries, Entry{Seq: l.seq, Command: command})
+	if len(l.entries) > l.retain {
+		l.entries = l.entries[len(l.entries)-l.retain:]
+	}
+	l.cond.Broadcast()
+	return l.seq
+}
+
+// Seq returns the sequence number of the last entry.
+func (l *Log) Seq() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.seq
+}
+
+// Has reports whether reading from sequence number from on is possible.
+func (l *Log) Has(from uint64) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return from >= l.oldest() && from <= l.seq+1
+}
+
+// Wait blocks until there are entries from sequence number from on and
+// returns them, or returns early with ctx's error.
+func (l *Log) Wait(ctx context.Context, from uint64) ([]Entry, error) {
+	stop := context.AfterFunc(ctx, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for l.seq < from && ctx.Err() == nil {
+		l.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if from < l.oldest() {
+		return nil, ErrSeqTooOld
+	}
+	start := int(from - l.entries[0].Seq)
+	return append([]Entry(nil), l.entries[start:]...), nil
+}
+
+// oldest returns the sequence number of the oldest retained entry. The
+// caller must hold the mutex.
+func (l *Log) oldest() uint64 {
+	if len(l.entries) == 0 {
+		return l.seq + 1
+	}
+	return l.entries[0].Seq
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
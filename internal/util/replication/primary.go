@@ -0,0 +1,144 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"command-queue/internal/util/logger"
+)
+
+// Replicas and primaries exchange JSON lines. A replica opens the session
+// with a hello naming the log it follows and the first entry it needs. The
+// primary answers with a snapshot when it cannot resume there, then streams
+// entries in order.
+type hello struct {
+	Log  string `json:"log,omitempty"`
+	From uint64 `json:"from"`
+}
+
+const (
+	messageSnapshot = "snapshot"
+	messageEntry    = "entry"
+)
+
+type message struct {
+	Type    string `json:"type"`
+	Seq     uint64 `json:"seq"`
+	Log     string `json:"log,omitempty"`
+	Command string `json:"command,omitempty"`
+	Items   []Item `json:"items,omitempty"`
+}
+
+// Item is a key/value pair of a snapshot. Namespace is "" for items of the
+// default namespace. Expires is the time the key expires, if it has an
+// expiry deadline.
+type Item struct {
+	Namespace string      `json:"namespace,omitempty"`
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value"`
+	Expires   *time.Time  `json:"expires,omitempty"`
+}
+
+// SnapshotFunc returns every item of the map in order together with the
+// sequence number of the last log entry they include.
+type SnapshotFunc func() ([]Item, uint64)
+
+// Primary streams its log to replicas.
+type Primary struct {
+	log      *Log
+	snapshot SnapshotFunc
+	logger   logger.Logger
+}
+
+// NewPrimary creates a Primary serving entries of log. snapshot is used to
+// bring new or lagging replicas up to date.
+func NewPrimary(log *Log, snapshot SnapshotFunc, logger logger.Logger) *Primary {
+	return &Primary{
+		log:      log,
+		snapshot: snapshot,
+		logger:   logger.Component("replication"),
+	}
+}
+
+// Serve accepts replicas on ln until ctx is done.
+func (p *Primary) Serve(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.handle(ctx, conn); err != nil && ctx.Err() == nil {
+				p.logger.Warn("Replication to replica stopped", "replica", conn.RemoteAddr().String(), logger.KeyError, err)
+			}
+		}()
+	}
+}
+
+func (p *Primary) handle(ctx context.Context, conn net.Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	// The replica sends nothing after its hello; a read returning means it
+	// went away.
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var h hello
+	if err := json.Unmarshal(line, &h); err != nil {
+		return err
+	}
+	go func() {
+		_, _ = reader.ReadByte()
+		cancel()
+	}()
+
+	writer := bufio.NewWriter(conn)
+	encoder := json.NewEncoder(writer)
+	next := h.From
+	if next == 0 || h.Log != p.log.ID() || !p.log.Has(next) {
+		items, seq := p.snapshot()
+		if err := encoder.Encode(message{Type: messageSnapshot, Seq: seq, Log: p.log.ID(), Items: items}); err != nil {
+			return err
+		}
+		next = seq + 1
+	}
+	for {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		entries, err := p.log.Wait(ctx, next)
+		if errors.Is(err, ErrSeqTooOld) {
+			// The replica fell behind; it resyncs from a snapshot when it
+			// reconnects.
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			if err := encoder.Encode(message{Type: messageEntry, Seq: entry.Seq, Command: entry.Command}); err != nil {
+				return err
+			}
+		}
+		next = entries[len(entries)-1].Seq + 1
+	}
+}
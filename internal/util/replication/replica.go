@@ -0,0 +1,166 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"command-queue/internal/util/logger"
+)
+
+const (
+	reconnectDelay    = 100 * time.Millisecond
+	maxReconnectDelay = 5 * time.Second
+)
+
+// Applier applies what a replica receives from its primary.
+type Applier interface {
+	// ApplySnapshot replaces the whole state with items.
+	ApplySnapshot(items []Item) error
+	// ApplyEntry applies one command of the primary's log.
+	ApplyEntry(command string) error
+}
+
+// Replica follows a primary and applies its log in order.
+type Replica struct {
+	addr    string
+	applier Applier
+	logger  logger.Logger
+	seq     atomic.Uint64
+	synced  atomic.Bool
+	// logID is the ID of the primary's log seq belongs to. It is only used
+	// by the goroutine running the replica.
+	logID string
+}
+
+// NewReplica creates a Replica of the primary listening on addr.
+func NewReplica(addr string, applier Applier, logger logger.Logger) *Replica {
+	return &Replica{
+		addr:    addr,
+		applier: applier,
+		logger:  logger.Component("replication"),
+	}
+}
+
+// Addr returns the address of the primary.
+func (r *Replica) Addr() string {
+	return r.addr
+}
+
+// Seq returns the sequence number of the last applied entry.
+func (r *Replica) Seq() uint64 {
+	return r.seq.Load()
+}
+
+// Synced reports whether the replica received its primary's data.
+func (r *Replica) Synced() bool {
+	return r.synced.Load()
+}
+
+// Run follows the primary until ctx is done, reconnecting when the
+// connection is lost.
+func (r *Replica) Run(ctx context.Context) {
+	delay := reconnectDelay
+	for ctx.Err() == nil {
+		applied, err := r.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if applied {
+			delay = reconnectDelay
+		}
+		r.logger.Warn("Replication interrupted, reconnecting", "primary", r.addr, "delay", delay, logger.KeyError, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// follow runs one session with the primary and reports whether anything
+// was applied before it ended.
+func (r *Replica) follow(ctx context.Context) (bool, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	from := uint64(0)
+	if seq := r.seq.Load(); seq > 0 {
+		from = seq + 1
+	}
+	if err := json.NewEncoder(conn).Encode(hello{Log: r.logID, From: from}); err != nil {
+		return false, err
+	}
+
+	applied := false
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return applied, err
+		}
+		switch msg.Type {
+		case messageSnapshot:
+			if err := r.applier.ApplySnapshot(msg.Items); err != nil {
+				return applied, err
+			}
+			r.logID = msg.Log
+			r.synced.Store(true)
+		case messageEntry:
+			if seq := r.seq.Load(); msg.Seq != seq+1 {
+				return applied, fmt.Errorf("expected entry %d, got %d", seq+1, msg.Seq)
+			}
+			if err := r.applier.ApplyEntry(msg.Command); err != nil {
+				// The replica diverged from its primary; start over from a
+				// snapshot.
+				r.seq.Store(0)
+				return applied, fmt.Errorf("applying entry %d: %w", msg.Seq, err)
+			}
+		default:
+			return applied, fmt.Errorf("unknown message type %q", msg.Type)
+		}
+		r.seq.Store(msg.Seq)
+		applied = true
+	}
+	if err := scanner.Err(); err != nil {
+		return applied, err
+	}
+	return applied, fmt.Errorf("connection closed by primary")
+}
+
+// FetchSnapshot reads every item of the primary listening on addr, together
+// with the last log entry they include, without following the log.
+func FetchSnapshot(ctx context.Context, addr string) ([]Item, uint64, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := json.NewEncoder(conn).Encode(hello{}); err != nil {
+		return nil, 0, err
+	}
+	var msg message
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil {
+		return nil, 0, err
+	}
+	if msg.Type != messageSnapshot {
+		return nil, 0, fmt.Errorf("expected a snapshot, got %q", msg.Type)
+	}
+	return msg.Items, msg.Seq, nil
+}
@@ -0,0 +1,223 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"command-queue/internal/util/logger"
+)
+
+func TestLog(t *testing.T) {
+	l := NewLog(3)
+	for _, command := range []string{"a", "b", "c", "d"} {
+		l.Append(command)
+	}
+	if l.Seq() != 4 {
+		t.Errorf("Expected seq 4, Got: %d", l.Seq())
+	}
+
+	entries, err := l.Wait(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("Wait returned an error: %v", err)
+	}
+	expected := []Entry{{Seq: 3, Command: "c"}, {Seq: 4, Command: "d"}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, Got: %v", expected, entries)
+	}
+	if _, err := l.Wait(context.Background(), 1); !errors.Is(err, ErrSeqTooOld) {
+		t.Errorf("Expected ErrSeqTooOld, Got: %v", err)
+	}
+	if l.Has(1) || !l.Has(2) || !l.Has(5) || l.Has(6) {
+		t.Errorf("Unexpected retained range")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(ctx, 5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, Got: %v", err)
+	}
+}
+
+// memApplier keeps the state of a replica as a list of commands.
+type memApplier struct {
+	mutex     sync.Mutex
+	snapshot  []Item
+	snapshots int
+	commands  []string
+}
+
+func (a *memApplier) ApplySnapshot(items []Item) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.snapshot = items
+	a.snapshots++
+	a.commands = nil
+	return nil
+}
+
+func (a *memApplier) ApplyEntry(command string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.commands = append(a.commands, command)
+	return nil
+}
+
+func (a *memApplier) state() ([]Item, []string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]Item(nil), a.snapshot...), append([]string(nil), a.commands...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not reached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := NewLog(100)
+	l.Append("addItem('a','1')")
+	snapshot := func() ([]Item, uint64) {
+		return []Item{{Key: "a", Value: "1"}}, l.Seq()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned an error: %v", err)
+	}
+	go NewPrimary(l, snapshot, logger.NewConsoleLogger()).Serve(ctx, ln)
+
+	applier := &memApplier{}
+	r := NewReplica(ln.Addr().String(), applier, logger.NewConsoleLogger())
+	go r.Run(ctx)
+	waitFor(t, func() bool { return r.Seq() == 1 })
+
+	l.Append("addItem('b','2')")
+	l.Append("deleteItem('a')")
+	waitFor(t, func() bool { return r.Seq() == 3 })
+
+	items, seq, err := FetchSnapshot(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("FetchSnapshot returned an error: %v", err)
+	}
+	if seq != 3 || len(items) != 1 {
+		t.Errorf("Unexpected snapshot %v at %d", items, seq)
+	}
+
+	items, commands := applier.state()
+	if !reflect.DeepEqual(items, []Item{{Key: "a", Value: "1"}}) {
+		t.Errorf("Unexpected snapshot: %v", items)
+	}
+	expected := []string{"addItem('b','2')", "deleteItem('a')"}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, Got: %v", expected, commands)
+	}
+}
+
+func TestReplication_Reconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := NewLog(100)
+	l.Append("addItem('a','1')")
+	snapshot := func() ([]Item, uint64) {
+		return []Item{{Key: "a", Value: "1"}}, l.Seq()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned an error: %v", err)
+	}
+	addr := ln.Addr().String()
+	primaryCtx, stopPrimary := context.WithCancel(ctx)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		NewPrimary(l, snapshot, logger.NewConsoleLogger()).Serve(primaryCtx, ln)
+	}()
+
+	applier := &memApplier{}
+	r := NewReplica(addr, applier, logger.NewConsoleLogger())
+	go r.Run(ctx)
+	waitFor(t, func() bool { return r.Seq() == 1 })
+
+	// Entries appended while the replica is disconnected are sent once it
+	// reconnects, without a new snapshot.
+	stopPrimary()
+	<-served
+	l.Append("addItem('b','2')")
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen returned an error: %v", err)
+	}
+	go NewPrimary(l, snapshot, logger.NewConsoleLogger()).Serve(ctx, ln)
+	waitFor(t, func() bool { return r.Seq() == 2 })
+
+	_, commands := applier.state()
+	expected := []string{"addItem('b','2')"}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, Got: %v", expected, commands)
+	}
+	if applier.snapshots != 1 {
+		t.Errorf("Expected 1 snapshot, Got: %d", applier.snapshots)
+	}
+}
+
+func TestReplication_NewLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := NewLog(100)
+	l.Append("addItem('a','1')")
+	snapshot := func() ([]Item, uint64) {
+		return []Item{{Key: "a", Value: "1"}}, l.Seq()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned an error: %v", err)
+	}
+	primaryCtx, stopPrimary := context.WithCancel(ctx)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		NewPrimary(l, snapshot, logger.NewConsoleLogger()).Serve(primaryCtx, ln)
+	}()
+
+	applier := &memApplier{}
+	r := NewReplica(ln.Addr().String(), applier, logger.NewConsoleLogger())
+	go r.Run(ctx)
+	waitFor(t, func() bool { return r.Seq() == 1 })
+
+	// A primary with a different log at the same address sends a new
+	// snapshot even though the replica's sequence number is retained.
+	stopPrimary()
+	<-served
+	other := NewLog(100)
+	other.Append("addItem('b','2')")
+	other.Append("addItem('c','3')")
+	ln, err = net.Listen("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Listen returned an error: %v", err)
+	}
+	otherSnapshot := func() ([]Item, uint64) {
+		return []Item{{Key: "b", Value: "2"}, {Key: "c", Value: "3"}}, other.Seq()
+	}
+	go NewPrimary(other, otherSnapshot, logger.NewConsoleLogger()).Serve(ctx, ln)
+	waitFor(t, func() bool { return r.Seq() == 2 })
+
+	items, commands := applier.state()
+	if len(items) != 2 || len(commands) != 0 {
+		t.Errorf("Expected a new snapshot, Got: %v %v", items, commands)
+	}
+}
@@ -0,0 +1,323 @@
+// Package tracing records spans of work done for a command and propagates
+// their context through queue messages with the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceparentHeader is the header carrying the span context of a message.
+const TraceparentHeader = "traceparent"
+
+const (
+	// exportBatchSize and exportInterval bound how long ended spans wait
+	// before they are exported.
+	exportBatchSize = 256
+	exportInterval  = time.Second
+	// spanBuffer is the number of ended spans that can wait for export;
+	// spans ended while it is full are dropped.
+	spanBuffer = 4096
+)
+
+// ErrInvalidTraceparent is returned for malformed traceparent headers.
+var ErrInvalidTraceparent = errors.New("tracing: invalid traceparent")
+
+// SpanContext identifies a span across processes.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context stored in ctx.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Inject adds the span context of ctx to headers.
+func Inject(ctx context.Context, headers map[string]string) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		headers[TraceparentHeader] = sc.Traceparent()
+	}
+}
+
+// Extract returns ctx with the span context carried by headers, if any.
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	sc, err := ParseTraceparent(headers[TraceparentHeader])
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// SpanKind tells what role a span plays in a trace.
+type SpanKind string
+
+const (
+	KindInternal SpanKind = "internal"
+	KindServer   SpanKind = "server"
+	KindProducer SpanKind = "producer"
+	KindConsumer SpanKind = "consumer"
+)
+
+// SpanData is an ended span as it is exported.
+type SpanData struct {
+	Service      string            `json:"service"`
+	Name         string            `json:"name"`
+	Kind         SpanKind          `json:"kind"`
+	TraceID      string            `json:"traceId"`
+	SpanID       string            `json:"spanId"`
+	ParentSpanID string            `json:"parentSpanId,omitempty"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Exporter sends ended spans to a backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer creates spans and exports them in batches in the background. A nil
+// *Tracer is valid and records nothing.
+type Tracer struct {
+	service  string
+	exporter Exporter
+	spans    chan SpanData
+	done     chan struct{}
+	closed   sync.Once
+	dropped  atomic.Uint64
+	onError  func(error)
+}
+
+// NewTracer creates a Tracer for service that sends spans to exporter.
+// onError, if not nil, is called when an export fails.
+func NewTracer(service string, exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		spans:    make(chan SpanData, spanBuffer),
+		done:     make(chan struct{}),
+		onError:  onError,
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span that is a child of the span context in ctx, or the
+// root of a new trace. The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Service: t.service,
+			Name:    name,
+			Kind:    kind,
+			Start:   time.Now(),
+		},
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.data.ParentSpanID = hex.EncodeToString(parent.SpanID[:])
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+	s.data.TraceID = hex.EncodeToString(s.sc.TraceID[:])
+	s.data.SpanID = hex.EncodeToString(s.sc.SpanID[:])
+	return ContextWithSpanContext(ctx, s.sc), s
+}
+
+// Dropped returns the number of spans dropped because the export buffer
+// was full.
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+// Close exports the remaining spans and closes the exporter if it is an
+// io.Closer.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.closed.Do(func() {
+		close(t.spans)
+	})
+	<-t.done
+	if c, ok := t.exporter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (t *Tracer) end(data SpanData) {
+	defer func() {
+		// Spans ended after Close are dropped.
+		if recover() != nil {
+			t.dropped.Add(1)
+		}
+	}()
+	select {
+	case t.spans <- data:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil && t.onError != nil {
+			t.onError(fmt.Errorf("exporting %d spans: %w", len(batch), err))
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case data, ok := <-t.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Span is a unit of work. A nil *Span is valid and records nothing.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span context of s.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute records a key/value pair on the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed with err, if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End ends the span and queues it for export if it is sampled. Only the
+// first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mutex.Unlock()
+	if s.sc.Sampled {
+		s.tracer.end(data)
+	}
+}
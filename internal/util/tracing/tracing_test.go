@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memExporter keeps exported spans in memory.
+type memExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (e *memExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	value := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		t.Fatalf("ParseTraceparent returned an error: %v", err)
+	}
+	if !sc.Sampled || sc.Traceparent() != value {
+		t.Errorf("Expected %s, Got: %s", value, sc.Traceparent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b716920333x-01",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	} {
+		if _, err := ParseTraceparent(invalid); !errors.Is(err, ErrInvalidTraceparent) {
+			t.Errorf("Expected ErrInvalidTraceparent for %q, Got: %v", invalid, err)
+		}
+	}
+}
+
+func TestTracer_Propagation(t *testing.T) {
+	exporter := &memExporter{}
+	tracer := NewTracer("client", exporter, nil)
+
+	ctx, send := tracer.Start(context.Background(), "send", KindProducer)
+	headers := make(map[string]string)
+	Inject(ctx, headers)
+	send.End()
+
+	// The receiving side continues the trace from the headers.
+	ctx, process := tracer.Start(Extract(context.Background(), headers), "process", KindConsumer)
+	_, parse := tracer.Start(ctx, "parse", KindInternal)
+	parse.SetError(errors.New("bad command"))
+	parse.End()
+	process.SetAttribute("command.type", "getItem")
+	process.End()
+	process.End()
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	if len(exporter.spans) != 3 {
+		t.Fatalf("Expected 3 spans, Got: %d", len(exporter.spans))
+	}
+	s, p, c := exporter.spans[0], exporter.spans[2], exporter.spans[1]
+	if p.TraceID != s.TraceID || c.TraceID != s.TraceID {
+		t.Errorf("Expected one trace, Got: %s %s %s", s.TraceID, p.TraceID, c.TraceID)
+	}
+	if s.ParentSpanID != "" || p.ParentSpanID != s.SpanID || c.ParentSpanID != p.SpanID {
+		t.Errorf("Unexpected parents: %+v", exporter.spans)
+	}
+	if c.Error != "bad command" || p.Attributes["command.type"] != "getItem" {
+		t.Errorf("Unexpected span data: %+v %+v", c, p)
+	}
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop", KindInternal)
+	span.SetAttribute("a", "b")
+	span.End()
+	headers := make(map[string]string)
+	Inject(ctx, headers)
+	if len(headers) != 0 || tracer.Close() != nil {
+		t.Errorf("Expected a nil tracer to record nothing")
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("NewFileExporter returned an error: %v", err)
+	}
+	tracer := NewTracer("server", exporter, nil)
+	_, span := tracer.Start(context.Background(), "write", KindInternal)
+	span.End()
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var spans []SpanData
+	for scanner.Scan() {
+		var data SpanData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			t.Fatalf("Invalid line %s: %v", scanner.Text(), err)
+		}
+		spans = append(spans, data)
+	}
+	if len(spans) != 1 || spans[0].Name != "write" || spans[0].Service != "server" {
+		t.Errorf("Unexpected spans: %+v", spans)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var request otlpTraces
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer("server", NewOTLPExporter(collector.URL), func(err error) {
+		t.Errorf("Export failed: %v", err)
+	})
+	_, span := tracer.Start(context.Background(), "apply", KindInternal)
+	span.SetError(errors.New("key not found"))
+	span.End()
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	if len(request.ResourceSpans) != 1 {
+		t.Fatalf("Unexpected request: %+v", request)
+	}
+	rs := request.ResourceSpans[0]
+	if rs.Resource.Attributes[0].Value.StringValue != "server" {
+		t.Errorf("Unexpected resource: %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].Name != "apply" || spans[0].Kind != 1 || spans[0].Status.Code != otlpStatusError {
+		t.Errorf("Unexpected spans: %+v", spans)
+	}
+}
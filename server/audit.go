@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/audit"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/orderedmap"
+)
+
+// ErrAudit is returned when a change cannot be written to the audit log.
+// The change is not applied.
+var ErrAudit = errors.New("audit log")
+
+// origin identifies the sender of a command for the audit log.
+type origin struct {
+	MessageID string
+	Client    string
+}
+
+type originKey struct{}
+
+func withOrigin(ctx context.Context, o origin) context.Context {
+	return context.WithValue(ctx, originKey{}, o)
+}
+
+func originFrom(ctx context.Context) origin {
+	o, _ := ctx.Value(originKey{}).(origin)
+	return o
+}
+
+// audit writes a record for every change of a transaction applied for
+// command. It is called while the map is still locked, so records are
+// chained in the order the transactions were applied, and before the
+// changes are published, so a change that cannot be audited is rolled
+// back. Replicas do not audit; their primary audited the changes.
+func (s *Server) audit(ctx context.Context, command types.Command, changes []orderedmap.Change, deleteOp changefeed.Op) error {
+	if s.auditLog == nil || len(changes) == 0 || s.replica.Load() {
+		return nil
+	}
+	o := originFrom(ctx)
+	now := time.Now()
+	records := make([]audit.Record, 0, len(changes))
+	for _, change := range changes {
+		records = append(records, audit.Record{
+			Time:      now,
+			MessageID: o.MessageID,
+			Client:    o.Client,
+			Command:   string(command.Type),
+			Op:        string(changeOp(change, deleteOp)),
+			Namespace: command.Namespace(),
+			Key:       change.Key,
+			OldHash:   audit.HashValue(change.Old),
+			NewHash:   audit.HashValue(change.New),
+		})
+	}
+	if err := s.auditLog.Append(records...); err != nil {
+		return fmt.Errorf("%w: %v", ErrAudit, err)
+	}
+	return nil
+}
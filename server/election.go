@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"command-queue/internal/util/election"
+)
+
+// Lead runs the server as one of several instances sharing its queue. Only
+// the instance holding the lease of elector consumes and applies commands;
+// the others are read-only and replicate the leader if it publishes the
+// address of its replication log. Writes and result files are fenced with
+// the token of the lease, so a leader that was replaced stops producing
+// them even before it notices. An instance that takes over a lease
+// without having replicated a previous leader logs an error: it serves
+// only the data it has.
+func (s *Server) Lead(ctx context.Context, elector *election.Elector) error {
+	s.elector = elector
+	s.replica.Store(true)
+	var followed, led atomic.Bool
+	return elector.Run(ctx, func(ctx context.Context, token uint64) error {
+		if token > 1 && !followed.Load() && !led.Load() {
+			s.log.Error("Took over the lease without replicating the previous leader; its data is not served", "token", token)
+		}
+		led.Store(true)
+		s.token.Store(token)
+		s.replicaMutex.Lock()
+		s.promote()
+		s.replicaMutex.Unlock()
+		defer s.replica.Store(true)
+		return s.Start(ctx)
+	}, func(lease election.Lease) {
+		if lease.Addr != "" {
+			followed.Store(true)
+			s.follow(ctx, lease.Addr)
+		}
+	})
+}
+
+// checkFence returns an error if the server was elected and no longer
+// holds the lease it was elected with.
+func (s *Server) checkFence() error {
+	if s.elector == nil {
+		return nil
+	}
+	return s.elector.Check(s.token.Load())
+}
+
+// fenced is the fencing check of a write, made with the map locked inside
+// the transaction that commits it, so that an error rolls the write back.
+// Replicas apply what their primary committed and are not fenced.
+func (s *Server) fenced() error {
+	if s.replica.Load() {
+		return nil
+	}
+	return s.checkFence()
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/orderedmap"
+)
+
+// execute applies command to the ordered map. Replicas only accept
+// read-only commands; their data changes through replication. Elected
+// servers only change data while they hold the lease, which run checks
+// when it commits.
+func (s *Server) execute(ctx context.Context, command types.Command) (Result, error) {
+	if !command.IsReadOnly() && s.replica.Load() {
+		return Result{}, ErrReadOnlyReplica
+	}
+	return s.run(ctx, command)
+}
+
+// run executes command without checking the role of the server. Scans read
+// a snapshot and do not block writers. Other read-only commands share the
+// read lock; everything else, including whole batches, runs under one write
+// lock so that no other command can observe a partially applied batch.
+// ctx carries the origin of the command for the audit log. Commands apply
+// to the map of their namespace; reads of a missing namespace see an empty
+// one and writes create it.
+func (s *Server) run(ctx context.Context, command types.Command) (Result, error) {
+	switch command.Type {
+	case types.ListNamespaces, types.CreateNamespace, types.DropNamespace, types.ClearNamespace:
+		return s.executeNamespaceCommand(ctx, command)
+	case types.Watch, types.WatchPrefix, types.Unwatch, types.UnwatchPrefix:
+		return s.executeWatchCommand(command)
+	}
+
+	ns, release, err := s.acquireNamespace(command.Namespace(), !command.IsReadOnly())
+	if err != nil {
+		return Result{}, err
+	}
+	defer release()
+	switch command.Type {
+	case types.GetAllItems, types.GetRange, types.GetPrefix:
+		return scan(ns.m.Snapshot(), command), nil
+	case types.OpenSnapshot, types.GetPage, types.CloseSnapshot:
+		return s.executeSnapshotCommand(ctx, ns.m, command)
+	case types.Expire:
+		return s.executeExpire(ns.m, command)
+	}
+
+	var result Result
+	fn := func(tx *orderedmap.Tx) error {
+		var err error
+		result, err = apply(tx, command)
+		return err
+	}
+	if command.IsReadOnly() {
+		return result, ns.m.View(fn)
+	}
+	return result, ns.m.Update(func(tx *orderedmap.Tx) error {
+		before := tx.Len()
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if err := s.checkQuota(ns, command.Namespace(), before, tx); err != nil {
+			return err
+		}
+		if err := s.fenced(); err != nil {
+			return err
+		}
+		if err := s.audit(ctx, command, tx.Changes(), changefeed.OpDelete); err != nil {
+			return err
+		}
+		if command.Type == types.Batch {
+			s.setDeadlines(command)
+		}
+		s.commit(command.Namespace(), tx.Changes(), changefeed.OpDelete)
+		s.record(command)
+		return nil
+	})
+}
+
+func apply(tx *orderedmap.Tx, command types.Command) (Result, error) {
+	result := Result{Command: command}
+	switch command.Type {
+	case types.AddItem:
+		return result, tx.Set(command.Key(), command.Value())
+	case types.DeleteItem:
+		_, err := tx.Delete(command.Key())
+		return result, err
+	case types.Increment, types.Decrement:
+		delta := command.Delta()
+		if command.Type == types.Decrement {
+			if delta == math.MinInt64 {
+				return Result{}, fmt.Errorf("key %q: %w", command.Key(), ErrOverflow)
+			}
+			delta = -delta
+		}
+		value, err := increment(tx, command.Key(), delta)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Items = []Item{{Key: command.Key(), Value: value}}
+	case types.Append, types.Prepend:
+		value, err := concat(tx, command)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Items = []Item{{Key: command.Key(), Value: value}}
+	case types.GetItem:
+		if val, ok := tx.Get(command.Key()); ok {
+			result.Items = []Item{{Key: command.Key(), Value: val}}
+		}
+	case types.InsertBefore:
+		return result, tx.InsertBefore(command.Pivot(), command.Key(), command.Value())
+	case types.InsertAfter:
+		return result, tx.InsertAfter(command.Pivot(), command.Key(), command.Value())
+	case types.MoveToFront:
+		return result, tx.MoveToFront(command.Key())
+	case types.MoveToBack:
+		return result, tx.MoveToBack(command.Key())
+	case types.GetFirst:
+		result.Items = item(tx.GetFirst())
+	case types.GetLast:
+		result.Items = item(tx.GetLast())
+	case types.GetAt:
+		result.Items = item(tx.GetAt(command.Index()))
+	case types.PopFirst:
+		key, value, ok, err := tx.PopFirst()
+		if err != nil {
+			return Result{}, err
+		}
+		result.Items = item(key, value, ok)
+	case types.PopLast:
+		key, value, ok, err := tx.PopLast()
+		if err != nil {
+			return Result{}, err
+		}
+		result.Items = item(key, value, ok)
+	case types.GetAllItems, types.GetRange, types.GetPrefix:
+		return scan(tx, command), nil
+	case types.Expire:
+		// The caller sets the deadline once the batch is applied.
+		if _, ok := tx.Get(command.Key()); !ok {
+			return Result{}, fmt.Errorf("key %q: %w", command.Key(), orderedmap.ErrKeyNotFound)
+		}
+	case types.Batch:
+		for i, step := range command.Commands() {
+			stepResult, err := apply(tx, step)
+			if err != nil {
+				return Result{}, fmt.Errorf("batch step %d (%s): %w", i+1, step, err)
+			}
+			result.Steps = append(result.Steps, stepResult)
+		}
+	default:
+		return Result{}, fmt.Errorf("unsupported command type %q", command.Type)
+	}
+	return result, nil
+}
+
+// scanner is implemented by orderedmap.Tx and orderedmap.Snapshot.
+type scanner interface {
+	GetAll() ([]string, []interface{})
+	GetRange(from, to string) ([]string, []interface{})
+	GetPrefix(prefix string) ([]string, []interface{})
+}
+
+func scan(sc scanner, command types.Command) Result {
+	result := Result{Command: command}
+	switch command.Type {
+	case types.GetAllItems:
+		result.Items = items(sc.GetAll())
+	case types.GetRange:
+		result.Items = items(sc.GetRange(command.Key(), command.RangeEnd()))
+	case types.GetPrefix:
+		result.Items = items(sc.GetPrefix(command.Key()))
+	}
+	return result
+}
+
+// executeSnapshotCommand opens, reads and closes snapshots that stay alive
+// between commands, so that paging through a large map sees one version.
+// A snapshot belongs to its namespace and to the client that opened it;
+// getPage and closeSnapshot of another namespace or client fail, except
+// that clients with the admin permission may close any snapshot.
+func (s *Server) executeSnapshotCommand(ctx context.Context, m orderedmap.Map, command types.Command) (Result, error) {
+	result := Result{Command: command}
+	owner := snapshotOwner(ctx, command)
+	switch command.Type {
+	case types.OpenSnapshot:
+		snapshot := m.Snapshot()
+		id := s.snapshots.Register(snapshot, owner)
+		result.Items = []Item{{Key: "snapshot", Value: id}, {Key: "items", Value: strconv.Itoa(snapshot.Len())}}
+	case types.GetPage:
+		snapshot, err := s.snapshots.Get(command.Key(), owner)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Items = items(snapshot.GetPage(command.Offset(), command.Limit()))
+	case types.CloseSnapshot:
+		err := s.snapshots.Release(command.Key(), owner)
+		if errors.Is(err, orderedmap.ErrSnapshotNotOwned) && s.isAdmin(owner.Client) {
+			if owner, err = s.snapshots.Owner(command.Key()); err == nil {
+				err = s.snapshots.Release(command.Key(), owner)
+			}
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// snapshotOwner returns the owner of the snapshots command opens or uses.
+func snapshotOwner(ctx context.Context, command types.Command) orderedmap.SnapshotOwner {
+	return orderedmap.SnapshotOwner{Namespace: command.Namespace(), Client: originFrom(ctx).Client}
+}
+
+// item wraps the result of a positional read; it is empty if nothing was found.
+func item(key string, value interface{}, ok bool) []Item {
+	if !ok {
+		return nil
+	}
+	return []Item{{Key: key, Value: value}}
+}
+
+// items pairs up the keys and values returned by a scan.
+func items(keys []string, values []interface{}) []Item {
+	result := make([]Item, 0, len(keys))
+	for i, key := range keys {
+		result = append(result, Item{Key: key, Value: values[i]})
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/orderedmap"
+)
+
+// expiryInterval is how often expired keys are removed.
+const expiryInterval = time.Second
+
+// deadlineKey is a key of a namespace with an expiry deadline.
+type deadlineKey struct {
+	namespace string
+	key       string
+}
+
+// executeExpire sets the time after which a key of m is removed. The
+// deadline is dropped when the key is deleted.
+func (s *Server) executeExpire(m orderedmap.Map, command types.Command) (Result, error) {
+	err := m.Update(func(tx *orderedmap.Tx) error {
+		if _, ok := tx.Get(command.Key()); !ok {
+			return fmt.Errorf("key %q: %w", command.Key(), orderedmap.ErrKeyNotFound)
+		}
+		if err := s.fenced(); err != nil {
+			return err
+		}
+		s.setDeadline(command.Namespace(), command.Key(), command.Seconds())
+		s.record(command)
+		return nil
+	})
+	return Result{Command: command}, err
+}
+
+// setDeadlines sets the deadlines of the expire steps of a batch. Like
+// executeExpire it is called with the map locked, once the batch is known
+// to be applied, and before its deletes clear their deadlines.
+func (s *Server) setDeadlines(command types.Command) {
+	for _, step := range command.Commands() {
+		if step.Type == types.Expire {
+			s.setDeadline(command.Namespace(), step.Key(), step.Seconds())
+		}
+	}
+}
+
+func (s *Server) setDeadline(namespace, key string, seconds int) {
+	s.setDeadlineAt(namespace, key, time.Now().Add(time.Duration(seconds)*time.Second))
+}
+
+func (s *Server) setDeadlineAt(namespace, key string, deadline time.Time) {
+	s.deadlineMutex.Lock()
+	defer s.deadlineMutex.Unlock()
+	s.deadlines[deadlineKey{namespace, key}] = deadline
+}
+
+// deadline returns the expiry deadline of a key, or nil if it has none.
+func (s *Server) deadline(namespace, key string) *time.Time {
+	s.deadlineMutex.Lock()
+	defer s.deadlineMutex.Unlock()
+	deadline, ok := s.deadlines[deadlineKey{namespace, key}]
+	if !ok {
+		return nil
+	}
+	return &deadline
+}
+
+// clearDeadlines drops the deadlines of every key of namespace.
+func (s *Server) clearDeadlines(namespace string) {
+	s.deadlineMutex.Lock()
+	defer s.deadlineMutex.Unlock()
+	for key := range s.deadlines {
+		if key.namespace == namespace {
+			delete(s.deadlines, key)
+		}
+	}
+}
+
+// expireLoop removes expired keys until ctx is done.
+func (s *Server) expireLoop(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.expireKeys(now)
+		}
+	}
+}
+
+// expireKeys removes the keys whose deadline is not after now and publishes
+// their removal as expire events. Replicas leave expiry to their primary,
+// which replicates the removals.
+func (s *Server) expireKeys(now time.Time) {
+	if s.replica.Load() || s.checkFence() != nil {
+		return
+	}
+	namespaces := make(map[string]bool)
+	for _, due := range s.dueKeys(now, nil) {
+		namespaces[due.namespace] = true
+	}
+	for name := range namespaces {
+		s.expireNamespace(name, now)
+	}
+}
+
+// expireNamespace removes the expired keys of the namespace name.
+func (s *Server) expireNamespace(name string, now time.Time) {
+	s.namespaceMutex.RLock()
+	defer s.namespaceMutex.RUnlock()
+	ns, ok := s.lookup(name)
+	if !ok {
+		// Dropping the namespace cleared its deadlines.
+		return
+	}
+	_ = ns.m.Update(func(tx *orderedmap.Tx) error {
+		// Deadlines may have changed while the map lock was not held.
+		keys := s.dueKeys(now, &name)
+		for _, key := range keys {
+			if _, err := tx.Delete(key.key); err != nil {
+				return err
+			}
+		}
+		if err := s.fenced(); err != nil {
+			return err
+		}
+		ctx := withOrigin(context.Background(), origin{Client: "expiry"})
+		expire := types.Command{Type: types.Expire}.WithNamespace(name)
+		if err := s.audit(ctx, expire, tx.Changes(), changefeed.OpExpire); err != nil {
+			s.log.Error("Error auditing expired keys", logger.KeyError, err)
+			return err
+		}
+		for _, key := range keys {
+			s.record(types.NewDeleteCommand(key.key).WithNamespace(name))
+		}
+		s.commit(name, tx.Changes(), changefeed.OpExpire)
+		return nil
+	})
+}
+
+// dueKeys returns the keys whose deadline is not after now, of every
+// namespace or, if namespace is not nil, of that namespace only.
+func (s *Server) dueKeys(now time.Time, namespace *string) []deadlineKey {
+	s.deadlineMutex.Lock()
+	defer s.deadlineMutex.Unlock()
+
+	var due []deadlineKey
+	for key, deadline := range s.deadlines {
+		if !now.Before(deadline) && (namespace == nil || key.namespace == *namespace) {
+			due = append(due, key)
+		}
+	}
+	return due
+}
+
+func (s *Server) clearDeadline(namespace, key string) {
+	s.deadlineMutex.Lock()
+	defer s.deadlineMutex.Unlock()
+	delete(s.deadlines, deadlineKey{namespace, key})
+}
@@ -0,0 +1,473 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/election"
+	"command-queue/internal/util/orderedmap"
+	"command-queue/internal/util/policy"
+	"command-queue/internal/util/queue"
+	"command-queue/internal/util/tracing"
+)
+
+const (
+	// defaultPageLimit and maxPageLimit bound the page size of GET /items.
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+	// maxCommandSize is the largest body accepted by POST /commands.
+	maxCommandSize = 1 << 20
+)
+
+// ServeAdmin serves the HTTP admin and query API on ln until ctx is done.
+func (s *Server) ServeAdmin(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.AdminHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	})
+	defer stop()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// AdminHandler returns the handler of the HTTP API:
+//
+//	GET    /items/{key}?namespace=                    value of one key
+//	GET    /items?offset=&limit=&snapshot=&namespace= one page of all items
+//	DELETE /items?snapshot=                           release a snapshot
+//	GET    /stats                                     counters of the server
+//	POST   /commands?mode=apply|enqueue               run or enqueue one command
+//	GET    /healthz                                   liveness
+//	GET    /readyz                                    readiness
+//	GET    /metrics                                   metrics in the Prometheus text format
+//
+// Commands go through the same path as queue messages: they are verified,
+// authorized, deduplicated, executed, traced and counted in the metrics.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", s.handleItems)
+	mux.HandleFunc("/items/", s.handleItem)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/commands", s.handleCommands)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.Handle("/metrics", s.metrics.registry.Handler())
+	return mux
+}
+
+type itemJSON struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type resultJSON struct {
+	Command string       `json:"command"`
+	Items   []itemJSON   `json:"items,omitempty"`
+	Steps   []resultJSON `json:"steps,omitempty"`
+}
+
+type pageJSON struct {
+	Items    []itemJSON `json:"items"`
+	Snapshot string     `json:"snapshot"`
+	Offset   int        `json:"offset"`
+	Limit    int        `json:"limit"`
+	Total    int        `json:"total"`
+	Next     *int       `json:"next,omitempty"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleItem(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/items/")
+	if key == "" {
+		writeJSON(w, http.StatusNotFound, errorJSON{Error: "missing key"})
+		return
+	}
+	namespace, ok := namespaceParam(w, r)
+	if !ok {
+		return
+	}
+	command := types.NewGetCommand(key).WithNamespace(namespace)
+	ctx, ok := s.admitRead(w, r, command)
+	if !ok {
+		return
+	}
+	result, err := s.execute(ctx, command)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(result.Items) == 0 {
+		writeJSON(w, http.StatusNotFound, errorJSON{Error: "key " + strconv.Quote(key) + " not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, toItemsJSON(result.Items)[0])
+}
+
+// handleItems returns a page of a snapshot. Without a snapshot parameter a
+// new snapshot is opened; passing its ID back pages through the same
+// version of the map. The snapshot is released once its last page was
+// read. A caller that stops paging earlier must release it with DELETE,
+// or it is kept until it expires.
+func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	query := r.URL.Query()
+	if r.Method == http.MethodDelete {
+		id := query.Get("snapshot")
+		if id == "" {
+			writeJSON(w, http.StatusBadRequest, errorJSON{Error: "missing snapshot"})
+			return
+		}
+		namespace, ok := namespaceParam(w, r)
+		if !ok {
+			return
+		}
+		command := types.NewCloseSnapshotCommand(id).WithNamespace(namespace)
+		ctx, ok := s.admitRead(w, r, command)
+		if !ok {
+			return
+		}
+		if _, err := s.execute(ctx, command); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"snapshot": id})
+		return
+	}
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: "invalid offset"})
+		return
+	}
+	limit, err := intParam(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: "limit must be between 1 and " + strconv.Itoa(maxPageLimit)})
+		return
+	}
+
+	namespace, ok := namespaceParam(w, r)
+	if !ok {
+		return
+	}
+	// Every page is authorized as a read of all items.
+	ctx, ok := s.admitRead(w, r, types.NewGetAllCommand().WithNamespace(namespace))
+	if !ok {
+		return
+	}
+
+	id := query.Get("snapshot")
+	if id == "" {
+		result, err := s.execute(ctx, types.NewOpenSnapshotCommand().WithNamespace(namespace))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		id = result.Items[0].Value.(string)
+	}
+	// The snapshot must be one of the namespace the read was authorized for.
+	getPage := types.NewGetPageCommand(id, offset, limit).WithNamespace(namespace)
+	result, err := s.execute(ctx, getPage)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	snapshot, err := s.snapshots.Get(id, snapshotOwner(ctx, getPage))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page := pageJSON{
+		Items:    toItemsJSON(result.Items),
+		Snapshot: id,
+		Offset:   offset,
+		Limit:    limit,
+		Total:    snapshot.Len(),
+	}
+	if next := offset + limit; next < page.Total {
+		page.Next = &next
+	} else {
+		// The snapshot may have expired meanwhile.
+		_, _ = s.execute(ctx, types.NewCloseSnapshotCommand(id).WithNamespace(namespace))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Stats())
+}
+
+// handleCommands runs the command in the request body and returns its
+// result, or with mode=enqueue sends it to the server's queue. Commands are
+// verified, authorized and deduplicated like queue messages, with the
+// headers of a message sent as HTTP headers.
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCommandSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: err.Error()})
+		return
+	}
+	message := requestMessage(r, strings.TrimSpace(string(body)))
+	command, err := types.ParseCommand(message.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: err.Error()})
+		return
+	}
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "apply":
+		attribute(r, message)
+		ctx, span := s.tracer.Start(tracing.Extract(r.Context(), message.Headers), "http", tracing.KindServer)
+		defer span.End()
+		_, result, err := s.handle(ctx, span, message, s.log, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, toResultJSON(result))
+	case "enqueue":
+		// The message keeps its headers, so the server verifies it when it
+		// is consumed.
+		if err := queue.Send(s.queue, message.Body, message.Headers); err != nil {
+			writeJSON(w, http.StatusBadGateway, errorJSON{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"command": command.String()})
+	default:
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: "mode must be apply or enqueue"})
+	}
+}
+
+// handleReady reports whether the server serves commands: it consumes its
+// queue, or it is a replica that received its primary's data.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	stats := s.Stats()
+	status := http.StatusServiceUnavailable
+	if stats.Ready {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, map[string]interface{}{"ready": stats.Ready, "role": stats.Role})
+}
+
+// Stats is a summary of the state of a server.
+type Stats struct {
+	Role           string `json:"role"`
+	Ready          bool   `json:"ready"`
+	Items          int    `json:"items"`
+	Processed      uint64 `json:"processed"`
+	Failed         uint64 `json:"failed"`
+	ActiveWorkers  int    `json:"activeWorkers"`
+	MaxWorkers     int    `json:"maxWorkers"`
+	Snapshots      int    `json:"snapshots"`
+	Namespaces     int    `json:"namespaces"`
+	Watches        int    `json:"watches"`
+	FeedSeq        uint64 `json:"feedSeq"`
+	ReplicationSeq uint64 `json:"replicationSeq"`
+	LeaseToken     uint64 `json:"leaseToken,omitempty"`
+}
+
+// Stats returns a summary of the state of the server.
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Role:          "primary",
+		Items:         s.itemCount(),
+		Processed:     s.processed.Load(),
+		Failed:        s.failed.Load(),
+		ActiveWorkers: len(s.semaphore),
+		MaxWorkers:    cap(s.semaphore),
+		Snapshots:     s.snapshots.Len(),
+		FeedSeq:       s.feed.Seq(),
+	}
+	s.watchMutex.Lock()
+	stats.Watches = len(s.watches)
+	s.watchMutex.Unlock()
+	s.namespaceMutex.RLock()
+	stats.Namespaces = len(s.namespaces)
+	s.namespaceMutex.RUnlock()
+
+	s.replicaMutex.Lock()
+	defer s.replicaMutex.Unlock()
+	switch {
+	case s.replica.Load():
+		stats.Role = "replica"
+		if s.follower != nil {
+			stats.ReplicationSeq = s.follower.Seq()
+			stats.Ready = s.follower.Synced()
+		}
+	default:
+		if s.oplog != nil {
+			stats.ReplicationSeq = s.oplog.Seq()
+		}
+		stats.Ready = s.running.Load()
+	}
+	if s.elector != nil {
+		stats.LeaseToken = s.token.Load()
+		if s.replica.Load() {
+			stats.Role = "standby"
+		}
+	}
+	return stats
+}
+
+// messageHeaders are the headers of a queue message that HTTP requests
+// carry as HTTP headers.
+var messageHeaders = []string{
+	queue.ClientIDHeader,
+	queue.NamespaceHeader,
+	queue.IdempotencyKeyHeader,
+	auth.KeyIDHeader,
+	auth.SignatureHeader,
+	auth.TimestampHeader,
+	auth.NonceHeader,
+	tracing.TraceparentHeader,
+}
+
+// requestMessage returns the queue message of the command in body sent
+// with r.
+func requestMessage(r *http.Request, body string) queue.Message {
+	message := queue.Message{ID: r.Header.Get("X-Request-Id"), Body: body, Headers: make(map[string]string)}
+	for _, name := range messageHeaders {
+		if value := r.Header.Get(name); value != "" {
+			message.Headers[name] = value
+		}
+	}
+	return message
+}
+
+// attribute attributes message to the remote host of r, unless it names a
+// client. The port is left out, so that requests of one host over several
+// connections, such as the pages of a snapshot, have the same client.
+func attribute(r *http.Request, message queue.Message) {
+	if message.Headers[queue.ClientIDHeader] == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		message.Headers[queue.ClientIDHeader] = "http:" + host
+	}
+}
+
+// admitRead verifies and authorizes a read of the HTTP API like a message
+// of the read-only command it stands for, and writes the error if it is
+// not admitted. A signed read signs the text of command. The returned
+// context carries the origin of the read.
+func (s *Server) admitRead(w http.ResponseWriter, r *http.Request, command types.Command) (context.Context, bool) {
+	message := requestMessage(r, command.String())
+	attribute(r, message)
+	ctx, span := s.tracer.Start(tracing.Extract(r.Context(), message.Headers), "http", tracing.KindServer)
+	defer span.End()
+	ctx, _, _, err := s.admit(ctx, span, message, s.log, nil)
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+	return ctx, true
+}
+
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, errorJSON{Error: "method not allowed"})
+	return false
+}
+
+// namespaceParam returns the namespace named by the query of r, or writes
+// an error if it is invalid.
+func namespaceParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	namespace := r.URL.Query().Get("namespace")
+	if namespace != "" && !types.IsNamespace(namespace) {
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: "invalid namespace"})
+		return "", false
+	}
+	return namespace, true
+}
+
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps errors of command execution to status codes.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusUnprocessableEntity
+	switch {
+	case errors.Is(err, ErrInvalidCommand):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrUnauthenticated):
+		status = http.StatusUnauthorized
+	case errors.Is(err, policy.ErrDenied), errors.Is(err, orderedmap.ErrSnapshotNotOwned):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrDuplicate):
+		status = http.StatusConflict
+	case errors.Is(err, orderedmap.ErrKeyNotFound), errors.Is(err, orderedmap.ErrSnapshotNotFound), errors.Is(err, ErrNamespaceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrReadOnlyReplica), errors.Is(err, election.ErrLeaseLost):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrAudit):
+		status = http.StatusInternalServerError
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrTooManyNamespaces):
+		status = http.StatusInsufficientStorage
+	}
+	writeJSON(w, status, errorJSON{Error: err.Error()})
+}
+
+func toItemsJSON(items []Item) []itemJSON {
+	result := make([]itemJSON, 0, len(items))
+	for _, item := range items {
+		result = append(result, itemJSON{Key: item.Key, Value: item.Value})
+	}
+	return result
+}
+
+func toResultJSON(result Result) resultJSON {
+	r := resultJSON{Command: result.Command.String()}
+	if len(result.Items) > 0 {
+		r.Items = toItemsJSON(result.Items)
+	}
+	for _, step := range result.Steps {
+		r.Steps = append(r.Steps, toResultJSON(step))
+	}
+	return r
+}
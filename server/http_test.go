@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/policy"
+	"command-queue/internal/util/queue"
+)
+
+func getJSON(t *testing.T, h http.Handler, target string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v))
+	return rec.Code
+}
+
+func TestAdminHandler_Items(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := server.execute(context.Background(), types.NewAddCommand(key, "v"+key))
+		assert.Nil(t, err)
+	}
+	h := server.AdminHandler()
+
+	var item itemJSON
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/items/b", &item))
+	assert.Equal(t, itemJSON{Key: "b", Value: "vb"}, item)
+	var errResp errorJSON
+	assert.Equal(t, http.StatusNotFound, getJSON(t, h, "/items/x", &errResp))
+
+	var page pageJSON
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/items?limit=2", &page))
+	assert.Equal(t, []itemJSON{{Key: "a", Value: "va"}, {Key: "b", Value: "vb"}}, page.Items)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 2, *page.Next)
+
+	// The next page comes from the same snapshot.
+	_, err := server.execute(context.Background(), types.NewDeleteCommand("c"))
+	assert.Nil(t, err)
+	var next pageJSON
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/items?limit=2&offset=2&snapshot="+page.Snapshot, &next))
+	assert.Equal(t, []itemJSON{{Key: "c", Value: "vc"}}, next.Items)
+	assert.Nil(t, next.Next)
+
+	// Reading the last page releases the snapshot, as does DELETE.
+	assert.Equal(t, 0, server.snapshots.Len())
+	var all pageJSON
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/items?limit=10", &all))
+	assert.Nil(t, all.Next)
+	assert.Equal(t, 0, server.snapshots.Len())
+	var first pageJSON
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/items?limit=1", &first))
+	assert.Equal(t, 1, server.snapshots.Len())
+	// A snapshot cannot be read as one of another namespace.
+	_, err = server.execute(context.Background(), types.NewAddCommand("o", "vo").WithNamespace("orders"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, getJSON(t, h, "/items?namespace=orders&snapshot="+first.Snapshot, &errResp))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/items?snapshot="+first.Snapshot, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 0, server.snapshots.Len())
+
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, h, "/items?limit=0", &errResp))
+	assert.Equal(t, http.StatusNotFound, getJSON(t, h, "/items?snapshot=unknown", &errResp))
+}
+
+func TestAdminHandler_CloseSnapshot(t *testing.T) {
+	p, err := policy.New(policy.File{Rules: []policy.Rule{
+		{Clients: []string{"ops"}, Commands: []string{policy.Admin, "closeSnapshot"}},
+		{Commands: []string{"getAllItems", "closeSnapshot"}},
+	}})
+	assert.Nil(t, err)
+	server := NewServer(nil, logger.NewConsoleLogger(), 1, WithPolicy(p))
+	for _, command := range []types.Command{types.NewAddCommand("a", "1"), types.NewAddCommand("b", "2"), types.NewAddCommand("o", "3").WithNamespace("orders")} {
+		_, err := server.execute(context.Background(), command)
+		assert.Nil(t, err)
+	}
+	h := server.AdminHandler()
+	request := func(method, target, client string, v interface{}) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set(queue.ClientIDHeader, client)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v))
+		return rec.Code
+	}
+
+	var page pageJSON
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/items?limit=1", "reporting", &page))
+	var errResp errorJSON
+	// Only the owner, in the snapshot's namespace, or an admin closes it.
+	assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/items?snapshot="+page.Snapshot, "other", &errResp))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/items?namespace=orders&snapshot="+page.Snapshot, "reporting", &errResp))
+	assert.Equal(t, 1, server.snapshots.Len())
+	var closed map[string]string
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/items?snapshot="+page.Snapshot, "ops", &closed))
+	assert.Equal(t, 0, server.snapshots.Len())
+}
+
+func TestAdminHandler_Commands(t *testing.T) {
+	memQ := queue.NewMemQueue(10)
+	server := NewServer(memQ, logger.NewConsoleLogger(), 1)
+	h := server.AdminHandler()
+	post := func(target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post("/commands", "addItem('a', '1')")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = post("/commands", "batch([increment('a', 2), getItem('a')])")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result resultJSON
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []itemJSON{{Key: "a", Value: "3"}}, result.Steps[1].Items)
+
+	assert.Equal(t, http.StatusBadRequest, post("/commands", "nope()").Code)
+	assert.Equal(t, http.StatusNotFound, post("/commands", "moveToFront('x')").Code)
+	assert.Equal(t, http.StatusOK, post("/commands", "addItem('b', 'x')\n").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/commands", "increment('b')").Code)
+
+	rec = post("/commands?mode=enqueue", "deleteItem('a')")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	messages, _ := memQ.ReceiveMessage()
+	assert.Equal(t, "deleteItem('a')", <-messages)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/commands", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAdminHandler_CommandsSigned(t *testing.T) {
+	key, err := auth.GenerateKey("k1", "billing", auth.HMACSHA256)
+	assert.Nil(t, err)
+	keys, err := auth.NewKeyring(key)
+	assert.Nil(t, err)
+	signer, err := auth.NewSigner(keys, "")
+	assert.Nil(t, err)
+	server := NewServer(nil, logger.NewConsoleLogger(), 1, WithVerifier(auth.NewVerifier(keys, auth.DefaultWindow)))
+	h := server.AdminHandler()
+	post := func(body string, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/commands", strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	signed := func(body string) map[string]string {
+		headers := map[string]string{queue.IdempotencyKeyHeader: "i1"}
+		assert.Nil(t, signer.Sign(body, headers))
+		return headers
+	}
+
+	// Commands are verified and deduplicated like queue messages.
+	assert.Equal(t, http.StatusUnauthorized, post("addItem('a', '1')", nil))
+	assert.Equal(t, http.StatusOK, post("addItem('a', '1')", signed("addItem('a', '1')")))
+	assert.Equal(t, http.StatusConflict, post("addItem('a', '1')", signed("addItem('a', '1')")))
+	assert.Equal(t, 1, server.orderedMap.Len())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`commandqueue_server_commands_total{type="addItem",result="ok"} 1`,
+		`commandqueue_server_commands_total{type="addItem",result="duplicate"} 1`,
+		`commandqueue_server_errors_total{reason="auth"} 1`,
+	} {
+		assert.Contains(t, rec.Body.String(), line+"\n")
+	}
+}
+
+func TestAdminHandler_Policy(t *testing.T) {
+	p, err := policy.New(policy.File{Rules: []policy.Rule{
+		{Clients: []string{"reporting"}, Commands: []string{"getItem"}},
+		{Clients: []string{"teamA"}, Keys: []string{"teamA:*"}},
+	}})
+	assert.Nil(t, err)
+	server := NewServer(nil, logger.NewConsoleLogger(), 1, WithPolicy(p))
+	h := server.AdminHandler()
+	request := func(method, target, body, client string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if client != "" {
+			req.Header.Set(queue.ClientIDHeader, client)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Reads and commands are authorized like queue messages.
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/commands", "addItem('teamA:1', 'a')", "teamA"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/commands", "addItem('teamB:1', 'b')", "teamA"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/commands", "addItem('teamA:2', 'b')", ""))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/items/teamA:1", "", "reporting"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/items", "", "reporting"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/items/teamA:1", "", ""))
+	assert.Equal(t, 1, server.orderedMap.Len())
+}
+
+func TestAdminHandler_Health(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 4, WithReplicaOf("127.0.0.1:1"))
+	h := server.AdminHandler()
+
+	var health map[string]string
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/healthz", &health))
+	var ready map[string]interface{}
+	assert.Equal(t, http.StatusServiceUnavailable, getJSON(t, h, "/readyz", &ready))
+	assert.Equal(t, "replica", ready["role"])
+
+	var stats Stats
+	assert.Equal(t, http.StatusOK, getJSON(t, h, "/stats", &stats))
+	assert.Equal(t, 4, stats.MaxWorkers)
+	assert.Equal(t, 0, stats.Items)
+}
+
+func TestAdminHandler_Metrics(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 2)
+	server.processCommand("addItem('a', '1')")
+	server.processCommand("addItem('b', '2')")
+	server.processCommand("addItem@orders('c', '3')")
+	server.processCommand("increment('a', 'x')") // invalid delta
+	server.processCommand("moveToBack('x')")
+	server.processCommand("nope")
+
+	rec := httptest.NewRecorder()
+	server.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	for _, line := range []string{
+		`commandqueue_server_commands_total{type="addItem",result="ok"} 3`,
+		`commandqueue_server_commands_total{type="unknown",result="error"} 2`,
+		`commandqueue_server_errors_total{reason="parse"} 2`,
+		`commandqueue_server_errors_total{reason="key_not_found"} 1`,
+		`commandqueue_server_processing_seconds_count{type="addItem"} 3`,
+		`commandqueue_server_workers_max 2`,
+		`commandqueue_server_map_items 3`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
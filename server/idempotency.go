@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate is returned for a message whose idempotency key was applied
+// within the window, or is being applied.
+var ErrDuplicate = errors.New("duplicate message")
+
+// defaultIdempotencyWindow is how long the server remembers the idempotency
+// keys of applied messages.
+const defaultIdempotencyWindow = 10 * time.Minute
+
+// idempotencyKeys remembers the idempotency keys of the messages being
+// applied and of those applied within the window. A key is claimed before
+// its message is executed, so a duplicate processed in parallel is skipped
+// too, and released if the command fails, so that it can be retried.
+type idempotencyKeys struct {
+	mu     sync.Mutex
+	window time.Duration
+	// keys maps a key to the time its message was applied, or to the zero
+	// time while it is being applied.
+	keys   map[string]time.Time
+	pruned time.Time
+	now    func() time.Time
+}
+
+func newIdempotencyKeys(window time.Duration) *idempotencyKeys {
+	return &idempotencyKeys{window: window, keys: make(map[string]time.Time), now: time.Now}
+}
+
+// claim returns false if key is being applied or was applied within the
+// window.
+func (k *idempotencyKeys) claim(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.now()
+	k.prune(now)
+	if applied, ok := k.keys[key]; ok && (applied.IsZero() || now.Sub(applied) < k.window) {
+		return false
+	}
+	k.keys[key] = time.Time{}
+	return true
+}
+
+// done marks the message of key as applied, or forgets key if applying it
+// failed.
+func (k *idempotencyKeys) done(key string, applied bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if applied {
+		k.keys[key] = k.now()
+	} else {
+		delete(k.keys, key)
+	}
+}
+
+// prune forgets the keys that left the window, at most once per window.
+// The caller must hold mu.
+func (k *idempotencyKeys) prune(now time.Time) {
+	if now.Sub(k.pruned) < k.window {
+		return
+	}
+	for key, applied := range k.keys {
+		if !applied.IsZero() && now.Sub(applied) >= k.window {
+			delete(k.keys, key)
+		}
+	}
+	k.pruned = now
+}
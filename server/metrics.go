@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+
+	"command-queue/internal/util/election"
+	"command-queue/internal/util/metrics"
+	"command-queue/internal/util/orderedmap"
+)
+
+// serverMetrics are the metrics a server exposes on /metrics.
+type serverMetrics struct {
+	registry   *metrics.Registry
+	commands   *metrics.CounterVec
+	errors     *metrics.CounterVec
+	processing *metrics.HistogramVec
+	wait       *metrics.Histogram
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		commands: r.NewCounterVec("commandqueue_server_commands_total",
+			"Commands received from the queue or the HTTP API by type and result.", "type", "result"),
+		errors: r.NewCounterVec("commandqueue_server_errors_total",
+			"Commands that failed by reason.", "reason"),
+		processing: r.NewHistogramVec("commandqueue_server_processing_seconds",
+			"Time to verify, parse, authorize and execute a command.", metrics.DefBuckets, "type"),
+		wait: r.NewHistogram("commandqueue_server_worker_wait_seconds",
+			"Time a received message waited for a free worker.", metrics.DefBuckets),
+	}
+	r.NewGaugeFunc("commandqueue_server_workers_busy", "Workers processing a command.", func() float64 {
+		return float64(len(s.semaphore))
+	})
+	r.NewGaugeFunc("commandqueue_server_workers_max", "Maximum number of workers.", func() float64 {
+		return float64(cap(s.semaphore))
+	})
+	r.NewGaugeFunc("commandqueue_server_map_items", "Items in the maps of all namespaces.", func() float64 {
+		return float64(s.itemCount())
+	})
+	return m
+}
+
+// Metrics returns the registry of the server's metrics.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
+
+// errorReason classifies an error of command processing for metrics.
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, orderedmap.ErrKeyNotFound):
+		return "key_not_found"
+	case errors.Is(err, orderedmap.ErrKeyExists):
+		return "key_exists"
+	case errors.Is(err, orderedmap.ErrNotPositional):
+		return "not_positional"
+	case errors.Is(err, orderedmap.ErrSnapshotNotFound):
+		return "snapshot_not_found"
+	case errors.Is(err, orderedmap.ErrSnapshotNotOwned):
+		return "snapshot_not_owned"
+	case errors.Is(err, ErrNotNumeric), errors.Is(err, ErrNotString):
+		return "wrong_type"
+	case errors.Is(err, ErrOverflow):
+		return "overflow"
+	case errors.Is(err, ErrReadOnlyReplica):
+		return "read_only_replica"
+	case errors.Is(err, election.ErrLeaseLost):
+		return "lease_lost"
+	case errors.Is(err, ErrNoFeedQueue), errors.Is(err, ErrNotWatched):
+		return "watch"
+	case errors.Is(err, ErrAudit):
+		return "audit"
+	case errors.Is(err, ErrNamespaceNotFound):
+		return "namespace_not_found"
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrTooManyNamespaces):
+		return "quota"
+	}
+	return "other"
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/orderedmap"
+)
+
+var (
+	// ErrNotNumeric is returned when incrementing a value that is not an integer.
+	ErrNotNumeric = errors.New("value is not numeric")
+	// ErrNotString is returned when appending to a value that is not a string.
+	ErrNotString = errors.New("value is not a string")
+	// ErrOverflow is returned when an increment leaves the int64 range.
+	ErrOverflow = errors.New("integer overflow")
+)
+
+// increment adds delta to the integer stored under key. A missing key counts
+// as zero. Numbers are stored as strings, like every other value.
+func increment(tx *orderedmap.Tx, key string, delta int64) (string, error) {
+	var current int64
+	if value, ok := tx.Get(key); ok {
+		var err error
+		if current, err = toInt(value); err != nil {
+			return "", fmt.Errorf("key %q: %w", key, err)
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return "", fmt.Errorf("key %q: %w", key, ErrOverflow)
+	}
+	next := strconv.FormatInt(current+delta, 10)
+	return next, tx.Set(key, next)
+}
+
+// concat appends or prepends s to the string stored under key. A missing key
+// counts as the empty string.
+func concat(tx *orderedmap.Tx, command types.Command) (string, error) {
+	var current string
+	if value, ok := tx.Get(command.Key()); ok {
+		if current, ok = value.(string); !ok {
+			return "", fmt.Errorf("key %q: %w", command.Key(), ErrNotString)
+		}
+	}
+	next := current + command.Value()
+	if command.Type == types.Prepend {
+		next = command.Value() + current
+	}
+	return next, tx.Set(command.Key(), next)
+}
+
+func toInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrNotNumeric, v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("%w: %v", ErrNotNumeric, value)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/orderedmap"
+)
+
+var (
+	// ErrNamespaceNotFound is returned when dropping or clearing a namespace
+	// that does not exist.
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	// ErrTooManyNamespaces is returned when creating a namespace beyond the
+	// limit set with WithNamespaceLimits.
+	ErrTooManyNamespaces = errors.New("too many namespaces")
+	// ErrQuotaExceeded is returned for commands that would store more items
+	// in a namespace than its quota.
+	ErrQuotaExceeded = errors.New("namespace quota exceeded")
+)
+
+// namespace is a named map of items, independent of the default map.
+type namespace struct {
+	m     orderedmap.Map
+	quota int
+}
+
+// lookup returns the namespace name, or the default namespace for "". The
+// caller must hold namespaceMutex.
+func (s *Server) lookup(name string) (*namespace, bool) {
+	if name == "" {
+		return &namespace{m: s.orderedMap}, true
+	}
+	ns, ok := s.namespaces[name]
+	return ns, ok
+}
+
+// acquireNamespace returns the namespace name with namespaceMutex read
+// locked until release is called, so that it is not dropped while in use.
+// A missing namespace is created if create is set; otherwise an empty one
+// is returned that is not kept.
+func (s *Server) acquireNamespace(name string, create bool) (ns *namespace, release func(), err error) {
+	for {
+		s.namespaceMutex.RLock()
+		if ns, ok := s.lookup(name); ok {
+			return ns, s.namespaceMutex.RUnlock, nil
+		}
+		s.namespaceMutex.RUnlock()
+		if !create {
+			return &namespace{m: orderedmap.NewOrderedMap()}, func() {}, nil
+		}
+		s.namespaceMutex.Lock()
+		_, err := s.createNamespace(name)
+		s.namespaceMutex.Unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// createNamespace returns the namespace name, creating it with the default
+// quota if it does not exist. The caller must hold namespaceMutex.
+func (s *Server) createNamespace(name string) (*namespace, error) {
+	if ns, ok := s.lookup(name); ok {
+		return ns, nil
+	}
+	if s.maxNamespaces > 0 && len(s.namespaces) >= s.maxNamespaces {
+		return nil, fmt.Errorf("namespace %q: %w (limit %d)", name, ErrTooManyNamespaces, s.maxNamespaces)
+	}
+	ns := &namespace{m: s.newMap(), quota: s.namespaceQuota}
+	s.namespaces[name] = ns
+	s.log.Info("Created namespace", logger.KeyNamespace, name)
+	return ns, nil
+}
+
+// executeNamespaceCommand lists, creates, drops and clears namespaces.
+// Dropping and clearing delete the items of the namespace like deleteItem
+// commands would, so the deletes are audited and published.
+func (s *Server) executeNamespaceCommand(ctx context.Context, command types.Command) (Result, error) {
+	result := Result{Command: command}
+	name := command.Namespace()
+	switch command.Type {
+	case types.ListNamespaces:
+		s.namespaceMutex.RLock()
+		defer s.namespaceMutex.RUnlock()
+		for _, n := range s.namespaceNames() {
+			result.Items = append(result.Items, Item{Key: n, Value: strconv.Itoa(s.namespaces[n].m.Len())})
+		}
+		return result, nil
+	case types.CreateNamespace:
+		s.namespaceMutex.Lock()
+		defer s.namespaceMutex.Unlock()
+		if err := s.fenced(); err != nil {
+			return Result{}, err
+		}
+		ns, err := s.createNamespace(name)
+		if err != nil {
+			return Result{}, err
+		}
+		if quota, ok := command.Quota(); ok {
+			ns.quota = quota
+		}
+		s.record(command)
+		return result, nil
+	}
+
+	s.namespaceMutex.Lock()
+	defer s.namespaceMutex.Unlock()
+	ns, ok := s.namespaces[name]
+	if !ok {
+		return Result{}, fmt.Errorf("%q: %w", name, ErrNamespaceNotFound)
+	}
+	err := ns.m.Update(func(tx *orderedmap.Tx) error {
+		keys, _ := tx.GetAll()
+		for _, key := range keys {
+			if _, err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		if err := s.fenced(); err != nil {
+			return err
+		}
+		if err := s.audit(ctx, command, tx.Changes(), changefeed.OpDelete); err != nil {
+			return err
+		}
+		s.commit(name, tx.Changes(), changefeed.OpDelete)
+		s.record(command)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if command.Type == types.DropNamespace {
+		delete(s.namespaces, name)
+		s.log.Info("Dropped namespace", logger.KeyNamespace, name)
+	}
+	return result, nil
+}
+
+// itemCount returns the number of items in all namespaces.
+func (s *Server) itemCount() int {
+	s.namespaceMutex.RLock()
+	defer s.namespaceMutex.RUnlock()
+	n := s.orderedMap.Len()
+	for _, ns := range s.namespaces {
+		n += ns.m.Len()
+	}
+	return n
+}
+
+// namespaceNames returns the names of the namespaces in lexical order. The
+// caller must hold namespaceMutex.
+func (s *Server) namespaceNames() []string {
+	names := make([]string, 0, len(s.namespaces))
+	for name := range s.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkQuota fails a transaction that leaves more items in ns than its
+// quota. Transactions that do not add items pass, so a namespace whose
+// quota was lowered can still be cleaned up. Replicas apply what their
+// primary accepted.
+func (s *Server) checkQuota(ns *namespace, name string, before int, tx *orderedmap.Tx) error {
+	if ns.quota == 0 || s.replica.Load() || tx.Len() <= ns.quota || tx.Len() <= before {
+		return nil
+	}
+	return fmt.Errorf("namespace %q holds at most %d items: %w", name, ns.quota, ErrQuotaExceeded)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"time"
+
+	"command-queue/internal/util/audit"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/orderedmap"
+	"command-queue/internal/util/policy"
+	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+	"command-queue/internal/util/tracing"
+)
+
+// Option configures optional behaviour of a Server.
+type Option func(*Server)
+
+// WithMap makes the server store its items in m instead of a new
+// insertion ordered map.
+func WithMap(m orderedmap.Map) Option {
+	return func(s *Server) {
+		s.orderedMap = m
+	}
+}
+
+// WithNamespaceMaps makes the server store the items of every named
+// namespace in a map returned by newMap instead of a new insertion ordered
+// map.
+func WithNamespaceMaps(newMap func() orderedmap.Map) Option {
+	return func(s *Server) {
+		s.newMap = newMap
+	}
+}
+
+// WithNamespaceLimits limits the number of named namespaces to
+// maxNamespaces and the number of items of a new namespace to quota, unless
+// createNamespace sets another quota. 0 leaves them unlimited.
+func WithNamespaceLimits(maxNamespaces, quota int) Option {
+	return func(s *Server) {
+		s.maxNamespaces = maxNamespaces
+		s.namespaceQuota = quota
+	}
+}
+
+// WithIdempotencyWindow sets how long the server remembers the idempotency
+// keys of applied messages. 0 applies every message.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(s *Server) {
+		s.idempotency = nil
+		if window > 0 {
+			s.idempotency = newIdempotencyKeys(window)
+		}
+	}
+}
+
+// WithSnapshotTTL sets how long a snapshot opened by openSnapshot stays
+// readable without being used.
+func WithSnapshotTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.snapshots = orderedmap.NewSnapshotRegistry(ttl)
+	}
+}
+
+// WithChangeFeed makes watches send change events to q.
+func WithChangeFeed(q queue.Queue) Option {
+	return func(s *Server) {
+		s.feedQueue = q
+	}
+}
+
+// WithFeedRetention sets how many change events are kept for watches that
+// resume from a sequence number.
+func WithFeedRetention(events int) Option {
+	return func(s *Server) {
+		s.feed = changefeed.New(events)
+	}
+}
+
+// WithReplicationLog records the commands that change data in a log of the
+// given number of entries, which replicas follow through ServeReplication.
+func WithReplicationLog(entries int) Option {
+	return func(s *Server) {
+		s.oplog = replication.NewLog(entries)
+	}
+}
+
+// WithReplicaOf makes the server a read-only replica of the primary whose
+// replication log is served on addr. It follows the primary once started
+// and until it is promoted.
+func WithReplicaOf(addr string) Option {
+	return func(s *Server) {
+		s.primaryAddr = addr
+		s.replica.Store(true)
+	}
+}
+
+// WithTracer traces the processing of every message with t.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(s *Server) {
+		s.tracer = t
+	}
+}
+
+// WithAuditLog writes every change of the map, with the message and client
+// that caused it, to l.
+func WithAuditLog(l *audit.Log) Option {
+	return func(s *Server) {
+		s.auditLog = l
+	}
+}
+
+// WithVerifier makes the server reject messages that are not signed by a
+// key of v, or that were already processed. The client that signed a
+// message is recorded in the audit log.
+func WithVerifier(v *auth.Verifier) Option {
+	return func(s *Server) {
+		s.verifier = v
+	}
+}
+
+// WithPolicy makes the server run only the commands of queued messages
+// that p allows to their client. Denied commands are audited and sent to
+// the dead-letter queue.
+func WithPolicy(p *policy.Policy) Option {
+	return func(s *Server) {
+		s.policy = p
+	}
+}
+
+// WithDeadLetter sends messages that were rejected or denied to q.
+func WithDeadLetter(q queue.Queue) Option {
+	return func(s *Server) {
+		s.deadLetterQueue = q
+	}
+}
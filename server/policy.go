@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/audit"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/policy"
+	"command-queue/internal/util/queue"
+)
+
+// DeadLetterReasonHeader is the header of a dead-lettered message that says
+// why it was not processed.
+const DeadLetterReasonHeader = "dead-letter-reason"
+
+// authorize checks command of client against the policy. A batch is
+// allowed if every step is.
+func (s *Server) authorize(client string, command types.Command) error {
+	if s.policy == nil {
+		return nil
+	}
+	if command.Type == types.Batch {
+		for _, step := range command.Commands() {
+			if err := s.authorize(client, step.WithNamespace(command.Namespace())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.policy.Authorize(policy.Request{
+		Client:    client,
+		Command:   string(command.Type),
+		Namespace: command.Namespace(),
+		Keys:      commandKeys(command),
+	})
+}
+
+// isAdmin reports whether the policy grants client the admin permission.
+// Without a policy no client has it.
+func (s *Server) isAdmin(client string) bool {
+	return s.policy != nil && s.policy.IsAdmin(client)
+}
+
+// commandKeys returns the keys command reads or writes, or nil for
+// commands on the whole map.
+func commandKeys(command types.Command) []string {
+	if !command.HasKey() {
+		return nil
+	}
+	keys := []string{command.Key()}
+	if command.Type == types.InsertBefore || command.Type == types.InsertAfter {
+		keys = append(keys, command.Pivot())
+	}
+	return keys
+}
+
+// auditDenied records a command the policy denied in the audit log, once
+// for every key it names.
+func (s *Server) auditDenied(ctx context.Context, command types.Command) {
+	if s.auditLog == nil {
+		return
+	}
+	o := originFrom(ctx)
+	now := time.Now()
+	var records []audit.Record
+	steps := []types.Command{command}
+	if command.Type == types.Batch {
+		steps = command.Commands()
+	}
+	for _, step := range steps {
+		keys := commandKeys(step)
+		if keys == nil {
+			keys = []string{""}
+		}
+		for _, key := range keys {
+			records = append(records, audit.Record{
+				Time:      now,
+				MessageID: o.MessageID,
+				Client:    o.Client,
+				Command:   string(step.Type),
+				Op:        "denied",
+				Namespace: command.Namespace(),
+				Key:       key,
+			})
+		}
+	}
+	if err := s.auditLog.Append(records...); err != nil {
+		s.log.Error("Error auditing denied command", logger.KeyError, err)
+	}
+}
+
+// deadLetter sends a message that was not processed to the dead-letter
+// queue, with the reason in its headers.
+func (s *Server) deadLetter(message queue.Message, reason error, log logger.Logger) {
+	if s.deadLetterQueue == nil {
+		return
+	}
+	headers := make(map[string]string, len(message.Headers)+1)
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+	headers[DeadLetterReasonHeader] = reason.Error()
+	if err := queue.Send(s.deadLetterQueue, message.Body, headers); err != nil {
+		log.Error("Error sending message to the dead-letter queue", logger.KeyError, err)
+	}
+}
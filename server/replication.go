@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/orderedmap"
+	"command-queue/internal/util/replication"
+)
+
+// defaultReplicationRetention is the number of log entries kept for
+// replicas that reconnect.
+const defaultReplicationRetention = 10000
+
+var (
+	// ErrReadOnlyReplica is returned for commands that change data on a replica.
+	ErrReadOnlyReplica = errors.New("replica only accepts read-only commands")
+	// ErrNotReplica is returned when promoting a server that is not a replica.
+	ErrNotReplica = errors.New("server is not a replica")
+	// ErrNoReplicationLog is returned when serving replicas without a replication log.
+	ErrNoReplicationLog = errors.New("no replication log configured")
+)
+
+// ServeReplication streams the replication log to replicas connecting on ln
+// until ctx is done.
+func (s *Server) ServeReplication(ctx context.Context, ln net.Listener) error {
+	if s.oplog == nil {
+		return ErrNoReplicationLog
+	}
+	return replication.NewPrimary(s.oplog, s.replicationSnapshot, s.log).Serve(ctx, ln)
+}
+
+// IsReplica reports whether the server follows a primary.
+func (s *Server) IsReplica() bool {
+	return s.replica.Load()
+}
+
+// Promote stops following the primary and makes the server accept writes.
+// Its replication log starts over with a new ID, so replicas of the old
+// primary can follow it after a full resync.
+func (s *Server) Promote() error {
+	s.replicaMutex.Lock()
+	defer s.replicaMutex.Unlock()
+	if !s.replica.Load() {
+		return ErrNotReplica
+	}
+	var seq uint64
+	if s.follower != nil {
+		seq = s.follower.Seq()
+	}
+	s.promote()
+	s.log.Info("Promoted to primary", "seq", seq)
+	return nil
+}
+
+// promote makes the server a primary. The caller must hold replicaMutex.
+func (s *Server) promote() {
+	s.stopFollowing()
+	if s.oplog != nil {
+		s.oplog.Reset()
+	}
+	s.replica.Store(false)
+}
+
+// startReplica follows the primary set with WithReplicaOf until ctx is done
+// or the server is promoted.
+func (s *Server) startReplica(ctx context.Context) {
+	if s.primaryAddr == "" || !s.replica.Load() {
+		return
+	}
+	s.follow(ctx, s.primaryAddr)
+}
+
+// follow makes the server a read-only replica of the primary serving its
+// log on addr, replacing the primary it followed before.
+func (s *Server) follow(ctx context.Context, addr string) {
+	s.replicaMutex.Lock()
+	defer s.replicaMutex.Unlock()
+	if s.stopReplica != nil && s.follower.Addr() == addr {
+		return
+	}
+	s.stopFollowing()
+	s.replica.Store(true)
+	s.follower = replication.NewReplica(addr, replicaApplier{s}, s.log)
+	ctx, s.stopReplica = context.WithCancel(ctx)
+	s.replicaDone = make(chan struct{})
+	go func(follower *replication.Replica, done chan struct{}) {
+		defer close(done)
+		follower.Run(ctx)
+	}(s.follower, s.replicaDone)
+}
+
+// stopFollowing stops replicating and waits until the last entry was
+// applied. The caller must hold replicaMutex.
+func (s *Server) stopFollowing() {
+	if s.stopReplica == nil {
+		return
+	}
+	s.stopReplica()
+	<-s.replicaDone
+	s.stopReplica = nil
+}
+
+// record adds a command that changed data to the replication log. It is
+// called while the map is still locked, so entries are in the order the
+// commands were applied.
+func (s *Server) record(command types.Command) {
+	if s.oplog == nil || s.replica.Load() {
+		return
+	}
+	s.oplog.Append(command.String())
+}
+
+// replicationSnapshot returns the items of every namespace with their
+// expiry deadlines, those of the default namespace first, together with the
+// last log entry they include.
+// The maps are all read locked at once, so no command is applied between
+// reading two of them.
+func (s *Server) replicationSnapshot() ([]replication.Item, uint64) {
+	s.namespaceMutex.RLock()
+	defer s.namespaceMutex.RUnlock()
+	names := append([]string{""}, s.namespaceNames()...)
+	var items []replication.Item
+	var seq uint64
+	var view func(i int) error
+	view = func(i int) error {
+		if i == len(names) {
+			seq = s.oplog.Seq()
+			return nil
+		}
+		ns, _ := s.lookup(names[i])
+		return ns.m.View(func(tx *orderedmap.Tx) error {
+			keys, values := tx.GetAll()
+			for j, key := range keys {
+				items = append(items, replication.Item{Namespace: names[i], Key: key, Value: values[j], Expires: s.deadline(names[i], key)})
+			}
+			return view(i + 1)
+		})
+	}
+	_ = view(0)
+	return items, seq
+}
+
+// replicaApplier applies what a replica receives to the server's map.
+type replicaApplier struct {
+	s *Server
+}
+
+// ApplySnapshot replaces the items of every namespace and their expiry
+// deadlines. Namespaces without items in the snapshot are dropped.
+func (a replicaApplier) ApplySnapshot(items []replication.Item) error {
+	namespaces := map[string][]replication.Item{"": nil}
+	for _, item := range items {
+		namespaces[item.Namespace] = append(namespaces[item.Namespace], item)
+	}
+	a.s.namespaceMutex.Lock()
+	defer a.s.namespaceMutex.Unlock()
+	for name := range a.s.namespaces {
+		if _, ok := namespaces[name]; !ok {
+			namespaces[name] = nil
+		}
+	}
+	for name, items := range namespaces {
+		ns, err := a.s.createNamespace(name)
+		if err != nil {
+			return err
+		}
+		err = ns.m.Update(func(tx *orderedmap.Tx) error {
+			keys, _ := tx.GetAll()
+			for _, key := range keys {
+				if _, err := tx.Delete(key); err != nil {
+					return err
+				}
+			}
+			for _, item := range items {
+				if err := tx.Set(item.Key, item.Value); err != nil {
+					return err
+				}
+			}
+			a.s.commit(name, tx.Changes(), changefeed.OpDelete)
+			a.s.clearDeadlines(name)
+			for _, item := range items {
+				if item.Expires != nil {
+					a.s.setDeadlineAt(name, item.Key, *item.Expires)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if name != "" && len(items) == 0 {
+			delete(a.s.namespaces, name)
+		}
+	}
+	return nil
+}
+
+func (a replicaApplier) ApplyEntry(command string) error {
+	parsed, err := types.ParseCommand(command)
+	if err != nil {
+		return err
+	}
+	_, err = a.s.run(context.Background(), parsed)
+	return err
+}
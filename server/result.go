@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"command-queue/internal/types"
+)
+
+// Item is a key/value pair returned by a command.
+type Item struct {
+	Key   string
+	Value interface{}
+}
+
+// Result is the outcome of executing a command.
+type Result struct {
+	Command types.Command
+	// Items holds the pairs returned by read commands.
+	Items []Item
+	// Steps holds the per-step results of a batch, in order.
+	Steps []Result
+}
+
+// String formats the result the way it is written to result files.
+func (r Result) String() string {
+	var sb strings.Builder
+	if r.Command.Type == types.Batch {
+		for i, step := range r.Steps {
+			sb.WriteString(fmt.Sprintf("%d %s\n", i+1, step.Command))
+			sb.WriteString(step.String())
+		}
+		return sb.String()
+	}
+	for _, item := range r.Items {
+		sb.WriteString(fmt.Sprintf("%s : %s\n", item.Key, item.Value))
+	}
+	return sb.String()
+}
@@ -2,113 +2,392 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"command-queue/internal/types"
+	"command-queue/internal/util/audit"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/election"
 	"command-queue/internal/util/logger"
 	"command-queue/internal/util/orderedmap"
+	"command-queue/internal/util/policy"
 	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+	"command-queue/internal/util/tracing"
 )
 
+var (
+	// ErrUnauthenticated is returned for messages whose signature is
+	// rejected.
+	ErrUnauthenticated = errors.New("message not authenticated")
+	// ErrInvalidCommand is returned for messages that do not parse.
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+// defaultSnapshotTTL is how long a snapshot opened by openSnapshot stays
+// readable without being used.
+const defaultSnapshotTTL = 5 * time.Minute
+
 // Server implements the Server interface.
 type Server struct {
 	queue      queue.Queue
-	orderedMap orderedmap.OrderedMap
-	fileMutex  sync.Mutex
-	log        logger.Logger
-	semaphore  chan interface{}
-	cnt        atomic.Uint64
+	orderedMap orderedmap.Map
+	// namespaces holds the maps of the named namespaces; orderedMap is the
+	// default namespace.
+	namespaces     map[string]*namespace
+	namespaceMutex sync.RWMutex
+	newMap         func() orderedmap.Map
+	maxNamespaces  int
+	namespaceQuota int
+	fileMutex      sync.Mutex
+	log            logger.Logger
+	semaphore      chan interface{}
+	workers        chan int
+	received       atomic.Uint64
+	cnt            atomic.Uint64
+	snapshots      *orderedmap.SnapshotRegistry
+	processed      atomic.Uint64
+	failed         atomic.Uint64
+	running        atomic.Bool
+	metrics        *serverMetrics
+
+	feed          *changefeed.Feed
+	feedQueue     queue.Queue
+	watches       map[changefeed.Filter]*changefeed.Subscription
+	watchMutex    sync.Mutex
+	stopped       chan struct{}
+	stopOnce      sync.Once
+	deadlines     map[deadlineKey]time.Time
+	deadlineMutex sync.Mutex
+
+	auditLog *audit.Log
+
+	oplog        *replication.Log
+	primaryAddr  string
+	follower     *replication.Replica
+	replica      atomic.Bool
+	replicaMutex sync.Mutex
+	stopReplica  context.CancelFunc
+	replicaDone  chan struct{}
+
+	elector       *election.Elector
+	token         atomic.Uint64
+	messages      <-chan queue.Message
+	rawMessages   <-chan string
+	messagesMutex sync.Mutex
+	tracer        *tracing.Tracer
+	verifier      *auth.Verifier
+
+	policy          *policy.Policy
+	deadLetterQueue queue.Queue
+	idempotency     *idempotencyKeys
 }
 
 // NewServer creates a new instance of Server.
-func NewServer(q queue.Queue, log logger.Logger, maxWorkers int) *Server {
-	return &Server{
+func NewServer(q queue.Queue, log logger.Logger, maxWorkers int, opts ...Option) *Server {
+	s := &Server{
 		queue:      q,
 		orderedMap: orderedmap.NewOrderedMap(),
+		namespaces: make(map[string]*namespace),
+		newMap:     func() orderedmap.Map { return orderedmap.NewOrderedMap() },
 		fileMutex:  sync.Mutex{},
-		log:        log,
+		log:        log.Component("server"),
 		semaphore:  make(chan interface{}, maxWorkers),
+		workers:    make(chan int, maxWorkers),
 		cnt:        atomic.Uint64{},
+		snapshots:  orderedmap.NewSnapshotRegistry(defaultSnapshotTTL),
+		feed:       changefeed.New(defaultFeedRetention),
+		watches:    make(map[changefeed.Filter]*changefeed.Subscription),
+		stopped:    make(chan struct{}),
+		deadlines:  make(map[deadlineKey]time.Time),
+
+		idempotency: newIdempotencyKeys(defaultIdempotencyWindow),
+	}
+	for i := 0; i < maxWorkers; i++ {
+		s.workers <- i
 	}
+	s.metrics = newServerMetrics(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Start starts the server, allowing it to read messages from the queue and process commands.
 func (s *Server) Start(ctx context.Context) error {
 	// Start reading messages from the queue in a separate goroutine.
-	messages, err := s.queue.ReceiveMessage()
+	messages, rawMessages, err := s.receive()
 	if err != nil {
 		return err
 	}
+	s.running.Store(true)
+	defer s.running.Store(false)
+	go s.expireLoop(ctx)
+	s.startReplica(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case raw, ok := <-rawMessages:
+			if !ok {
+				return nil
+			}
+			s.dispatch(queue.Unwrap(raw))
 		case message, ok := <-messages:
 			if !ok {
 				return nil
 			}
-			// Acquire a semaphore slot
-			s.semaphore <- struct{}{}
-
-			// Launch a new goroutine to process the message concurrently
-			go func(msg string) {
-				defer func() {
-					// Release the semaphore slot when the goroutine completes
-					<-s.semaphore
-				}()
-				s.processCommand(msg)
-			}(message)
+			s.dispatch(message)
 		}
 	}
 }
 
+// dispatch processes message on a worker, waiting for a free one.
+func (s *Server) dispatch(message queue.Message) {
+	// Acquire a semaphore slot
+	received := time.Now()
+	s.semaphore <- struct{}{}
+	worker := <-s.workers
+	s.metrics.wait.Observe(time.Since(received).Seconds())
+
+	// Launch a new goroutine to process the message concurrently
+	go func() {
+		defer func() {
+			// Release the semaphore slot when the goroutine completes
+			s.workers <- worker
+			<-s.semaphore
+		}()
+		s.processMessage(message, s.log.With(logger.KeyWorker, worker))
+	}()
+}
+
+// receive returns the messages of the queue, on the first channel if the
+// queue carries headers and on the second otherwise. The channels are only
+// created once, so a server that is started again, for example when it is
+// elected leader again, keeps consuming the same messages. Plain messages
+// are not read ahead, so a server that stopped leading does not hold on to
+// them.
+func (s *Server) receive() (<-chan queue.Message, <-chan string, error) {
+	s.messagesMutex.Lock()
+	defer s.messagesMutex.Unlock()
+	if s.messages != nil || s.rawMessages != nil {
+		return s.messages, s.rawMessages, nil
+	}
+	var err error
+	if hq, ok := s.queue.(queue.HeaderQueue); ok {
+		s.messages, err = hq.ReceiveMessages()
+	} else {
+		s.rawMessages, err = s.queue.ReceiveMessage()
+	}
+	return s.messages, s.rawMessages, err
+}
+
 // Stop stops the server, preventing it from reading messages and processing commands.
 func (s *Server) Stop() error {
-	// Server has stopped successfully.
+	s.stopOnce.Do(func() {
+		close(s.stopped)
+		s.closeWatches()
+		s.replicaMutex.Lock()
+		s.stopFollowing()
+		s.replicaMutex.Unlock()
+	})
 	return nil
 }
 
 func (s *Server) processCommand(message string) {
-	command, err := types.ParseCommand(message)
+	s.processMessage(queue.Message{Body: message}, s.log)
+}
+
+// messageID returns the broker's ID of message, or a sequence number of the
+// messages received by the server if the broker does not assign IDs.
+func (s *Server) messageID(message queue.Message) string {
+	if message.ID != "" {
+		return message.ID
+	}
+	return strconv.FormatUint(s.received.Add(1), 10)
+}
+
+// processMessage parses, executes and writes the result of one message. The
+// work is traced as a child of the span context carried by the message.
+// Messages that fail verification or authorization are dead-lettered.
+func (s *Server) processMessage(message queue.Message, log logger.Logger) {
+	ctx, span := s.tracer.Start(tracing.Extract(context.Background(), message.Headers), "process", tracing.KindConsumer)
+	defer span.End()
+	command, result, err := s.handle(ctx, span, message, log, func(err error, log logger.Logger) {
+		s.deadLetter(message, err, log)
+	})
 	if err != nil {
-		s.log.Printf("Error parsing command: %v\n", err)
 		return
 	}
+	_, writeSpan := s.tracer.Start(ctx, "write", tracing.KindInternal)
+	s.writeResult(command, result)
+	writeSpan.End()
+}
+
+// handle verifies, parses, authorizes and executes message, which comes
+// from the queue or the HTTP API, and records the outcome in span, the
+// log and the metrics. Messages that fail verification or authorization
+// are passed to reject, if it is not nil. Duplicates of a message that was
+// applied return ErrDuplicate.
+func (s *Server) handle(ctx context.Context, span *tracing.Span, message queue.Message, log logger.Logger, reject func(error, logger.Logger)) (types.Command, Result, error) {
+	start := time.Now()
+	ctx, command, log, err := s.admit(ctx, span, message, log, reject)
+	if err != nil {
+		return types.Command{}, Result{}, err
+	}
+	defer func() {
+		s.metrics.processing.With(string(command.Type)).Observe(time.Since(start).Seconds())
+	}()
+
+	// Idempotency keys are scoped to the client, so that clients cannot
+	// make the server skip each other's messages.
+	idempotencyKey := message.Headers[queue.IdempotencyKeyHeader]
+	if idempotencyKey != "" && s.idempotency != nil {
+		idempotencyKey = originFrom(ctx).Client + "\n" + idempotencyKey
+		if !s.idempotency.claim(idempotencyKey) {
+			log.Info("Skipped duplicate message", logger.KeyIdempotencyKey, message.Headers[queue.IdempotencyKeyHeader])
+			s.metrics.commands.With(string(command.Type), "duplicate").Inc()
+			return command, Result{}, ErrDuplicate
+		}
+	}
+
+	_, applySpan := s.tracer.Start(ctx, "apply", tracing.KindInternal)
+	result, err := s.execute(ctx, command)
+	applySpan.SetError(err)
+	applySpan.End()
+	if idempotencyKey != "" && s.idempotency != nil {
+		s.idempotency.done(idempotencyKey, err == nil)
+	}
+	if err != nil {
+		log.Warn("Error executing command", logger.KeyError, err)
+		span.SetError(err)
+		s.failed.Add(1)
+		s.metrics.commands.With(string(command.Type), "error").Inc()
+		s.metrics.errors.With(errorReason(err)).Inc()
+		return command, Result{}, err
+	}
+	s.processed.Add(1)
+	s.metrics.commands.With(string(command.Type), "ok").Inc()
+	log.Debug("Processed command", "duration", time.Since(start))
+	return command, result, nil
+}
+
+// admit verifies, parses and authorizes message. It returns ctx with the
+// origin of the message and log with its fields.
+func (s *Server) admit(ctx context.Context, span *tracing.Span, message queue.Message, log logger.Logger, reject func(error, logger.Logger)) (context.Context, types.Command, logger.Logger, error) {
+	id := s.messageID(message)
+	log = log.With(logger.KeyMessageID, id)
+
+	// Without a verifier the client ID is taken on trust.
+	client := message.Headers[queue.ClientIDHeader]
+	if s.verifier != nil {
+		var err error
+		if client, err = s.verifier.Verify(message); err != nil {
+			err = fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+			log.Warn("Rejected message", logger.KeyClient, message.Headers[queue.ClientIDHeader], logger.KeyError, err)
+			if reject != nil {
+				reject(err, log)
+			}
+			span.SetError(err)
+			s.failed.Add(1)
+			s.metrics.commands.With("unknown", "error").Inc()
+			s.metrics.errors.With("auth").Inc()
+			return ctx, types.Command{}, log, err
+		}
+	}
+	if client != "" {
+		log = log.With(logger.KeyClient, client)
+	}
+	ctx = withOrigin(ctx, origin{MessageID: id, Client: client})
+
+	_, parseSpan := s.tracer.Start(ctx, "parse", tracing.KindInternal)
+	command, err := types.ParseCommand(message.Body)
+	if ns := message.Headers[queue.NamespaceHeader]; err == nil && ns != "" && command.Namespace() == "" {
+		if !types.IsNamespace(ns) {
+			err = fmt.Errorf("invalid namespace header %q", ns)
+		}
+		command = command.WithNamespace(ns)
+	}
+	parseSpan.SetError(err)
+	parseSpan.End()
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidCommand, err)
+		log.Warn("Error parsing command", logger.KeyError, err)
+		span.SetError(err)
+		s.failed.Add(1)
+		s.metrics.commands.With("unknown", "error").Inc()
+		s.metrics.errors.With("parse").Inc()
+		return ctx, types.Command{}, log, err
+	}
+	span.SetAttribute("command.type", string(command.Type))
+	log = log.With(logger.KeyCommandType, string(command.Type))
+	if command.Namespace() != "" {
+		log = log.With(logger.KeyNamespace, command.Namespace())
+	}
+	if command.HasKey() {
+		log = log.With(logger.KeyKey, command.Key())
+	}
+
+	if err := s.authorize(client, command); err != nil {
+		log.Warn("Denied command", logger.KeyError, err)
+		s.auditDenied(ctx, command)
+		if reject != nil {
+			reject(err, log)
+		}
+		span.SetError(err)
+		s.failed.Add(1)
+		s.metrics.commands.With(string(command.Type), "error").Inc()
+		s.metrics.errors.With("denied").Inc()
+		return ctx, types.Command{}, log, err
+	}
+	return ctx, command, log, nil
+}
+
+// writeResult writes the result of a read to a result file.
+func (s *Server) writeResult(command types.Command, result Result) {
 	switch command.Type {
-	case types.AddItem:
-		s.orderedMap.Set(command.Key(), command.Value())
-	case types.DeleteItem:
-		s.orderedMap.DeleteItem(command.Key())
 	case types.GetItem:
-		val, ok := s.orderedMap.Get(command.Key())
-		if ok {
-			s.writeToFile(command.Key(), fmt.Sprintf("%s : %s\n", command.Key(), val))
+		if len(result.Items) > 0 {
+			s.writeToFile(command.Key(), result.String())
 		}
 	case types.GetAllItems:
-		keys, values := s.orderedMap.GetAll()
-		result := ""
-		for i, key := range keys {
-			result += fmt.Sprintf("%s : %s\n", key, values[i])
+		s.writeToFile("allItems", result.String())
+	case types.GetFirst, types.GetLast, types.GetAt, types.PopFirst, types.PopLast:
+		if len(result.Items) > 0 {
+			s.writeToFile(string(command.Type), result.String())
 		}
-		s.writeToFile("allItems", result)
+	case types.GetRange, types.GetPrefix, types.OpenSnapshot, types.GetPage:
+		s.writeToFile(string(command.Type), result.String())
+	case types.Batch:
+		s.writeToFile("batch", result.String())
+	case types.ListNamespaces:
+		s.writeToFile("namespaces", result.String())
 	}
 }
 
 func (s *Server) writeToFile(filename, content string) {
+	if err := s.checkFence(); err != nil {
+		s.log.Warn("Dropping result", "file", filename, logger.KeyError, err)
+		return
+	}
 	// read the counter value and increment it
 	index := s.cnt.Add(1)
 	filename = fmt.Sprintf("%s_%d", filename, index)
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
-		s.log.Printf("Error opening file: %v\n", err)
+		s.log.Error("Error opening result file", "file", filename, logger.KeyError, err)
 		return
 	}
 	defer file.Close()
 
 	if _, err := file.WriteString(content); err != nil {
-		s.log.Printf("Error writing to file: %v\n", err)
+		s.log.Error("Error writing result file", "file", filename, logger.KeyError, err)
 	}
 }
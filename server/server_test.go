@@ -3,15 +3,30 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"command-queue/internal/types"
 
 	"github.com/stretchr/testify/assert"
 
+	"command-queue/internal/util/audit"
+	"command-queue/internal/util/auth"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/election"
 	"command-queue/internal/util/logger"
+	"command-queue/internal/util/orderedmap"
+	"command-queue/internal/util/policy"
 	"command-queue/internal/util/queue"
+	"command-queue/internal/util/replication"
+	"command-queue/internal/util/tracing"
 )
 
 func TestServer_Start(t *testing.T) {
@@ -52,13 +67,23 @@ func TestServer_Start(t *testing.T) {
 	cancel()
 }
 
+// inTempDir runs the rest of the test in a temporary working directory, so
+// that the result files the server writes do not end up in the package.
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Error changing working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
 func TestProcessCommand(t *testing.T) {
+	inTempDir(t)
 	server := NewServer(nil, logger.NewConsoleLogger(), 1)
 
-	// delete test files if they exist
-	os.Remove("key2_1")
-	os.Remove("allItems_2")
-
 	tests := []struct {
 		name    string
 		message string
@@ -82,10 +107,789 @@ func TestProcessCommand(t *testing.T) {
 	bt, err := os.ReadFile("key2_1")
 	assert.Nilf(t, err, "Error reading file: %v", err)
 	assert.Equal(t, "key2 : value2\n", string(bt))
-	os.Remove("key2_1")
 
 	bt, err = os.ReadFile("allItems_2")
 	assert.Nilf(t, err, "Error reading file: %v", err)
 	assert.Equal(t, "key2 : value2\n", string(bt))
-	os.Remove("allItems_2")
+}
+
+func TestProcessCommand_Batch(t *testing.T) {
+	inTempDir(t)
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+
+	server.processCommand(types.NewAddCommand("from", "value").String())
+	server.processCommand("batch([addItem('to', 'value'), deleteItem('from'), getItem('to')])")
+
+	keys, values := server.orderedMap.GetAll()
+	assert.Equal(t, []string{"to"}, keys)
+	assert.Equal(t, []interface{}{"value"}, values)
+
+	bt, err := os.ReadFile("batch_1")
+	assert.Nilf(t, err, "Error reading file: %v", err)
+	assert.Equal(t, "1 addItem('to', 'value')\n2 deleteItem('from')\n3 getItem('to')\nto : value\n", string(bt))
+}
+
+func TestExecute_ExpireInBatch(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+
+	// A failed batch sets no deadline.
+	_, err := server.execute(context.Background(), mustParse(t, "batch([addItem('a', '1'), expire('a', 10), expire('b', 10)])"))
+	assert.ErrorIs(t, err, orderedmap.ErrKeyNotFound)
+	assert.Empty(t, server.deadlines)
+
+	_, err = server.execute(context.Background(), mustParse(t, "batch([addItem('a', '1'), expire('a', 10), addItem('b', '2'), expire('b', 10), deleteItem('b')])"))
+	assert.Nil(t, err)
+	assert.Len(t, server.deadlines, 1)
+	server.expireKeys(time.Now().Add(10 * time.Second))
+	assert.Equal(t, 0, server.orderedMap.Len())
+}
+
+func TestProcessMessage_Idempotency(t *testing.T) {
+	server := NewServer(nil, logger.NewTestLogger(), 1, WithIdempotencyWindow(time.Minute))
+	now := time.Now()
+	server.idempotency.now = func() time.Time { return now }
+	send := func(body, client, key string) {
+		headers := map[string]string{queue.ClientIDHeader: client, queue.IdempotencyKeyHeader: key}
+		server.processMessage(queue.Message{Body: body, Headers: headers}, server.log)
+	}
+
+	send("increment('n')", "a", "k1")
+	send("increment('n')", "a", "k1")
+	send("increment('n')", "b", "k1")
+	value, _ := server.orderedMap.Get("n")
+	assert.Equal(t, "2", value)
+
+	// A failed command can be retried with its key.
+	send("insertAfter('p', 'x', '1')", "a", "k2")
+	send("addItem('p', '0')", "a", "k3")
+	send("insertAfter('p', 'x', '1')", "a", "k2")
+	assert.Equal(t, uint64(1), server.failed.Load())
+	_, ok := server.orderedMap.Get("x")
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+	send("increment('n')", "a", "k1")
+	value, _ = server.orderedMap.Get("n")
+	assert.Equal(t, "3", value)
+
+	var sb strings.Builder
+	assert.Nil(t, server.Metrics().WriteText(&sb))
+	assert.Contains(t, sb.String(), `commandqueue_server_commands_total{type="increment",result="duplicate"} 1`+"\n")
+}
+
+func mustParse(t *testing.T, message string) types.Command {
+	t.Helper()
+	command, err := types.ParseCommand(message)
+	assert.Nil(t, err)
+	return command
+}
+
+func TestExecute_Mutations(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+
+	tests := []struct {
+		name     string
+		command  types.Command
+		expected string
+		err      error
+	}{
+		{name: "IncrementMissing", command: types.NewIncrementCommand("counter", 5), expected: "5"},
+		{name: "Increment", command: types.NewIncrementCommand("counter", 2), expected: "7"},
+		{name: "Decrement", command: types.NewDecrementCommand("counter", 10), expected: "-3"},
+		{name: "AppendMissing", command: types.NewAppendCommand("name", "world"), expected: "world"},
+		{name: "Prepend", command: types.NewPrependCommand("name", "hello "), expected: "hello world"},
+		{name: "IncrementNotNumeric", command: types.NewIncrementCommand("name", 1), err: ErrNotNumeric},
+		{name: "IncrementOverflow", command: types.NewIncrementCommand("counter", math.MinInt64), err: ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := server.execute(context.Background(), tt.command)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, []Item{{Key: tt.command.Key(), Value: tt.expected}}, result.Items)
+		})
+	}
+
+	// A failing step rolls back the whole batch.
+	command, err := types.ParseCommand("batch([increment('counter'), increment('name')])")
+	assert.Nil(t, err)
+	_, err = server.execute(context.Background(), command)
+	assert.ErrorIs(t, err, ErrNotNumeric)
+	value, _ := server.orderedMap.Get("counter")
+	assert.Equal(t, "-3", value)
+}
+
+func TestExecute_Positional(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+	for _, message := range []string{
+		"addItem('b', '2')",
+		"addItem('d', '4')",
+		"insertBefore('d', 'c', '3')",
+		"insertAfter('d', 'e', '5')",
+		"insertBefore('b', 'a', '1')",
+		"moveToFront('e')",
+		"moveToBack('a')",
+	} {
+		command, err := types.ParseCommand(message)
+		assert.Nil(t, err)
+		_, err = server.execute(context.Background(), command)
+		assert.Nilf(t, err, "%s returned an error: %v", message, err)
+	}
+	keys, _ := server.orderedMap.GetAll()
+	assert.Equal(t, []string{"e", "b", "c", "d", "a"}, keys)
+
+	tests := []struct {
+		command  types.Command
+		expected []Item
+	}{
+		{command: types.NewGetFirstCommand(), expected: []Item{{Key: "e", Value: "5"}}},
+		{command: types.NewGetLastCommand(), expected: []Item{{Key: "a", Value: "1"}}},
+		{command: types.NewGetAtCommand(2), expected: []Item{{Key: "c", Value: "3"}}},
+		{command: types.NewGetAtCommand(10), expected: nil},
+		{command: types.NewPopFirstCommand(), expected: []Item{{Key: "e", Value: "5"}}},
+		{command: types.NewPopLastCommand(), expected: []Item{{Key: "a", Value: "1"}}},
+	}
+	for _, tt := range tests {
+		result, err := server.execute(context.Background(), tt.command)
+		assert.Nil(t, err)
+		assert.Equalf(t, tt.expected, result.Items, "%s", tt.command)
+	}
+	keys, _ = server.orderedMap.GetAll()
+	assert.Equal(t, []string{"b", "c", "d"}, keys)
+
+	_, err := server.execute(context.Background(), types.NewMoveToFrontCommand("missing"))
+	assert.ErrorIs(t, err, orderedmap.ErrKeyNotFound)
+}
+
+func TestExecute_SortedMap(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1, WithMap(orderedmap.NewSortedMap()))
+	for _, key := range []string{"user:2", "b", "user:1", "a"} {
+		_, err := server.execute(context.Background(), types.NewAddCommand(key, key))
+		assert.Nil(t, err)
+	}
+
+	result, err := server.execute(context.Background(), types.NewGetAllCommand())
+	assert.Nil(t, err)
+	assert.Equal(t, "a : a\nb : b\nuser:1 : user:1\nuser:2 : user:2\n", result.String())
+
+	result, err = server.execute(context.Background(), types.NewGetRangeCommand("b", "user:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "b", Value: "b"}, {Key: "user:1", Value: "user:1"}}, result.Items)
+
+	result, err = server.execute(context.Background(), types.NewGetPrefixCommand("user:"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "user:1", Value: "user:1"}, {Key: "user:2", Value: "user:2"}}, result.Items)
+
+	_, err = server.execute(context.Background(), types.NewMoveToFrontCommand("b"))
+	assert.ErrorIs(t, err, orderedmap.ErrNotPositional)
+}
+
+func TestExecute_Snapshots(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_, err := server.execute(context.Background(), types.NewAddCommand(key, key))
+		assert.Nil(t, err)
+	}
+
+	result, err := server.execute(context.Background(), types.NewOpenSnapshotCommand())
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshot", result.Items[0].Key)
+	assert.Equal(t, "5", result.Items[1].Value)
+	id := result.Items[0].Value.(string)
+
+	// Writes after the snapshot was opened do not move the pages.
+	_, err = server.execute(context.Background(), types.NewDeleteCommand("a"))
+	assert.Nil(t, err)
+
+	result, err = server.execute(context.Background(), types.NewGetPageCommand(id, 0, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "a", Value: "a"}, {Key: "b", Value: "b"}}, result.Items)
+	result, err = server.execute(context.Background(), types.NewGetPageCommand(id, 4, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "e", Value: "e"}}, result.Items)
+
+	_, err = server.execute(context.Background(), types.NewCloseSnapshotCommand(id))
+	assert.Nil(t, err)
+	_, err = server.execute(context.Background(), types.NewGetPageCommand(id, 0, 2))
+	assert.ErrorIs(t, err, orderedmap.ErrSnapshotNotFound)
+}
+
+func TestExecute_SnapshotOwner(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+	a := withOrigin(context.Background(), origin{Client: "a"})
+	b := withOrigin(context.Background(), origin{Client: "b"})
+	_, err := server.execute(a, types.NewAddCommand("x", "1").WithNamespace("orders"))
+	assert.Nil(t, err)
+
+	result, err := server.execute(a, types.NewOpenSnapshotCommand().WithNamespace("orders"))
+	assert.Nil(t, err)
+	id := result.Items[0].Value.(string)
+
+	// Another client and another namespace can neither page nor close it.
+	_, err = server.execute(b, types.NewGetPageCommand(id, 0, 10).WithNamespace("orders"))
+	assert.ErrorIs(t, err, orderedmap.ErrSnapshotNotOwned)
+	_, err = server.execute(a, types.NewGetPageCommand(id, 0, 10).WithNamespace("users"))
+	assert.ErrorIs(t, err, orderedmap.ErrSnapshotNotOwned)
+	_, err = server.execute(b, types.NewCloseSnapshotCommand(id).WithNamespace("orders"))
+	assert.ErrorIs(t, err, orderedmap.ErrSnapshotNotOwned)
+	_, err = server.execute(a, types.NewCloseSnapshotCommand(id).WithNamespace("users"))
+	assert.ErrorIs(t, err, orderedmap.ErrSnapshotNotOwned)
+
+	result, err = server.execute(a, types.NewGetPageCommand(id, 0, 10).WithNamespace("orders"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "x", Value: "1"}}, result.Items)
+	_, err = server.execute(a, types.NewCloseSnapshotCommand(id).WithNamespace("orders"))
+	assert.Nil(t, err)
+}
+
+func receiveEvent(t *testing.T, messages <-chan string) changefeed.Event {
+	t.Helper()
+	select {
+	case message := <-messages:
+		var event changefeed.Event
+		assert.Nil(t, json.Unmarshal([]byte(message), &event))
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("No change event received")
+	}
+	return changefeed.Event{}
+}
+
+func TestExecute_Watch(t *testing.T) {
+	inTempDir(t)
+	feedQ := queue.NewMemQueue(10)
+	server := NewServer(nil, logger.NewConsoleLogger(), 1, WithChangeFeed(feedQ))
+	defer server.Stop()
+	messages, _ := feedQ.ReceiveMessage()
+
+	_, err := server.execute(context.Background(), types.NewAddCommand("user:1", "a"))
+	assert.Nil(t, err)
+	_, err = server.execute(context.Background(), types.NewWatchPrefixCommand("user:", 0))
+	assert.Nil(t, err)
+
+	server.processCommand("batch([addItem('user:1', 'b'), addItem('other', 'x'), deleteItem('user:1')])")
+	event := receiveEvent(t, messages)
+	assert.Equal(t, changefeed.Event{Seq: 2, Op: changefeed.OpSet, Key: "user:1", Old: "a", New: "b", Time: event.Time}, event)
+	event = receiveEvent(t, messages)
+	assert.Equal(t, changefeed.Event{Seq: 4, Op: changefeed.OpDelete, Key: "user:1", Old: "b", Time: event.Time}, event)
+
+	// Keys removed by expiry are reported as expire events.
+	_, err = server.execute(context.Background(), types.NewAddCommand("user:2", "c"))
+	assert.Nil(t, err)
+	receiveEvent(t, messages)
+	_, err = server.execute(context.Background(), types.NewExpireCommand("user:2", 10))
+	assert.Nil(t, err)
+	server.expireKeys(time.Now())
+	_, ok := server.orderedMap.Get("user:2")
+	assert.True(t, ok)
+	server.expireKeys(time.Now().Add(10 * time.Second))
+	_, ok = server.orderedMap.Get("user:2")
+	assert.False(t, ok)
+	event = receiveEvent(t, messages)
+	assert.Equal(t, changefeed.OpExpire, event.Op)
+	assert.Equal(t, "user:2", event.Key)
+
+	// A watch can resume from an earlier sequence number.
+	_, err = server.execute(context.Background(), types.NewWatchCommand("user:1", 2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), receiveEvent(t, messages).Seq)
+	assert.Equal(t, uint64(4), receiveEvent(t, messages).Seq)
+
+	_, err = server.execute(context.Background(), types.NewUnwatchCommand("user:1"))
+	assert.Nil(t, err)
+	_, err = server.execute(context.Background(), types.NewUnwatchCommand("user:1"))
+	assert.ErrorIs(t, err, ErrNotWatched)
+}
+
+func TestCommit_NoWatches(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+	_, err := server.execute(context.Background(), types.NewAddCommand("a", "1"))
+	assert.Nil(t, err)
+
+	// Changes are numbered but not retained without a feed queue.
+	assert.Equal(t, uint64(1), server.feed.Seq())
+	_, err = server.Subscribe(changefeed.Filter{Key: "a"}, 1)
+	assert.ErrorIs(t, err, changefeed.ErrSeqTooOld)
+
+	sub, err := server.Subscribe(changefeed.Filter{Key: "a"}, 0)
+	assert.Nil(t, err)
+	defer sub.Close()
+	_, err = server.execute(context.Background(), types.NewAddCommand("a", "2"))
+	assert.Nil(t, err)
+	event := <-sub.C
+	assert.Equal(t, uint64(2), event.Seq)
+	assert.Equal(t, "2", event.New)
+}
+
+func TestCommit_SubscribeWhileCommitting(t *testing.T) {
+	server := NewServer(nil, logger.NewConsoleLogger(), 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Microsecond):
+				_, err := server.execute(context.Background(), types.NewAddCommand("a", strconv.Itoa(i)))
+				assert.Nil(t, err)
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	// Subscriptions opened between commits that nobody watched get the
+	// next change instead of ErrSeqTooOld.
+	for i := 0; i < 200; i++ {
+		sub, err := server.Subscribe(changefeed.Filter{Key: "a"}, 0)
+		assert.Nil(t, err)
+		select {
+		case _, ok := <-sub.C:
+			assert.True(t, ok, "subscription closed: %v", sub.Err())
+		case <-time.After(time.Second):
+			t.Fatal("No change event received")
+		}
+		sub.Close()
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary := NewServer(nil, logger.NewConsoleLogger(), 1, WithReplicationLog(100))
+	_, err := primary.execute(context.Background(), types.NewAddCommand("a", "1"))
+	assert.Nil(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go primary.ServeReplication(ctx, ln)
+
+	replica := NewServer(nil, logger.NewConsoleLogger(), 1, WithReplicaOf(ln.Addr().String()))
+	defer replica.Stop()
+	replica.startReplica(ctx)
+
+	for _, command := range []types.Command{
+		types.NewAddCommand("b", "2"),
+		types.NewInsertBeforeCommand("a", "c", "3"),
+		types.NewMoveToBackCommand("a"),
+		types.NewIncrementCommand("b", 5),
+		types.NewExpireCommand("c", 10),
+	} {
+		_, err := primary.execute(context.Background(), command)
+		assert.Nil(t, err)
+	}
+	primary.expireKeys(time.Now().Add(10 * time.Second))
+
+	expected, _ := primary.orderedMap.GetAll()
+	assert.Eventually(t, func() bool {
+		keys, _ := replica.orderedMap.GetAll()
+		return assert.ObjectsAreEqual(expected, keys)
+	}, 2*time.Second, 5*time.Millisecond)
+	keys, values := replica.orderedMap.GetAll()
+	assert.Equal(t, []string{"b", "a"}, keys)
+	assert.Equal(t, []interface{}{"7", "1"}, values)
+
+	// Replicas serve reads but reject writes until they are promoted.
+	assert.True(t, replica.IsReplica())
+	result, err := replica.execute(context.Background(), types.NewGetCommand("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "b", Value: "7"}}, result.Items)
+	_, err = replica.execute(context.Background(), types.NewAddCommand("d", "4"))
+	assert.ErrorIs(t, err, ErrReadOnlyReplica)
+
+	assert.Nil(t, replica.Promote())
+	assert.False(t, replica.IsReplica())
+	_, err = replica.execute(context.Background(), types.NewAddCommand("d", "4"))
+	assert.Nil(t, err)
+	assert.ErrorIs(t, replica.Promote(), ErrNotReplica)
+}
+
+func TestReplication_SnapshotDeadlines(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The log keeps one entry, so the replica resyncs from a snapshot.
+	primary := NewServer(nil, logger.NewConsoleLogger(), 1, WithReplicationLog(1))
+	for _, command := range []types.Command{
+		types.NewAddCommand("a", "1"),
+		types.NewAddCommand("b", "2"),
+		types.NewExpireCommand("a", 10),
+	} {
+		_, err := primary.execute(context.Background(), command)
+		assert.Nil(t, err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go primary.ServeReplication(ctx, ln)
+
+	replica := NewServer(nil, logger.NewConsoleLogger(), 1, WithReplicaOf(ln.Addr().String()))
+	defer replica.Stop()
+	replica.startReplica(ctx)
+	assert.Eventually(t, func() bool {
+		keys, _ := replica.orderedMap.GetAll()
+		return len(keys) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// The promoted replica expires the key when the primary would have.
+	assert.Nil(t, replica.Promote())
+	replica.expireKeys(time.Now().Add(5 * time.Second))
+	_, ok := replica.orderedMap.Get("a")
+	assert.True(t, ok)
+	replica.expireKeys(time.Now().Add(10 * time.Second))
+	keys, _ := replica.orderedMap.GetAll()
+	assert.Equal(t, []string{"b"}, keys)
+}
+
+func TestLead(t *testing.T) {
+	store := election.NewMemStore()
+	memQ := queue.NewMemQueue(10)
+	ttl := 60 * time.Millisecond
+
+	start := func(ctx context.Context, id string) (*Server, <-chan error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		s := NewServer(memQ, logger.NewConsoleLogger(), 1, WithReplicationLog(100))
+		go s.ServeReplication(ctx, ln)
+		elector := election.NewElector(store, id, ln.Addr().String(), ttl, logger.NewConsoleLogger())
+		done := make(chan error, 1)
+		go func() { done <- s.Lead(ctx, elector) }()
+		return s, done
+	}
+	leads := func(s *Server) func() bool {
+		return func() bool { return !s.IsReplica() }
+	}
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	a, doneA := start(ctxA, "a")
+	assert.Eventually(t, leads(a), 2*time.Second, 5*time.Millisecond)
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	b, doneB := start(ctxB, "b")
+
+	// Only the leader consumes commands; the standby replicates them.
+	assert.Nil(t, memQ.SendMessage("addItem('a', '1')"))
+	assert.Eventually(t, func() bool {
+		_, ok := b.orderedMap.Get("a")
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.True(t, b.IsReplica())
+	_, err := b.execute(context.Background(), types.NewAddCommand("b", "2"))
+	assert.ErrorIs(t, err, ErrReadOnlyReplica)
+
+	// The standby takes over with the replicated data once the leader stops.
+	stopA()
+	assert.Nil(t, <-doneA)
+	assert.Eventually(t, leads(b), 2*time.Second, 5*time.Millisecond)
+	assert.Nil(t, memQ.SendMessage("addItem('b', '2')"))
+	assert.Eventually(t, func() bool {
+		_, ok := b.orderedMap.Get("b")
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+	keys, _ := b.orderedMap.GetAll()
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	// The old leader's token is fenced off.
+	assert.ErrorIs(t, a.checkFence(), election.ErrLeaseLost)
+	assert.Nil(t, b.checkFence())
+	stopB()
+	assert.Nil(t, <-doneB)
+}
+
+func TestLead_TakeoverWithoutReplication(t *testing.T) {
+	store := election.NewMemStore()
+	_, err := store.Acquire("a", "", 30*time.Millisecond)
+	assert.Nil(t, err)
+
+	// The previous leader published no replication address, so the standby
+	// takes over without its data and says so.
+	log := logger.NewTestLogger()
+	s := NewServer(queue.NewMemQueue(10), log, 1)
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Lead(ctx, election.NewElector(store, "b", "", 30*time.Millisecond, log)) }()
+	assert.Eventually(t, func() bool { return !s.IsReplica() }, 2*time.Second, 5*time.Millisecond)
+	entry, ok := log.Find("Took over the lease without replicating the previous leader; its data is not served")
+	assert.True(t, ok)
+	assert.Equal(t, logger.LevelError, entry.Level)
+	stop()
+	assert.Nil(t, <-done)
+}
+
+func TestExecute_Fenced(t *testing.T) {
+	store := election.NewMemStore()
+	_, err := store.Acquire("b", "", time.Minute)
+	assert.Nil(t, err)
+	s := NewServer(nil, logger.NewConsoleLogger(), 1)
+	s.elector = election.NewElector(store, "a", "", time.Minute, logger.NewConsoleLogger())
+	s.token.Store(1)
+
+	// A leader that lost its lease rolls its writes back.
+	_, err = s.execute(context.Background(), types.NewAddCommand("a", "1"))
+	assert.ErrorIs(t, err, election.ErrLeaseLost)
+	_, err = s.execute(context.Background(), types.NewBatchCommand(types.NewAddCommand("b", "2")))
+	assert.ErrorIs(t, err, election.ErrLeaseLost)
+	assert.Equal(t, 0, s.orderedMap.Len())
+}
+
+func TestProcessMessage_Tracing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans")
+	exporter, err := tracing.NewFileExporter(path)
+	assert.Nil(t, err)
+	tracer := tracing.NewTracer("server", exporter, nil)
+	s := NewServer(nil, logger.NewConsoleLogger(), 1, WithTracer(tracer))
+
+	parent := tracing.SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}, Sampled: true}
+	s.processMessage(queue.Message{
+		Body:    "addItem('a', 'b')",
+		Headers: map[string]string{tracing.TraceparentHeader: parent.Traceparent()},
+	}, s.log)
+	assert.Nil(t, tracer.Close())
+
+	bt, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	spans := make(map[string]tracing.SpanData)
+	for _, line := range strings.Split(strings.TrimSpace(string(bt)), "\n") {
+		var span tracing.SpanData
+		assert.Nil(t, json.Unmarshal([]byte(line), &span))
+		spans[span.Name] = span
+	}
+	assert.Len(t, spans, 4)
+
+	// The process span continues the client's trace and parents the others.
+	process := spans["process"]
+	assert.Equal(t, "01000000000000000000000000000000", process.TraceID)
+	assert.Equal(t, "0200000000000000", process.ParentSpanID)
+	assert.Equal(t, "addItem", process.Attributes["command.type"])
+	for _, name := range []string{"parse", "apply", "write"} {
+		assert.Equal(t, process.TraceID, spans[name].TraceID, name)
+		assert.Equal(t, process.SpanID, spans[name].ParentSpanID, name)
+	}
+}
+
+func TestProcessMessage_Logging(t *testing.T) {
+	log := logger.NewTestLogger()
+	s := NewServer(nil, log, 1)
+	s.processCommand("addItem('a', 'b')")
+	s.dispatch(queue.Message{ID: "m1", Body: "increment('a', 1)"})
+
+	var entry logger.Entry
+	assert.Eventually(t, func() bool {
+		var ok bool
+		entry, ok = log.Find("Error executing command")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, logger.LevelWarn, entry.Level)
+	assert.Equal(t, "server", entry.Fields[logger.KeyComponent])
+	assert.Equal(t, "m1", entry.Fields[logger.KeyMessageID])
+	assert.Equal(t, int64(0), entry.Fields[logger.KeyWorker])
+	assert.Equal(t, "increment", entry.Fields[logger.KeyCommandType])
+	assert.Equal(t, "a", entry.Fields[logger.KeyKey])
+
+	// Messages without a broker ID are numbered by the server.
+	entry, ok := log.Find("Processed command")
+	assert.True(t, ok)
+	assert.Equal(t, logger.LevelDebug, entry.Level)
+	assert.Equal(t, "1", entry.Fields[logger.KeyMessageID])
+}
+
+func TestAudit(t *testing.T) {
+	inTempDir(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path, 0, 0)
+	assert.Nil(t, err)
+	s := NewServer(nil, logger.NewConsoleLogger(), 1, WithAuditLog(al))
+
+	headers := map[string]string{queue.ClientIDHeader: "billing"}
+	s.processMessage(queue.Message{ID: "m1", Body: "addItem('a', '1')", Headers: headers}, s.log)
+	s.processMessage(queue.Message{ID: "m2", Body: "batch([increment('a', 2), addItem('b', 'x')])", Headers: headers}, s.log)
+	s.processMessage(queue.Message{ID: "m3", Body: "getItem('a')", Headers: headers}, s.log)
+
+	report, err := audit.Verify(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.Records)
+
+	bt, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var records []audit.Record
+	for _, line := range strings.Split(strings.TrimSpace(string(bt)), "\n") {
+		var r audit.Record
+		assert.Nil(t, json.Unmarshal([]byte(line), &r))
+		records = append(records, r)
+	}
+	assert.Equal(t, "m1", records[0].MessageID)
+	assert.Equal(t, "billing", records[0].Client)
+	assert.Equal(t, "addItem", records[0].Command)
+	assert.Equal(t, "", records[0].OldHash)
+	assert.Equal(t, audit.HashValue("1"), records[0].NewHash)
+	assert.Equal(t, "m2", records[1].MessageID)
+	assert.Equal(t, "batch", records[1].Command)
+	assert.Equal(t, audit.HashValue("1"), records[1].OldHash)
+	assert.Equal(t, audit.HashValue("3"), records[1].NewHash)
+	assert.Equal(t, "b", records[2].Key)
+
+	// A change that cannot be audited is not applied.
+	assert.Nil(t, al.Close())
+	_, err = s.execute(context.Background(), types.NewDeleteCommand("a"))
+	assert.ErrorIs(t, err, ErrAudit)
+	value, ok := s.orderedMap.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "3", value)
+}
+
+func TestProcessMessage_Signed(t *testing.T) {
+	key, err := auth.GenerateKey("k1", "billing", auth.HMACSHA256)
+	assert.Nil(t, err)
+	keys, err := auth.NewKeyring(key)
+	assert.Nil(t, err)
+	signer, err := auth.NewSigner(keys, "")
+	assert.Nil(t, err)
+	log := logger.NewTestLogger()
+	s := NewServer(nil, log, 1, WithVerifier(auth.NewVerifier(keys, auth.DefaultWindow)))
+
+	headers := make(map[string]string)
+	assert.Nil(t, signer.Sign("addItem('a', '1')", headers))
+	signed := queue.Message{Body: "addItem('a', '1')", Headers: headers}
+	s.processMessage(signed, s.log)
+	value, ok := s.orderedMap.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+
+	// Replays and unsigned messages are rejected before they are parsed.
+	assert.Nil(t, s.orderedMap.Update(func(tx *orderedmap.Tx) error {
+		_, err := tx.Delete("a")
+		return err
+	}))
+	s.processMessage(signed, s.log)
+	s.processCommand("addItem('b', '2')")
+	assert.Equal(t, 0, s.orderedMap.Len())
+
+	var rejected []error
+	for _, entry := range log.Entries() {
+		if entry.Message == "Rejected message" {
+			rejected = append(rejected, entry.Fields[logger.KeyError].(error))
+		}
+	}
+	assert.Len(t, rejected, 2)
+	assert.ErrorIs(t, rejected[0], auth.ErrReplay)
+	assert.ErrorIs(t, rejected[1], auth.ErrUnsigned)
+	assert.Equal(t, uint64(2), s.failed.Load())
+}
+
+func TestProcessMessage_Policy(t *testing.T) {
+	p, err := policy.New(policy.File{Rules: []policy.Rule{
+		{Clients: []string{"reporting"}, Commands: []string{"getItem", "getAllItems"}},
+		{Clients: []string{"teamA"}, Keys: []string{"teamA:*"}},
+	}})
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	al, err := audit.Open(path, 0, 0)
+	assert.Nil(t, err)
+	deadLetters := queue.NewMemQueue(10)
+	s := NewServer(nil, logger.NewConsoleLogger(), 1, WithPolicy(p), WithDeadLetter(deadLetters), WithAuditLog(al))
+
+	send := func(client, body string) {
+		s.processMessage(queue.Message{Body: body, Headers: map[string]string{queue.ClientIDHeader: client}}, s.log)
+	}
+	send("teamA", "addItem('teamA:1', 'a')")
+	send("teamA", "batch([addItem('teamA:2', 'b'), addItem('teamB:1', 'c')])")
+	send("reporting", "deleteItem('teamA:1')")
+	keys, _ := s.orderedMap.GetAll()
+	assert.Equal(t, []string{"teamA:1"}, keys)
+
+	messages, _ := deadLetters.ReceiveMessage()
+	for _, expected := range []string{"batch([addItem('teamA:2', 'b'), addItem('teamB:1', 'c')])", "deleteItem('teamA:1')"} {
+		message := queue.Unwrap(<-messages)
+		assert.Equal(t, expected, message.Body)
+		assert.Contains(t, message.Headers[DeadLetterReasonHeader], policy.ErrDenied.Error())
+	}
+
+	bt, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var denied []string
+	for _, line := range strings.Split(strings.TrimSpace(string(bt)), "\n") {
+		var r audit.Record
+		assert.Nil(t, json.Unmarshal([]byte(line), &r))
+		if r.Op == "denied" {
+			denied = append(denied, r.Client+" "+r.Command+" "+r.Key)
+		}
+	}
+	assert.Equal(t, []string{"teamA addItem teamA:2", "teamA addItem teamB:1", "reporting deleteItem teamA:1"}, denied)
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer(nil, logger.NewConsoleLogger(), 1, WithReplicationLog(100), WithNamespaceLimits(2, 2))
+	run := func(message string) (Result, error) {
+		command, err := types.ParseCommand(message)
+		assert.Nil(t, err)
+		return server.execute(ctx, command)
+	}
+
+	// Namespaces are created by the first write and hold their own items.
+	_, err := run("addItem('a', 'default')")
+	assert.Nil(t, err)
+	_, err = run("addItem@orders('a', 'order')")
+	assert.Nil(t, err)
+	result, err := run("getItem@orders('a')")
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "a", Value: "order"}}, result.Items)
+	result, err = run("getAllItems@missing()")
+	assert.Nil(t, err)
+	assert.Empty(t, result.Items)
+
+	// Quotas reject writes that add items, and roll back the transaction.
+	_, err = run("addItem@orders('b', '2')")
+	assert.Nil(t, err)
+	_, err = run("batch@orders([deleteItem('a'), addItem('c', '3'), addItem('d', '4')])")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	_, err = run("addItem@orders('a', 'changed')")
+	assert.Nil(t, err)
+	_, err = run("createNamespace('orders', '0')")
+	assert.Nil(t, err)
+	_, err = run("addItem@orders('c', '3')")
+	assert.Nil(t, err)
+
+	_, err = run("createNamespace('users')")
+	assert.Nil(t, err)
+	_, err = run("addItem@third('a', 'b')")
+	assert.ErrorIs(t, err, ErrTooManyNamespaces)
+	result, err = run("listNamespaces()")
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "orders", Value: "3"}, {Key: "users", Value: "0"}}, result.Items)
+
+	// A replica receives every namespace.
+	items, _ := server.replicationSnapshot()
+	assert.Equal(t, []replication.Item{
+		{Key: "a", Value: "default"},
+		{Namespace: "orders", Key: "a", Value: "changed"},
+		{Namespace: "orders", Key: "b", Value: "2"},
+		{Namespace: "orders", Key: "c", Value: "3"},
+	}, items)
+	replica := NewServer(nil, logger.NewConsoleLogger(), 1, WithReplicaOf("unused"))
+	assert.Nil(t, replicaApplier{replica}.ApplySnapshot(items))
+	assert.Nil(t, replicaApplier{replica}.ApplyEntry("deleteItem@orders('b')"))
+	result, err = replica.execute(ctx, types.NewGetAllCommand().WithNamespace("orders"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Item{{Key: "a", Value: "changed"}, {Key: "c", Value: "3"}}, result.Items)
+
+	_, err = run("clearNamespace('orders')")
+	assert.Nil(t, err)
+	result, err = run("getAllItems@orders()")
+	assert.Nil(t, err)
+	assert.Empty(t, result.Items)
+	_, err = run("dropNamespace('orders')")
+	assert.Nil(t, err)
+	_, err = run("dropNamespace('orders')")
+	assert.ErrorIs(t, err, ErrNamespaceNotFound)
+	assert.Equal(t, 1, server.Stats().Namespaces)
+	value, _ := server.orderedMap.Get("a")
+	assert.Equal(t, "default", value)
 }
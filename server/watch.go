@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"command-queue/internal/types"
+	"command-queue/internal/util/changefeed"
+	"command-queue/internal/util/logger"
+	"command-queue/internal/util/orderedmap"
+)
+
+const (
+	// defaultFeedRetention is the number of change events kept for watches
+	// that resume from a sequence number.
+	defaultFeedRetention = 10000
+	// feedRetryDelay is the first delay before an event is sent to the
+	// change feed queue again; it doubles up to feedMaxRetryDelay.
+	feedRetryDelay    = 100 * time.Millisecond
+	feedMaxRetryDelay = 10 * time.Second
+)
+
+var (
+	// ErrNoFeedQueue is returned for watches on a server without a change feed queue.
+	ErrNoFeedQueue = errors.New("no change feed queue configured")
+	// ErrNotWatched is returned when removing a watch that does not exist.
+	ErrNotWatched = errors.New("not watched")
+)
+
+// Subscribe returns a subscription to the changes selected by filter,
+// starting at sequence number from, or with the next change if from is 0.
+func (s *Server) Subscribe(filter changefeed.Filter, from uint64) (*changefeed.Subscription, error) {
+	return s.feed.Subscribe(filter, from)
+}
+
+// commit publishes the changes of a transaction to the change feed. It is
+// called while the map is still locked, so events are numbered in the order
+// the transactions were applied. Deleted keys are reported with deleteOp.
+// Without a change feed queue or subscriptions the changes are only
+// numbered, so that servers without watches do not retain events.
+func (s *Server) commit(namespace string, changes []orderedmap.Change, deleteOp changefeed.Op) {
+	if len(changes) == 0 {
+		return
+	}
+	now := time.Now()
+	events := make([]changefeed.Event, 0, len(changes))
+	for _, change := range changes {
+		event := changefeed.Event{Op: changeOp(change, deleteOp), Namespace: namespace, Key: change.Key, Old: change.Old, New: change.New, Time: now}
+		if change.Op == orderedmap.ChangeDelete {
+			s.clearDeadline(namespace, change.Key)
+		}
+		events = append(events, event)
+	}
+	s.feed.PublishOrSkip(s.feedQueue != nil, events...)
+}
+
+// changeOp returns the change feed operation of change. Deleted keys are
+// reported with deleteOp.
+func changeOp(change orderedmap.Change, deleteOp changefeed.Op) changefeed.Op {
+	switch change.Op {
+	case orderedmap.ChangeSet:
+		return changefeed.OpSet
+	case orderedmap.ChangeMove:
+		return changefeed.OpMove
+	}
+	return deleteOp
+}
+
+// executeWatchCommand adds and removes watches. Every watch is a change feed
+// subscription whose events are sent to the change feed queue.
+func (s *Server) executeWatchCommand(command types.Command) (Result, error) {
+	result := Result{Command: command}
+	filter := changefeed.Filter{
+		Namespace: command.Namespace(),
+		Key:       command.Key(),
+		Prefix:    command.Type == types.WatchPrefix || command.Type == types.UnwatchPrefix,
+	}
+
+	switch command.Type {
+	case types.Watch, types.WatchPrefix:
+		if s.feedQueue == nil {
+			return Result{}, ErrNoFeedQueue
+		}
+		sub, err := s.feed.Subscribe(filter, command.From())
+		if err != nil {
+			return Result{}, err
+		}
+		s.watchMutex.Lock()
+		if old, ok := s.watches[filter]; ok {
+			old.Close()
+		}
+		s.watches[filter] = sub
+		s.watchMutex.Unlock()
+		go s.forward(filter, sub)
+		result.Items = []Item{{Key: "seq", Value: strconv.FormatUint(s.feed.Seq(), 10)}}
+	case types.Unwatch, types.UnwatchPrefix:
+		s.watchMutex.Lock()
+		defer s.watchMutex.Unlock()
+		sub, ok := s.watches[filter]
+		if !ok {
+			return Result{}, ErrNotWatched
+		}
+		sub.Close()
+		delete(s.watches, filter)
+	}
+	return result, nil
+}
+
+// forward sends the events of a watch to the change feed queue as JSON.
+// Failed sends are retried until they succeed, so every event is delivered
+// at least once; consumers can drop duplicates by sequence number.
+func (s *Server) forward(filter changefeed.Filter, sub *changefeed.Subscription) {
+	for event := range sub.C {
+		data, err := json.Marshal(event)
+		if err != nil {
+			s.log.Error("Error encoding change event", "seq", event.Seq, logger.KeyError, err)
+			continue
+		}
+		delay := feedRetryDelay
+		for {
+			err := s.feedQueue.SendMessage(string(data))
+			if err == nil {
+				break
+			}
+			s.log.Warn("Error sending change event, retrying", "seq", event.Seq, "delay", delay, logger.KeyError, err)
+			select {
+			case <-time.After(delay):
+			case <-s.stopped:
+				return
+			}
+			delay = min(2*delay, feedMaxRetryDelay)
+		}
+	}
+	if err := sub.Err(); err != nil {
+		s.log.Warn("Watch stopped", logger.KeyKey, filter.Key, logger.KeyError, err)
+	}
+
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	if s.watches[filter] == sub {
+		delete(s.watches, filter)
+	}
+}
+
+// closeWatches stops every watch.
+func (s *Server) closeWatches() {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	for filter, sub := range s.watches {
+		sub.Close()
+		delete(s.watches, filter)
+	}
+}